@@ -0,0 +1,40 @@
+package metering
+
+import "github.com/yyh1102/go-wasm-metering/toolkit"
+
+// checkAdmission refuses the modules that can't be run in the environment described by the options.
+func (m *Metering) checkAdmission(module []toolkit.JSON) error {
+	memories := 0
+	for _, section := range module {
+		sectionName, exist := section["name"]
+		if !exist {
+			continue
+		}
+		switch sectionName.(string) {
+		case "import":
+			entries, _ := section["entries"].([]toolkit.ImportEntry)
+			for _, entry := range entries {
+				if entry.Kind != "memory" {
+					continue
+				}
+				memories += 1
+				if m.opts.Deterministic && entry.Type.(toolkit.MemLimits).IsShared() {
+					return ErrSharedMemory
+				}
+			}
+		case "memory":
+			entries, _ := section["entries"].([]toolkit.MemLimits)
+			memories += len(entries)
+			for _, entry := range entries {
+				if m.opts.Deterministic && entry.IsShared() {
+					return ErrSharedMemory
+				}
+			}
+		}
+	}
+
+	if m.opts.MaxMemories > 0 && memories > m.opts.MaxMemories {
+		return ErrTooManyMemories
+	}
+	return nil
+}
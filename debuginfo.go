@@ -0,0 +1,34 @@
+package metering
+
+import (
+	"fmt"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// DebugInfo tells what metering does with the DWARF sections of a module, their addresses are offsets in
+// the code that the metering moves.
+type DebugInfo int
+
+const (
+	DebugInfoKeep    DebugInfo = iota // keep the sections as they are.
+	DebugInfoStrip                    // remove the sections, they are reported in Result.StrippedSections.
+	DebugInfoRewrite                  // map the addresses of the sections to the metered code.
+)
+
+// processDebugInfo strips or rewrites the DWARF sections of the metered module.
+func (m *Metering) processDebugInfo(module []toolkit.JSON, codeMap *toolkit.CodeMap) ([]toolkit.JSON, error) {
+	switch m.opts.DebugInfo {
+	case DebugInfoStrip:
+		module, m.strippedSections = toolkit.StripDWARF(module)
+	case DebugInfoRewrite:
+		// the linker maps the addresses of an object file through its relocations.
+		if toolkit.IsRelocatable(module) {
+			return module, nil
+		}
+		if err := toolkit.RewriteDWARF(module, codeMap); err != nil {
+			return nil, fmt.Errorf("debug info: %v", err)
+		}
+	}
+	return module, nil
+}
@@ -81,6 +81,103 @@ var defaultCostTable = toolkit.JSON{
 			"drop":           120,
 			"select":         120,
 			"unreachable":    1,
+
+			// tail calls.
+			"return_call":          90,
+			"return_call_indirect": 10000,
+
+			// bulk memory operators, priced by their full name.
+			"memory.init": 10000,
+			"memory.copy": 10000,
+			"memory.fill": 10000,
+			"data.drop":   90,
+			"table.init":  10000,
+			"table.copy":  10000,
+			"elem.drop":   90,
+
+			// exception handling.
+			"try":       1,
+			"try_table": 1,
+			"catch":     90,
+			"catch_all": 90,
+			"delegate":  90,
+			"throw":     10000,
+			"throw_ref": 10000,
+			"rethrow":   10000,
+
+			// atomic memory accesses.
+			"atomic.load":            180,
+			"atomic.load8_u":         180,
+			"atomic.load16_u":        180,
+			"atomic.load32_u":        180,
+			"atomic.store":           180,
+			"atomic.store8":          180,
+			"atomic.store16":         180,
+			"atomic.store32":         180,
+			"atomic.rmw.add":         240,
+			"atomic.rmw8.add_u":      240,
+			"atomic.rmw16.add_u":     240,
+			"atomic.rmw32.add_u":     240,
+			"atomic.rmw.sub":         240,
+			"atomic.rmw8.sub_u":      240,
+			"atomic.rmw16.sub_u":     240,
+			"atomic.rmw32.sub_u":     240,
+			"atomic.rmw.and":         240,
+			"atomic.rmw8.and_u":      240,
+			"atomic.rmw16.and_u":     240,
+			"atomic.rmw32.and_u":     240,
+			"atomic.rmw.or":          240,
+			"atomic.rmw8.or_u":       240,
+			"atomic.rmw16.or_u":      240,
+			"atomic.rmw32.or_u":      240,
+			"atomic.rmw.xor":         240,
+			"atomic.rmw8.xor_u":      240,
+			"atomic.rmw16.xor_u":     240,
+			"atomic.rmw32.xor_u":     240,
+			"atomic.rmw.xchg":        240,
+			"atomic.rmw8.xchg_u":     240,
+			"atomic.rmw16.xchg_u":    240,
+			"atomic.rmw32.xchg_u":    240,
+			"atomic.rmw.cmpxchg":     300,
+			"atomic.rmw8.cmpxchg_u":  300,
+			"atomic.rmw16.cmpxchg_u": 300,
+			"atomic.rmw32.cmpxchg_u": 300,
+			"atomic.notify":          10000,
+			"atomic.wait32":          10000,
+			"atomic.wait64":          10000,
+			"fence":                  90,
+
+			// typed function references and garbage collection, priced by their full name.
+			"call_ref":           10000,
+			"return_call_ref":    10000,
+			"br_on_null":         90,
+			"br_on_non_null":     90,
+			"br_on_cast":         120,
+			"br_on_cast_fail":    120,
+			"ref.test":           120,
+			"ref.test_null":      120,
+			"ref.cast":           120,
+			"ref.cast_null":      120,
+			"struct.new":         10000,
+			"struct.new_default": 10000,
+			"struct.get":         120,
+			"struct.get_s":       120,
+			"struct.get_u":       120,
+			"struct.set":         120,
+			"array.new":          10000,
+			"array.new_default":  10000,
+			"array.new_fixed":    10000,
+			"array.new_data":     10000,
+			"array.new_elem":     10000,
+			"array.get":          120,
+			"array.get_s":        120,
+			"array.get_u":        120,
+			"array.set":          120,
+			"array.len":          120,
+			"array.fill":         10000,
+			"array.copy":         10000,
+			"array.init_data":    10000,
+			"array.init_elem":    10000,
 		},
 	},
 	"data": 0,
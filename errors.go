@@ -4,4 +4,8 @@ import "errors"
 
 var (
 	ErrImportMeterFunc = errors.New("importing metering function is not allowed")
+	ErrSharedMemory    = errors.New("shared memory is not allowed in deterministic mode")
+	ErrTooManyMemories = errors.New("the module declares more memories than allowed")
+
+	ErrDeadCodeDebugInfo = errors.New("the debug info can't be rewritten once the dead code is eliminated")
 )
@@ -1,10 +1,9 @@
 package metering
 
 import (
-	"fmt"
 	"github.com/yyh1102/go-wasm-metering/toolkit"
+	"github.com/yyh1102/go-wasm-metering/toolkit/pass"
 	"reflect"
-	"strconv"
 )
 
 const (
@@ -25,36 +24,144 @@ var (
 		"else":        {},
 		"return":      {},
 		"loop":        {},
+
+		// tail calls leave the function.
+		"return_call":          {},
+		"return_call_indirect": {},
+
+		// exception handling.
+		"try":       {},
+		"try_table": {},
+		"catch":     {},
+		"catch_all": {},
+		"throw":     {},
+		"throw_ref": {},
+		"rethrow":   {},
+		"delegate":  {},
+
+		// typed function references and garbage collection.
+		"return_call_ref": {},
+		"br_on_null":      {},
+		"br_on_non_null":  {},
+		"br_on_cast":      {},
+		"br_on_cast_fail": {},
 	}
 )
 
 // MeterWASM injects metering into WebAssembly binary code.
 // This func is the real exported function used by outer callers.
 func MeterWASM(wasm []byte, opts *Options) ([]byte, uint64, error) {
+	result, err := Meter(wasm, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result.Wasm, result.GasCost, nil
+}
+
+// Result is the outcome of Meter.
+type Result struct {
+	Wasm             []byte   // the metered module.
+	GasCost          uint64   // the cost of the locals, the types and the code of the module.
+	StrippedSections []string // the names of the custom sections removed with Options.Strip and of the debug sections removed with DebugInfoStrip.
+	StrippedExports  []string // the names of the exports removed with Options.Strip.
+	StrippedBytes    int      // the size Options.Strip saved.
+
+	// BinaryMap maps the offsets of the module to the metered one, i.e. to rewrite its source map (see toolkit.RewriteSourceMap),
+	// and back, i.e. to locate a trap of the metered module in the module.
+	BinaryMap *toolkit.BinaryMap
+	// Functions tells where the operators of every function ended up and where the metering is injected, the offsets
+	// are the ones of the payload of the code section.
+	Functions []toolkit.FuncMap
+}
+
+// Meter injects metering into WebAssembly binary code like MeterWASM and reports what it did to the module.
+func Meter(wasm []byte, opts *Options) (*Result, error) {
 	module := toolkit.Wasm2Json(wasm)
 	if opts == nil {
 		opts = &Options{}
 	}
 	metering, err := newMetring(*opts)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	manager := pass.NewManager()
+	var strip *pass.Strip
+	if opts.Strip != nil {
+		strip = &pass.Strip{Sections: opts.Strip.Sections, Exports: opts.Strip.Exports}
+		manager.Add(strip)
+	}
+	if opts.EliminateDeadCode {
+		manager.Add(&pass.DeadCode{})
+	}
+	if len(manager.Passes()) > 0 {
+		if module, err = manager.Run(module); err != nil {
+			return nil, err
+		}
+		// stripping leaves the code as it is, the offsets stay the ones of the module.
+		if opts.EliminateDeadCode {
+			wasm = toolkit.Json2Wasm(module)
+		}
 	}
-	module, gasCost, err := metering.meterJSON(module)
+	module, err = pass.NewManager(metering).Run(module)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
+	}
+	meteredWasm := toolkit.Json2Wasm(module)
+	result := &Result{
+		Wasm:             meteredWasm,
+		GasCost:          metering.gasCost,
+		StrippedSections: metering.strippedSections,
+		BinaryMap:        toolkit.NewBinaryMap(wasm, meteredWasm, metering.codeMap),
+		Functions:        metering.codeMap.Funcs(),
+	}
+	if strip != nil {
+		result.StrippedSections = append(strip.StrippedSections, result.StrippedSections...)
+		result.StrippedExports = strip.StrippedExports
+		result.StrippedBytes = strip.Saved
 	}
-	return toolkit.Json2Wasm(module), gasCost, nil
+	return result, nil
 }
 
 type Options struct {
-	CostTable toolkit.JSON // path of cost table file.
-	ModuleStr string       // the import string for metering function.
-	FieldStr  string       // the field string for the metering function.
-	MeterType string       // the register type that is used to meter. Can be `i64`, `i32`, `f64`, `f32`.
+	CostTable     toolkit.JSON // path of cost table file.
+	ModuleStr     string       // the import string for metering function.
+	FieldStr      string       // the field string for the metering function.
+	MeterType     string       // the register type that is used to meter. Can be `i64`, `i32`, `f64`, `f32`.
+	Deterministic bool         // refuse the modules that may behave non-deterministically, i.e. with shared memories.
+	MaxMemories   int          // the maximum number of memories, imported or defined, a module can declare. 0 means no limit.
+	DebugInfo     DebugInfo    // what to do with the DWARF sections, their addresses are stale once the code is metered.
+	SourceMapURL  string       // the URL of the source map of the metered module, the one of the module is kept if it's empty.
+
+	// EliminateDeadCode removes the functions, the globals, the types and the imports the module can't use before metering it
+	// (see pass.DeadCode). The offsets of Result.BinaryMap and Result.Functions are then the ones of the module without its dead code.
+	EliminateDeadCode bool
+	// Strip removes the custom sections and the exports the module doesn't need before metering it, and before its dead code is
+	// eliminated (see pass.Strip). Only its Sections and Exports are used, what it removed is reported in Result. The code is left as
+	// it is, the offsets of Result.BinaryMap stay the ones of the module.
+	Strip *pass.Strip
 }
 
+// Metering is the pass that meters a module (see pass.Pass).
 type Metering struct {
 	opts Options
+
+	gasCost          uint64           // the cost of the last metered module.
+	strippedSections []string         // the debug sections removed from the last metered module.
+	codeMap          *toolkit.CodeMap // maps the code of the last metered module to the metered code.
+}
+
+func (m *Metering) Name() string {
+	return "metering"
+}
+
+// Run meters a module, its cost is kept for Meter.
+func (m *Metering) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	module, gasCost, err := m.meterJSON(module)
+	if err != nil {
+		return nil, err
+	}
+	m.gasCost = gasCost
+	return module, nil
 }
 
 func newMetring(opts Options) (*Metering, error) {
@@ -75,6 +182,11 @@ func newMetring(opts Options) (*Metering, error) {
 		opts.MeterType = defaultMeterType
 	}
 
+	// the DWARF addresses of the removed functions can't be mapped.
+	if opts.EliminateDeadCode && opts.DebugInfo == DebugInfoRewrite {
+		return nil, ErrDeadCodeDebugInfo
+	}
+
 	return &Metering{
 		opts: opts,
 	}, nil
@@ -82,47 +194,25 @@ func newMetring(opts Options) (*Metering, error) {
 
 // meterJSON injects metering into a JSON output of Wasm2Json.
 func (m *Metering) meterJSON(module []toolkit.JSON) ([]toolkit.JSON, uint64, error) {
-	// find section.
-	findSection := func(module []toolkit.JSON, sectionName string) toolkit.JSON {
-		for _, section := range module {
-			if name, exist := section["name"]; exist {
-				if name.(string) == sectionName {
-					return section
-				}
-			}
-		}
-		return nil
+	if err := m.checkAdmission(module); err != nil {
+		return nil, 0, err
 	}
 
-	// create section.
-	createSection := func(module []toolkit.JSON, sectionName string) []toolkit.JSON {
-		newSectionId := toolkit.J2W_SECTION_IDS[sectionName]
-		for i, section := range module {
-			name, exist := section["name"]
-			if exist {
-				secId, exist := toolkit.J2W_SECTION_IDS[name.(string)]
-				//fmt.Printf("%v %v %v\n", name, secId, exist)
-				if exist && secId > 0 && newSectionId < secId {
-					rest := append([]toolkit.JSON{}, module[i:]...)
-					// insert the section at pos `i`
-					module = append(module[:i], toolkit.JSON{
-						"name": sectionName,
-					})
-					module = append(module, rest...)
-					break
-				}
+	// the code before metering, to map its offsets to the metered code.
+	var oldCode []toolkit.CodeBody
+	for _, section := range module {
+		if section["name"] == "code" {
+			for _, entry := range section["entries"].([]toolkit.CodeBody) {
+				entry.Code = append([]toolkit.OP{}, entry.Code...)
+				oldCode = append(oldCode, entry)
 			}
 		}
-		return module
 	}
-	//fmt.Printf("%#v\n", module)
 
-	// add necessary `type` and `import` sections if and only if they don't exist.
-	if findSection(module, "type") == nil {
-		module = createSection(module, "type")
-	}
-	if findSection(module, "import") == nil {
-		module = createSection(module, "import")
+	// a relocatable object file needs its linking and relocation sections rewritten.
+	var relocator *meterRelocator
+	if toolkit.IsRelocatable(module) {
+		relocator = newMeterRelocator(module)
 	}
 
 	importEntry := toolkit.ImportEntry{
@@ -131,111 +221,72 @@ func (m *Metering) meterJSON(module []toolkit.JSON) ([]toolkit.JSON, uint64, err
 		Kind:      "function",
 	}
 
-	importType := toolkit.TypeEntry{
-		Form:   "func",
-		Params: []string{m.opts.MeterType},
-	}
-
 	var (
-		typeModule     toolkit.JSON
-		functionModule toolkit.JSON
-		funcIndex      int
-		newModule      = make([]toolkit.JSON, len(module))
-		gasCost        uint64
+		newModule = make([]toolkit.JSON, len(module))
+		gasCost   uint64
 	)
 
 	copy(newModule, module)
 	//fmt.Printf("%#v", newModule)
 
 	for _, section := range newModule {
-		sectionName, exist := section["name"]
-		if !exist {
+		if section["name"] != "import" {
 			continue
 		}
-		switch sectionName.(string) {
-		case "type":
-			var entries []toolkit.TypeEntry
-
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]toolkit.TypeEntry)
-			}
-			//fmt.Printf("Entries %#v\n", entries)
-			importEntry.Type = uint64(len(entries))
-			entries = append(entries, importType)
-			section["entries"] = entries
-
-			// save for use for the code section.
-			typeModule = section
-		case "function":
-			// save for use for the code section.
-			functionModule = section
-		case "import":
-			var entries []toolkit.ImportEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]toolkit.ImportEntry)
-			}
-			for _, entry := range entries {
-				if entry.ModuleStr == m.opts.ModuleStr && entry.FieldStr == m.opts.FieldStr {
-					return nil, 0, ErrImportMeterFunc
-				}
-
-				if entry.Kind == "function" {
-					funcIndex += 1
-				}
-			}
-			// append the metering import.
-			section["entries"] = append(entries, importEntry)
-		case "export":
-			var entries []toolkit.ExportEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]toolkit.ExportEntry)
-			}
-			for i, entry := range entries {
-				if entry.Kind == "function" && entry.Index >= uint32(funcIndex) {
-					entries[i].Index = entry.Index + 1
-				}
-			}
-		case "element":
-			var entries []toolkit.ElementEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]toolkit.ElementEntry)
-			}
-			for i, entry := range entries {
-				// remap element indices.
-				newElements := make([]uint64, 0, len(entry.Elements))
-				for _, el := range entry.Elements {
-					if el >= uint64(funcIndex) {
-						el += 1
-					}
-					newElements = append(newElements, el)
-				}
-				entries[i].Elements = newElements
-			}
-		case "start":
-			index := section["index"].(uint32)
-			if index >= uint32(funcIndex) {
-				index += 1
-			}
-			section["index"] = index
-		case "code":
-			entries := section["entries"].([]toolkit.CodeBody)
-			funcEntries := functionModule["entries"].([]uint64)
-			typEntries := typeModule["entries"].([]toolkit.TypeEntry)
-			for i, entry := range entries {
-				typeIndex := funcEntries[i]
-				typ := typEntries[typeIndex]
-				cost := getCost(typ, m.opts.CostTable["type"].(toolkit.JSON), defaultCost)
-
-				entry, cost = meterCodeEntry(entry, m.opts.CostTable["code"].(toolkit.JSON), m.opts.MeterType, funcIndex, cost)
-				gasCost += cost
-				entries[i] = entry
+		entries, _ := section["entries"].([]toolkit.ImportEntry)
+		for _, entry := range entries {
+			if entry.ModuleStr == m.opts.ModuleStr && entry.FieldStr == m.opts.FieldStr {
+				return nil, 0, ErrImportMeterFunc
 			}
 		}
 	}
+
+	// the type of the metering function, the one of the module is used if it has it.
+	newModule, meterType := toolkit.InternType(newModule, toolkit.TypeEntry{
+		Form:   "func",
+		Params: []string{m.opts.MeterType},
+	})
+	importEntry.Type = uint64(meterType)
+
+	// append the metering import, the functions after it are renumbered.
+	space := toolkit.NewIndexSpace(newModule)
+	meterFuncIndex, _, err := space.AddImport(importEntry)
+	if err != nil {
+		return nil, 0, err
+	}
+	newModule = space.Module()
+	funcIndex := int(meterFuncIndex)
+
+	// meter the code.
+	meter := newCodeMeter(m.opts.CostTable, m.opts.MeterType, funcIndex)
+	opMaps, err := pass.Rewrite(newModule, meter)
+	if err != nil {
+		return nil, 0, err
+	}
+	gasCost = meter.gasCost
+	var newCode []toolkit.CodeBody
+	for _, section := range newModule {
+		if section["name"] == "code" {
+			newCode = section["entries"].([]toolkit.CodeBody)
+		}
+	}
+	if relocator != nil {
+		for i, entry := range newCode {
+			relocator.addMeterCalls(i, entry, opMaps[i])
+		}
+	}
+	m.codeMap = toolkit.NewCodeMap(oldCode, newCode, opMaps)
+
+	if relocator != nil {
+		newModule = relocator.relocate(newModule, funcIndex, oldCode, opMaps)
+	}
+	newModule, err = m.processDebugInfo(newModule, m.codeMap)
+	if err != nil {
+		return nil, 0, err
+	}
+	if m.opts.SourceMapURL != "" {
+		newModule = toolkit.SetSourceMappingURL(newModule, m.opts.SourceMapURL)
+	}
 	return newModule, gasCost, nil
 }
 
@@ -277,143 +328,77 @@ func getCost(j interface{}, costTable toolkit.JSON, defaultCost uint64) (cost ui
 	return
 }
 
-// meterCodeEntry meters a single code entry (see toolkit.CodeBody).
-func meterCodeEntry(entry toolkit.CodeBody, costTable toolkit.JSON, meterType string, meterFuncIndex int, cost uint64) (toolkit.CodeBody, uint64) {
-	getImmediateFromOP := func(name, opType string) string {
-		var immediatesKey string
-		if name == "const" {
-			immediatesKey = opType
-		} else {
-			immediatesKey = name
-		}
-		return toolkit.OP_IMMEDIATES[immediatesKey]
-	}
-
-	meteringStatement := func(cost uint64, meteringImportIndex int) (ops []toolkit.OP) {
-		opsJson := toolkit.Text2Json(fmt.Sprintf("%s.const %v call %v", meterType, cost, meteringImportIndex))
-		for _, op := range opsJson {
-			//immediates, _ := strconv.ParseUint(op["immediates"].(string), 10, 64)
-
-			oop := toolkit.OP{
-				Name: op["name"].(string),
-			}
-
-			// convert immediates.
-			imm := getImmediateFromOP(oop.Name, meterType)
-			if imm != "" {
-				opImm := op["immediates"]
-				switch imm {
-				case "varuint1":
-					imme, _ := strconv.ParseInt(opImm.(string), 10, 8)
-					oop.Immediates = int8(imme)
-				case "varuint32":
-					imme, _ := strconv.ParseUint(opImm.(string), 10, 32)
-					oop.Immediates = uint32(imme)
-				case "varint32":
-					imme, _ := strconv.ParseInt(opImm.(string), 10, 32)
-					oop.Immediates = int32(imme)
-				case "varint64":
-					imme, _ := strconv.ParseInt(opImm.(string), 10, 64)
-					oop.Immediates = int64(imme)
-				case "uint32":
-					oop.Immediates = opImm.([]byte)
-				case "uint64":
-					oop.Immediates = opImm.([]byte)
-				case "block_type":
-					oop.Immediates = opImm.(string)
-				case "br_table", "call_indirect", "memory_immediate":
-					oop.Immediates = opImm.(toolkit.JSON)
-				}
-				//fmt.Printf("immediate %v %v\n", imm, oop.Immediates)
-			}
-
-			if rt, ok := op["return_type"]; ok {
-				oop.ReturnType = rt.(string)
-			}
-
-			if rt, ok := op["type"]; ok {
-				oop.Type = rt.(string)
-			}
+// codeMeter is the visitor that meters the function bodies: a metering statement is inserted before every
+// segment of code that ends with a branch, it's charged the cost of the segment.
+type codeMeter struct {
+	typeTable      toolkit.JSON // the costs of the types.
+	codeTable      toolkit.JSON // the costs of the locals and the operators.
+	meterType      string
+	meterFuncIndex int
+	meteringCost   uint64 // the cost of the metering statement itself.
+
+	segments map[int]uint64 // the cost of the segments of the visited function by their first operator.
+	gasCost  uint64
+}
 
-			ops = append(ops, oop)
-		}
+func newCodeMeter(costTable toolkit.JSON, meterType string, meterFuncIndex int) *codeMeter {
+	m := &codeMeter{
+		typeTable:      costTable["type"].(toolkit.JSON),
+		codeTable:      costTable["code"].(toolkit.JSON),
+		meterType:      meterType,
+		meterFuncIndex: meterFuncIndex,
+	}
+	for _, op := range m.meteringStatement(0) {
+		m.meteringCost += m.opCost(op)
+	}
+	return m
+}
 
-		return
-	}
-
-	remapOp := func(op *toolkit.OP, funcIndex int) {
-		if op.Name == "call" {
-			switch imm := op.Immediates.(type) {
-			case string:
-				rv, _ := strconv.ParseInt(imm, 10, 64)
-				if rv >= int64(funcIndex) {
-					rv += 1
-					op.Immediates = strconv.FormatInt(rv, 10)
-				}
-			case uint32:
-				//fmt.Printf("funcIndex %d, imm %d\n", funcIndex, imm)
-				if imm >= uint32(funcIndex) {
-					imm += 1
-					op.Immediates = imm
-				}
-			default:
-				panic(fmt.Sprintf("invalid immediates type: %v", imm))
-			}
+func (m *codeMeter) meteringStatement(cost uint64) []toolkit.OP {
+	return toolkit.NewEmitter().Const(m.meterType, cost).Call(uint32(m.meterFuncIndex)).Ops()
+}
 
+// opCost prices the operators by their full name (i.e. `memory.copy`) if the cost table has it, by their name otherwise.
+func (m *codeMeter) opCost(op toolkit.OP) uint64 {
+	codeTable := m.codeTable["code"].(toolkit.JSON)
+	if op.ReturnType != "" {
+		if _, exist := codeTable[op.ReturnType+"."+op.Name]; exist {
+			return getCost(op.ReturnType+"."+op.Name, codeTable, defaultCost)
 		}
 	}
+	return getCost(op.Name, codeTable, defaultCost)
+}
 
-	meterTheMeteringStatement := func() uint64 {
-		code := meteringStatement(0, meterFuncIndex)
-		// sum the operations cost
-		sum := uint64(0)
-		for _, op := range code {
-			sum += getCost(op.Name, costTable["code"].(toolkit.JSON), defaultCost)
-		}
-		return sum
+// EnterFunc prices the segments of a function, the first one is also charged the type and the locals of the function.
+// The gas cost of the module counts the type and the locals once more, as it always did.
+func (m *codeMeter) EnterFunc(f *pass.Func) error {
+	cost := getCost(f.Body.Locals, m.codeTable["locals"].(toolkit.JSON), defaultCost)
+	if f.Type != nil {
+		cost += getCost(*f.Type, m.typeTable, defaultCost)
 	}
+	m.gasCost += cost
 
-	var (
-		meteringCost = meterTheMeteringStatement()
-		code         = make([]toolkit.OP, len(entry.Code))
-		meteredCode  []toolkit.OP
-	)
-	//fmt.Printf("meter the meter cost %d\n", meteringCost)
-
-	// create a code copy.
-	copy(code, entry.Code)
-
-	cost += getCost(entry.Locals, costTable["locals"].(toolkit.JSON), defaultCost)
-	sum := cost
-
-	for len(code) > 0 {
-		i := 0
-
-		// meter a segment of wasm code.
-		for {
-			op := &code[i]
-			remapOp(op, meterFuncIndex)
-			cost += getCost(code[i].Name, costTable["code"].(toolkit.JSON), defaultCost)
-			i += 1
-			if _, exist := branchOps[op.Name]; exist {
-				break
-			}
+	m.segments = map[int]uint64{}
+	start := 0
+	for i, op := range f.Body.Code {
+		cost += m.opCost(op)
+		if _, exist := branchOps[op.Name]; !exist && i < len(f.Body.Code)-1 {
+			continue
 		}
-
-		// add the metering statement.
 		if cost != 0 {
 			// add the cost of metering
-			cost += meteringCost
-			ops := meteringStatement(cost, meterFuncIndex)
-			meteredCode = append(meteredCode, ops...)
+			cost += m.meteringCost
+			m.segments[start] = cost
 		}
-		sum += cost
-
-		meteredCode = append(meteredCode, code[:i]...)
-		code = code[i:]
-		cost = 0
+		m.gasCost += cost
+		start, cost = i+1, 0
 	}
+	return nil
+}
 
-	entry.Code = meteredCode
-	return entry, sum
+func (m *codeMeter) Visit(c *pass.Cursor) error {
+	if cost, exist := m.segments[c.Index]; exist {
+		c.InsertBefore(m.meteringStatement(cost)...)
+	}
+	return nil
 }
@@ -7,6 +7,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/yyh1102/go-wasm-metering/test"
 	"github.com/yyh1102/go-wasm-metering/toolkit"
+	"github.com/yyh1102/go-wasm-metering/toolkit/gen"
+	"github.com/yyh1102/go-wasm-metering/toolkit/pass"
 	"io/ioutil"
 	"path"
 	"testing"
@@ -96,9 +98,445 @@ func TestBasicMeteringTests(t *testing.T) {
 
 		if !assert.Equal(t, true, assert.ObjectsAreEqual(meteredModule, expectedJson)) {
 			fmt.Printf("file name %s\n", file.Name())
-			fmt.Printf("%#v\n%#v\n", meteredModule, expectedJson)
+			fmt.Print(toolkit.Diff(expectedJson, meteredModule))
 		}
 	}
 
 	//fmt.Printf("Basic metering tests failed cases %d", failed)
 }
+
+func TestGasCost(t *testing.T) {
+	// the gas costs MeterWASM returns for the modules, they mustn't change.
+	costs := map[string]uint64{
+		"basic.wasm":        12,
+		"memory0.wasm":      0,
+		"memory1.wasm":      0,
+		"memory2.wasm":      0,
+		"mixedImports.wasm": 12,
+		"start.wasm":        8,
+		"stuff.wasm":        10,
+	}
+	files, err := ioutil.ReadDir(path.Join("test", "in", "wasm"))
+	assert.Nil(t, err)
+	for _, file := range files {
+		wasm, err := ioutil.ReadFile(path.Join("test", "in", "wasm", file.Name()))
+		assert.Nil(t, err)
+		_, gasCost, err := MeterWASM(wasm, &Options{CostTable: test.DefaultCostTable})
+		// the module imports the metering function already.
+		if file.Name() == "basic+import.wasm" {
+			assert.Equal(t, ErrImportMeterFunc, err)
+			continue
+		}
+		cost, exist := costs[file.Name()]
+		assert.True(t, exist, file.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, cost, gasCost, file.Name())
+		delete(costs, file.Name())
+	}
+	assert.Empty(t, costs)
+}
+
+func TestDeterministicSharedMemory(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x05, 0x04, 0x01, 0x03, 0x01, 0x02, // memory 1 2 shared
+	}
+
+	_, _, err := MeterWASM(wasm, &Options{Deterministic: true})
+	assert.Equal(t, ErrSharedMemory, err)
+
+	_, _, err = MeterWASM(wasm, &Options{})
+	assert.Nil(t, err)
+}
+
+func TestMeterExceptionHandlers(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x08, 0x02, 0x60, 0x00, 0x00, 0x60, 0x01, 0x7f, 0x00, // type
+		0x03, 0x02, 0x01, 0x00, // function
+		0x0d, 0x03, 0x01, 0x00, 0x01, // tag
+		0x0a, 0x10, 0x01, 0x0e, 0x00, // code
+		0x06, 0x40, // try
+		0x41, 0x01, // i32.const 1
+		0x08, 0x00, // throw 0
+		0x07, 0x00, // catch 0
+		0x1a, // drop
+		0x19, // catch_all
+		0x01, // nop
+		0x0b, // end
+		0x0b,
+	}
+
+	meteredWasm, _, err := MeterWASM(wasm, &Options{CostTable: test.DefaultCostTable})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(meteredWasm)
+	code := module[5]["entries"].([]toolkit.CodeBody)[0].Code
+
+	// every handler is charged on its own.
+	handlers := 0
+	for i, op := range code {
+		if op.Name == "catch" || op.Name == "catch_all" {
+			handlers += 1
+			assert.Equal(t, "const", code[i+1].Name)
+			assert.Equal(t, toolkit.OP{Name: "call", Immediates: uint32(0)}, code[i+2])
+		}
+	}
+	assert.Equal(t, 2, handlers)
+}
+
+func TestMeterTailCalls(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x03, 0x03, 0x02, 0x00, 0x00, // function
+		0x0a, 0x0a, 0x02, // code
+		0x04, 0x00, 0x12, 0x01, 0x0b, // return_call 1
+		0x03, 0x00, 0x01, 0x0b, // nop
+	}
+
+	meteredWasm, _, err := MeterWASM(wasm, &Options{CostTable: test.DefaultCostTable})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(meteredWasm)
+	code := module[4]["entries"].([]toolkit.CodeBody)[0].Code
+
+	assert.Equal(t, toolkit.OP{Name: "call", Immediates: uint32(0)}, code[1])
+	assert.Equal(t, toolkit.OP{Name: "return_call", Immediates: uint32(2)}, code[2])
+	// the tail call ends the metered segment.
+	assert.Equal(t, "const", code[3].Name)
+	assert.Equal(t, "end", code[5].Name)
+}
+
+func TestMeterMemory64(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7e, // type () -> i64
+		0x03, 0x02, 0x01, 0x00, // function
+		0x05, 0x0d, 0x01, 0x05, 0x80, 0x80, 0x80, 0x80, 0x10, 0x80, 0x80, 0x80, 0x80, 0x20, // memory i64 2^32 2^33
+		0x0a, 0x08, 0x01, 0x06, 0x00, // code
+		0x42, 0x01, // i64.const 1
+		0x40, 0x00, // grow_memory
+		0x0b,
+	}
+
+	meteredWasm, _, err := MeterWASM(wasm, &Options{CostTable: test.DefaultCostTable})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(meteredWasm)
+	assert.Equal(t, toolkit.MemLimits{Flags: 5, Intial: uint64(1) << 32, Maximum: uint64(1) << 33}, module[4]["entries"].([]toolkit.MemLimits)[0])
+	assert.Nil(t, toolkit.ValidateModule(module))
+}
+
+func TestMaxMemories(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x05, 0x05, 0x02, 0x00, 0x01, 0x00, 0x01, // two memories
+	}
+
+	_, _, err := MeterWASM(wasm, &Options{MaxMemories: 1})
+	assert.Equal(t, ErrTooManyMemories, err)
+
+	_, _, err = MeterWASM(wasm, &Options{MaxMemories: 2})
+	assert.Nil(t, err)
+}
+
+func TestMeterTypedFunctionReferences(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x03, 0x02, 0x01, 0x00, // function
+		0x06, 0x06, 0x01, 0x70, 0x00, 0xd2, 0x00, 0x0b, // global funcref (ref.func 0)
+		0x09, 0x05, 0x01, 0x03, 0x00, 0x01, 0x00, // declarative element segment
+		0x0a, 0x09, 0x01, 0x07, 0x00, // code
+		0xd2, 0x00, // ref.func 0
+		0xd4, 0x00, // br_on_null 0
+		0x1a, // drop
+		0x0b,
+	}
+
+	meteredWasm, _, err := MeterWASM(wasm, &Options{CostTable: test.DefaultCostTable})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(meteredWasm)
+
+	// the references to the functions are shifted by the metering import.
+	assert.Equal(t, toolkit.OP{Name: "func", ReturnType: "ref", Immediates: uint32(1)}, module[4]["entries"].([]toolkit.GlobalEntry)[0].Init[0])
+	assert.Equal(t, []uint64{1}, module[5]["entries"].([]toolkit.ElementEntry)[0].Elements)
+
+	code := module[6]["entries"].([]toolkit.CodeBody)[0].Code
+	assert.Equal(t, toolkit.OP{Name: "func", ReturnType: "ref", Immediates: uint32(1)}, code[2])
+	assert.Equal(t, "br_on_null", code[3].Name)
+
+	// the code following a br_on_null is charged on its own.
+	assert.Equal(t, "const", code[4].Name)
+	assert.Equal(t, toolkit.OP{Name: "call", Immediates: uint32(0)}, code[5])
+	assert.Equal(t, "drop", code[6].Name)
+}
+
+func TestMeterRelocatable(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x02, 0x09, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01, 0x66, 0x00, 0x00, // import env.f
+		0x03, 0x02, 0x01, 0x00, // function
+		0x0a, 0x0a, 0x01, 0x08, 0x00, // code
+		0x10, 0x80, 0x80, 0x80, 0x80, 0x00, // call 0, padded
+		0x0b,
+		0x00, 0x14, 0x07, 0x6c, 0x69, 0x6e, 0x6b, 0x69, 0x6e, 0x67, 0x02, // linking
+		0x08, 0x09, 0x02, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0x01, 0x67, // symbols: env.f, g
+		0x00, 0x10, 0x0a, 0x72, 0x65, 0x6c, 0x6f, 0x63, 0x2e, 0x43, 0x4f, 0x44, 0x45, // reloc.CODE
+		0x03, 0x01, 0x00, 0x04, 0x00, // the index of call 0
+	}
+
+	meteredWasm, _, err := MeterWASM(wasm, &Options{CostTable: test.DefaultCostTable})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(meteredWasm)
+
+	// the functions are renumbered and the metering import gets a symbol.
+	linking := toolkit.ParseLinking(module[5]["payload"].(string))
+	assert.Equal(t, []toolkit.Symbol{
+		{Kind: "function", Flags: toolkit.SYMBOL_UNDEFINED, Index: 0},
+		{Kind: "function", Index: 2, Name: "g"},
+		{Kind: "function", Flags: toolkit.SYMBOL_UNDEFINED, Index: 1},
+	}, linking.Symbols)
+
+	// the injected call is padded and relocated, the relocations stay in order.
+	code := module[4]["entries"].([]toolkit.CodeBody)
+	offsets := toolkit.CodeOffsets(code)
+	assert.Equal(t, toolkit.OP{Name: "call", Immediates: uint32(1), Widths: []byte{5}}, code[0].Code[1])
+	assert.Equal(t, toolkit.RelocSec{
+		Section: 3,
+		Entries: []toolkit.Relocation{
+			{Type: "R_WASM_FUNCTION_INDEX_LEB", Offset: offsets[0].Ops[1] + 1, Index: 2},
+			{Type: "R_WASM_FUNCTION_INDEX_LEB", Offset: offsets[0].Ops[2] + 1, Index: 0},
+		},
+	}, toolkit.ParseReloc(module[6]["payload"].(string)))
+}
+
+// dwarfModule returns a module with a function `i32.const 1 drop` and its debug info, the function starts at
+// offset 3 of the code section and its code is 4 bytes long.
+func dwarfModule() []byte {
+	module := toolkit.Wasm2Json([]byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x03, 0x02, 0x01, 0x00, // function
+		0x0a, 0x07, 0x01, 0x05, 0x00, // code
+		0x41, 0x01, 0x1a, 0x0b, // i32.const 1 drop
+	})
+	custom := func(name string, payload []byte) toolkit.JSON {
+		return toolkit.JSON{"name": "custom", "section_name": name, "payload": string(payload)}
+	}
+	return toolkit.Json2Wasm(append(module,
+		custom(".debug_abbrev", []byte{
+			0x01, 0x11, 0x01, 0x10, 0x17, 0x11, 0x01, 0x12, 0x06, 0x00, 0x00, // compile unit: stmt_list, low_pc, high_pc
+			0x02, 0x2e, 0x00, 0x11, 0x01, 0x12, 0x06, 0x00, 0x00, // subprogram: low_pc, high_pc
+			0x00,
+		}),
+		custom(".debug_info", []byte{
+			0x1e, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04,
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+			0x02, 0x03, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+			0x00,
+		}),
+		custom(".debug_line", dwarfLineProgram(3)),
+	))
+}
+
+// dwarfLineProgram returns a line program with the rows of the function of dwarfModule starting at an address.
+func dwarfLineProgram(address byte) []byte {
+	return []byte{
+		0x2f, 0x00, 0x00, 0x00, 0x04, 0x00, 0x1b, 0x00, 0x00, 0x00,
+		0x01, 0x01, 0x01, 0xfb, 0x0e, 0x0d, 0x00, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x61, 0x2e, 0x63, 0x00, 0x00, 0x00, 0x00, 0x00, // a.c
+		0x00, 0x05, 0x02, address, 0x00, 0x00, 0x00, 0x01, // line 1
+		0x2f,       // line 2, 2 bytes later
+		0x02, 0x02, // the end of the function
+		0x00, 0x01, 0x01,
+	}
+}
+
+func TestMeterDebugInfo(t *testing.T) {
+	result, err := Meter(dwarfModule(), &Options{CostTable: test.DefaultCostTable, DebugInfo: DebugInfoRewrite})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(result.Wasm)
+	offsets := toolkit.CodeOffsets(module[4]["entries"].([]toolkit.CodeBody))
+	start, end := byte(offsets[0].Ops[2]), byte(offsets[0].End)
+
+	// the addresses move with `i32.const 1`, the metering is at the start of the function.
+	assert.Equal(t, string([]byte{
+		0x1e, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x04,
+		0x01, 0x00, 0x00, 0x00, 0x00, start, 0x00, 0x00, 0x00, end - start, 0x00, 0x00, 0x00,
+		0x02, start, 0x00, 0x00, 0x00, end - start, 0x00, 0x00, 0x00,
+		0x00,
+	}), module[6]["payload"])
+	assert.Equal(t, string(dwarfLineProgram(start)), module[7]["payload"])
+
+	result, err = Meter(dwarfModule(), &Options{CostTable: test.DefaultCostTable, DebugInfo: DebugInfoStrip})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{".debug_abbrev", ".debug_info", ".debug_line"}, result.StrippedSections)
+	assert.Len(t, toolkit.Wasm2Json(result.Wasm), 5)
+}
+
+func TestMeterSourceMap(t *testing.T) {
+	module := toolkit.Wasm2Json([]byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x03, 0x02, 0x01, 0x00, // function
+		0x0a, 0x07, 0x01, 0x05, 0x00, // code
+		0x41, 0x01, 0x1a, 0x0b, // i32.const 1 drop
+	})
+	wasm := toolkit.Json2Wasm(toolkit.SetSourceMappingURL(module, "a.wasm.map"))
+
+	result, err := Meter(wasm, &Options{CostTable: test.DefaultCostTable, SourceMapURL: "a.metered.wasm.map"})
+	assert.Nil(t, err)
+	metered := toolkit.Wasm2Json(result.Wasm)
+	url, _ := toolkit.SourceMappingURL(metered)
+	assert.Equal(t, "a.metered.wasm.map", url)
+
+	// `drop` is at offset 25 of the module.
+	offsets := toolkit.CodeOffsets(metered[4]["entries"].([]toolkit.CodeBody))
+	codeOffset, _ := toolkit.CodeSectionOffset(result.Wasm)
+	drop, exist := result.BinaryMap.Map(25)
+	assert.True(t, exist)
+	assert.Equal(t, codeOffset+offsets[0].Ops[3], drop)
+	assert.Equal(t, byte(0x1a), result.Wasm[drop])
+}
+
+func TestMeterOffsetMapping(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x03, 0x02, 0x01, 0x00, // function
+		0x0a, 0x07, 0x01, 0x05, 0x00, // code
+		0x41, 0x01, 0x1a, 0x0b, // i32.const 1 drop
+	}
+
+	result, err := Meter(wasm, &Options{CostTable: test.DefaultCostTable})
+	assert.Nil(t, err)
+	_, offsets := toolkit.Wasm2JsonOffsets(result.Wasm)
+	ops := offsets[0].Ops
+
+	// the metering statement is injected before `i32.const 1`.
+	assert.Equal(t, []toolkit.FuncMap{{
+		Func:     0,
+		Ops:      []toolkit.OpMapping{{Old: 3, New: ops[2]}, {Old: 5, New: ops[3]}, {Old: 6, New: ops[4]}},
+		Injected: []toolkit.Range{{Start: 3, End: ops[2]}},
+	}}, result.Functions)
+
+	// a trap at `drop` is located in the module, the injected code isn't.
+	codeOffset, _ := toolkit.CodeSectionOffset(result.Wasm)
+	trap, exist := result.BinaryMap.Reverse(codeOffset + ops[3])
+	assert.True(t, exist)
+	assert.Equal(t, uint32(25), trap)
+	_, exist = result.BinaryMap.Reverse(codeOffset + ops[1])
+	assert.False(t, exist)
+}
+
+func TestMeterDeadCode(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	code, _ := toolkit.NewEmitter().Code()
+	main := b.AddFunction(void, nil, code)
+	code, _ = toolkit.NewEmitter().Call(main).Code()
+	b.AddFunction(void, nil, code)
+	assert.Nil(t, b.AddExport("main", "function", main))
+	wasm := toolkit.Json2Wasm(b.Module())
+
+	result, err := Meter(wasm, &Options{EliminateDeadCode: true})
+	assert.Nil(t, err)
+	module := toolkit.Wasm2Json(result.Wasm)
+	space := toolkit.NewIndexSpace(module)
+	imports, defined := space.Len("function")
+	assert.Equal(t, []uint32{1, 1}, []uint32{imports, defined})
+	assert.Len(t, result.Functions, 1)
+
+	_, err = Meter(wasm, &Options{EliminateDeadCode: true, DebugInfo: DebugInfoRewrite})
+	assert.Equal(t, ErrDeadCodeDebugInfo, err)
+}
+
+func TestMeterStrip(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	code, _ := toolkit.NewEmitter().Code()
+	main := b.AddFunction(void, nil, code)
+	code, _ = toolkit.NewEmitter().Call(main).Code()
+	internal := b.AddFunction(void, nil, code)
+	assert.Nil(t, b.AddExport("main", "function", main))
+	assert.Nil(t, b.AddExport("internal", "function", internal))
+	module := append(b.Module(),
+		toolkit.JSON{"name": "custom", "section_name": "producers", "payload": "clang"},
+		toolkit.JSON{"name": "custom", "section_name": ".debug_str", "payload": "main"})
+	wasm := toolkit.Json2Wasm(module)
+
+	opts := &Options{
+		Strip:             &pass.Strip{Sections: []string{"producers", ".debug_*"}, Exports: []string{"main"}},
+		EliminateDeadCode: true,
+	}
+	result, err := Meter(wasm, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"producers", ".debug_str"}, result.StrippedSections)
+	assert.Equal(t, []string{"internal"}, result.StrippedExports)
+	assert.True(t, result.StrippedBytes > 0)
+	assert.Nil(t, opts.Strip.StrippedExports)
+
+	// the function only the removed export used is dead code.
+	space := toolkit.NewIndexSpace(toolkit.Wasm2Json(result.Wasm))
+	imports, defined := space.Len("function")
+	assert.Equal(t, []uint32{1, 1}, []uint32{imports, defined})
+
+	// the binary map maps the offsets of the module, not the ones of the stripped module.
+	result, err = Meter(wasm, &Options{Strip: &pass.Strip{Exports: []string{"main"}}})
+	assert.Nil(t, err)
+	oldCode, _ := toolkit.CodeSectionOffset(wasm)
+	assert.Equal(t, oldCode, result.BinaryMap.OldCode)
+	op := result.Functions[0].Ops[0]
+	offset, mapped := result.BinaryMap.Reverse(result.BinaryMap.NewCode + op.New)
+	assert.True(t, mapped)
+	assert.Equal(t, oldCode+op.Old, offset)
+}
+
+func TestMeterGenerated(t *testing.T) {
+	sectionEntries := func(module []toolkit.JSON, name string) interface{} {
+		for _, section := range module {
+			if section["name"] == name {
+				return section["entries"]
+			}
+		}
+		return nil
+	}
+	for seed := int64(0); seed < 100; seed++ {
+		module, err := gen.Generate(seed, gen.DefaultLimits)
+		assert.Nil(t, err)
+		result, err := Meter(toolkit.Json2Wasm(module), &Options{CostTable: test.DefaultCostTable})
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		metered := toolkit.Wasm2Json(result.Wasm)
+		if err := toolkit.ValidateModuleStrict(metered); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+
+		// the metering function is imported after the functions of the module, the defined ones move by one.
+		imported, _ := toolkit.NewIndexSpace(module).Len("function")
+		moved := func(index uint64) uint64 {
+			if index >= uint64(imported) {
+				return index + 1
+			}
+			return index
+		}
+		exports, _ := sectionEntries(module, "export").([]toolkit.ExportEntry)
+		meteredExports, _ := sectionEntries(metered, "export").([]toolkit.ExportEntry)
+		assert.Equal(t, len(exports), len(meteredExports))
+		for i, export := range exports {
+			if export.Kind == "function" {
+				export.Index = uint32(moved(uint64(export.Index)))
+			}
+			assert.Equal(t, export, meteredExports[i])
+		}
+		elements, _ := sectionEntries(module, "element").([]toolkit.ElementEntry)
+		meteredElements, _ := sectionEntries(metered, "element").([]toolkit.ElementEntry)
+		assert.Equal(t, len(elements), len(meteredElements))
+		for i, segment := range elements {
+			for j, index := range segment.Elements {
+				assert.Equal(t, moved(index), meteredElements[i].Elements[j])
+			}
+		}
+	}
+}
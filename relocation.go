@@ -0,0 +1,51 @@
+package metering
+
+import "github.com/yyh1102/go-wasm-metering/toolkit"
+
+// meterRelocator rewrites the linking and relocation sections of an object file once it's metered.
+type meterRelocator struct {
+	toolkit.Relocator
+}
+
+func newMeterRelocator(module []toolkit.JSON) *meterRelocator {
+	r := &meterRelocator{}
+	r.Sections = append([]toolkit.JSON{}, module...)
+	return r
+}
+
+// addMeterCalls relocates the calls to the metering function of a metered function, they are padded so that
+// the linker can rewrite their index.
+func (r *meterRelocator) addMeterCalls(f int, entry toolkit.CodeBody, opMap []int) {
+	original := make(map[int]struct{}, len(opMap))
+	for _, i := range opMap {
+		original[i] = struct{}{}
+	}
+	for i, op := range entry.Code {
+		if _, exist := original[i]; exist || op.Name != "call" {
+			continue
+		}
+		entry.Code[i].Widths = []byte{5}
+		r.Relocations = append(r.Relocations, toolkit.OpRelocation{
+			Func: f,
+			Op:   i,
+			Type: "R_WASM_FUNCTION_INDEX_LEB",
+		})
+	}
+}
+
+// relocate renumbers the functions after the metering import and adds its undefined symbol.
+func (r *meterRelocator) relocate(module []toolkit.JSON, meterFuncIndex int, code []toolkit.CodeBody, opMaps [][]int) []toolkit.JSON {
+	r.Code, r.OpMap = code, opMaps
+	r.RemapFunction = func(index uint32) uint32 {
+		if index >= uint32(meterFuncIndex) {
+			return index + 1
+		}
+		return index
+	}
+	r.Symbols = []toolkit.Symbol{{
+		Kind:  "function",
+		Flags: toolkit.SYMBOL_UNDEFINED,
+		Index: uint32(meterFuncIndex),
+	}}
+	return r.Relocate(module)
+}
@@ -0,0 +1,244 @@
+package toolkit
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// the order of the sections of a module, the custom sections excepted.
+var sectionOrder = []string{
+	"type", "import", "function", "table", "memory", "tag", "global", "export", "start", "element", "datacount", "code", "data",
+}
+
+// Builder adds entries to a module and returns their indices. The imports come first in the index spaces,
+// so an entity can only be imported while the module defines none of its kind.
+type Builder struct {
+	module []JSON
+}
+
+// NewBuilder creates a builder that adds to a module, i.e. one decoded with Wasm2Json. The module is
+// empty if it's nil.
+func NewBuilder(module []JSON) *Builder {
+	if module == nil {
+		module = []JSON{{
+			"name":    "preramble",
+			"magic":   []byte{0, 97, 115, 109},
+			"version": []byte{1, 0, 0, 0},
+		}}
+	}
+	return &Builder{module: module}
+}
+
+// Module returns the module that is built.
+func (b *Builder) Module() []JSON {
+	return b.module
+}
+
+// section returns the section with the given name, it's created in order if the module has none.
+func (b *Builder) section(name string) JSON {
+	if pos := findSectionByName(b.module, name); pos >= 0 {
+		return b.module[pos]
+	}
+
+	rank := func(name string) int {
+		for i, n := range sectionOrder {
+			if n == name {
+				return i
+			}
+		}
+		return -1
+	}
+	section := JSON{"name": name}
+	pos := len(b.module)
+	for i := len(b.module) - 1; i > 0; i-- {
+		r := rank(b.module[i]["name"].(string))
+		if r < 0 {
+			continue
+		}
+		if r < rank(name) {
+			break
+		}
+		pos = i
+	}
+	b.module = append(b.module[:pos], append([]JSON{section}, b.module[pos:]...)...)
+	return section
+}
+
+func (b *Builder) entries(name string) interface{} {
+	if pos := findSectionByName(b.module, name); pos >= 0 {
+		return b.module[pos]["entries"]
+	}
+	return nil
+}
+
+func (b *Builder) imports() []ImportEntry {
+	entries, _ := b.entries("import").([]ImportEntry)
+	return entries
+}
+
+func (b *Builder) countImports(kind string) uint32 {
+	count := uint32(0)
+	for _, entry := range b.imports() {
+		if entry.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// AddType returns the index of a type, it's added if the module doesn't have the same function type yet.
+func (b *Builder) AddType(typ TypeEntry) uint32 {
+	section := b.section("type")
+	entries, _ := section["entries"].([]TypeEntry)
+	if typ.Form == "func" && typ.Sub == "" && typ.RecGroup == 0 {
+		for i, entry := range entries {
+			if isPlainFuncType(entry, entries, i) && reflect.DeepEqual(entry.Params, typ.Params) && entry.ReturnType == typ.ReturnType {
+				return uint32(i)
+			}
+		}
+	}
+	if typ.Params == nil {
+		typ.Params = []string{}
+	}
+	section["entries"] = append(entries, typ)
+	return uint32(len(entries))
+}
+
+// isPlainFuncType reports whether a type is a function type that isn't declared with subtyping or in a
+// rec group, such types are equal when their signatures are.
+func isPlainFuncType(entry TypeEntry, entries []TypeEntry, i int) bool {
+	if entry.Form != "func" || entry.Sub != "" || entry.RecGroup > 1 {
+		return false
+	}
+	for j := 0; j < i; j++ {
+		if int(entries[j].RecGroup) > i-j {
+			return false
+		}
+	}
+	return true
+}
+
+// InternType returns the index of a type in a module, the type is appended if the module doesn't have the
+// same function type yet (see Builder.AddType). The module is returned with its type section.
+func InternType(module []JSON, typ TypeEntry) ([]JSON, uint32) {
+	b := NewBuilder(module)
+	index := b.AddType(typ)
+	return b.Module(), index
+}
+
+// FuncType returns the index of the function type with the given params and result, "" if it has none.
+func (b *Builder) FuncType(params []string, result string) uint32 {
+	return b.AddType(TypeEntry{Form: "func", Params: params, ReturnType: result})
+}
+
+func (b *Builder) addImport(module, field, kind string, typ interface{}, defined uint32) (uint32, error) {
+	if defined > 0 {
+		return 0, fmt.Errorf("can't import the %s %s.%s after the defined ones", kind, module, field)
+	}
+	index := b.countImports(kind)
+	section := b.section("import")
+	section["entries"] = append(b.imports(), ImportEntry{
+		ModuleStr: module,
+		FieldStr:  field,
+		Kind:      kind,
+		Type:      typ,
+	})
+	return index, nil
+}
+
+func (b *Builder) definedFuncs() uint32 {
+	entries, _ := b.entries("function").([]uint64)
+	return uint32(len(entries))
+}
+
+// ImportFunction imports a function of the given type and returns its index.
+func (b *Builder) ImportFunction(module, field string, typ uint32) (uint32, error) {
+	return b.addImport(module, field, "function", uint64(typ), b.definedFuncs())
+}
+
+// ImportGlobal imports a global and returns its index.
+func (b *Builder) ImportGlobal(module, field string, typ Global) (uint32, error) {
+	entries, _ := b.entries("global").([]GlobalEntry)
+	return b.addImport(module, field, "global", typ, uint32(len(entries)))
+}
+
+// ImportMemory imports a memory and returns its index.
+func (b *Builder) ImportMemory(module, field string, limits MemLimits) (uint32, error) {
+	entries, _ := b.entries("memory").([]MemLimits)
+	return b.addImport(module, field, "memory", limits, uint32(len(entries)))
+}
+
+// AddFunction defines a function of the given type and returns its index, the code is ended by `end`
+// (see Emitter.Code).
+func (b *Builder) AddFunction(typ uint32, locals []LocalEntry, code []OP) uint32 {
+	index := b.countImports("function") + b.definedFuncs()
+	funcs := b.section("function")
+	entries, _ := funcs["entries"].([]uint64)
+	funcs["entries"] = append(entries, uint64(typ))
+
+	if locals == nil {
+		locals = []LocalEntry{}
+	}
+	codeSec := b.section("code")
+	bodies, _ := codeSec["entries"].([]CodeBody)
+	codeSec["entries"] = append(bodies, CodeBody{Locals: locals, Code: code})
+	return index
+}
+
+// AddGlobal defines a global initialized by a constant expression and returns its index.
+func (b *Builder) AddGlobal(typ Global, init []OP) uint32 {
+	index := b.countImports("global")
+	section := b.section("global")
+	entries, _ := section["entries"].([]GlobalEntry)
+	section["entries"] = append(entries, GlobalEntry{Type: typ, Init: init})
+	return index + uint32(len(entries))
+}
+
+// AddMemory defines a memory and returns its index.
+func (b *Builder) AddMemory(limits MemLimits) uint32 {
+	index := b.countImports("memory")
+	section := b.section("memory")
+	entries, _ := section["entries"].([]MemLimits)
+	section["entries"] = append(entries, limits)
+	return index + uint32(len(entries))
+}
+
+// AddExport exports an entity of the given kind (i.e. "function") under a name, the names are unique.
+func (b *Builder) AddExport(field, kind string, index uint32) error {
+	section := b.section("export")
+	entries, _ := section["entries"].([]ExportEntry)
+	for _, entry := range entries {
+		if entry.FieldStr == field {
+			return fmt.Errorf("duplicate export %q", field)
+		}
+	}
+	section["entries"] = append(entries, ExportEntry{FieldStr: field, Kind: kind, Index: index})
+	return nil
+}
+
+// AddTable defines a table and returns its index.
+func (b *Builder) AddTable(table Table) uint32 {
+	index := b.countImports("table")
+	section := b.section("table")
+	entries, _ := section["entries"].([]Table)
+	section["entries"] = append(entries, table)
+	return index + uint32(len(entries))
+}
+
+// AddElements adds an active segment of functions to a table at the offset of a constant expression.
+func (b *Builder) AddElements(table uint32, offset []OP, funcs []uint64) {
+	section := b.section("element")
+	entries, _ := section["entries"].([]ElementEntry)
+	section["entries"] = append(entries, ElementEntry{Index: table, Offset: offset, Elements: funcs})
+}
+
+// AddData adds an active data segment to a memory at the offset of a constant expression.
+func (b *Builder) AddData(memory uint32, offset []OP, data []byte) {
+	segment := DataSegment{Index: memory, Offset: offset, Data: data}
+	if memory != 0 {
+		segment.Flags = DATA_FLAG_INDEX
+	}
+	section := b.section("data")
+	entries, _ := section["entries"].([]DataSegment)
+	section["entries"] = append(entries, segment)
+}
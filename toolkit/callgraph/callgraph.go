@@ -0,0 +1,388 @@
+// Package callgraph builds the call graph of a module, the indirect calls are resolved to every function
+// of the right signature that may be in their table.
+package callgraph
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// EdgeKind tells how a function calls another one.
+type EdgeKind int
+
+const (
+	EdgeDirect   EdgeKind = iota // `call` and `return_call`.
+	EdgeIndirect                 // `call_indirect` and `return_call_indirect`, to a possible target.
+	EdgeRef                      // `call_ref` and `return_call_ref`, to a possible target.
+)
+
+var edgeKindNames = map[EdgeKind]string{
+	EdgeDirect:   "direct",
+	EdgeIndirect: "indirect",
+	EdgeRef:      "ref",
+}
+
+func (k EdgeKind) String() string {
+	return edgeKindNames[k]
+}
+
+type Edge struct {
+	Caller uint32
+	Callee uint32
+	Kind   EdgeKind
+}
+
+// Func is a function of the module, imported or defined.
+type Func struct {
+	Index    uint32
+	Type     uint32
+	Import   *toolkit.ImportEntry // nil if the function is defined.
+	Exports  []string             // the names the function is exported under.
+	Callees  []Edge
+	Callers  []Edge
+	Escaping bool // the function may be called through a table or a reference.
+}
+
+func (f *Func) Imported() bool {
+	return f.Import != nil
+}
+
+// Graph is the call graph of a module, the functions are in the order of their index space.
+type Graph struct {
+	Funcs []*Func
+	Start *uint32 // the start function, nil if there's none.
+}
+
+// the possible targets of the indirect calls.
+type targets struct {
+	tables  map[uint32]map[uint32]struct{} // the functions of the active segments of every table.
+	passive map[uint32]struct{}            // the functions of the passive segments, they may be copied to any table.
+	refs    map[uint32]struct{}            // the functions whose reference is taken, they may be stored in any table.
+	open    map[uint32]bool                // the tables the host can modify, they may hold the exported functions.
+}
+
+// New builds the call graph of a module decoded by Wasm2Json.
+func New(module []toolkit.JSON) (*Graph, error) {
+	var (
+		types   []toolkit.TypeEntry
+		imports []toolkit.ImportEntry
+		funcs   []uint64
+		code    []toolkit.CodeBody
+		exports []toolkit.ExportEntry
+		elems   []toolkit.ElementEntry
+		globals []toolkit.GlobalEntry
+		g       = &Graph{}
+	)
+	for _, section := range module {
+		switch section["name"] {
+		case "type":
+			types, _ = section["entries"].([]toolkit.TypeEntry)
+		case "import":
+			imports, _ = section["entries"].([]toolkit.ImportEntry)
+		case "function":
+			funcs, _ = section["entries"].([]uint64)
+		case "code":
+			code, _ = section["entries"].([]toolkit.CodeBody)
+		case "export":
+			exports, _ = section["entries"].([]toolkit.ExportEntry)
+		case "element":
+			elems, _ = section["entries"].([]toolkit.ElementEntry)
+		case "global":
+			globals, _ = section["entries"].([]toolkit.GlobalEntry)
+		case "start":
+			start := section["index"].(uint32)
+			g.Start = &start
+		}
+	}
+	if len(code) != len(funcs) {
+		return nil, fmt.Errorf("%d bodies for %d functions", len(code), len(funcs))
+	}
+
+	t := &targets{
+		tables:  map[uint32]map[uint32]struct{}{},
+		passive: map[uint32]struct{}{},
+		refs:    map[uint32]struct{}{},
+		open:    map[uint32]bool{},
+	}
+	importedTables := uint32(0)
+	for i := range imports {
+		entry := &imports[i]
+		switch entry.Kind {
+		case "function":
+			g.Funcs = append(g.Funcs, &Func{
+				Index:  uint32(len(g.Funcs)),
+				Type:   uint32(entry.Type.(uint64)),
+				Import: entry,
+			})
+		case "table":
+			t.open[importedTables] = true
+			importedTables++
+		}
+	}
+	for _, typ := range funcs {
+		g.Funcs = append(g.Funcs, &Func{Index: uint32(len(g.Funcs)), Type: uint32(typ)})
+	}
+	for _, entry := range exports {
+		switch entry.Kind {
+		case "function":
+			if entry.Index >= uint32(len(g.Funcs)) {
+				return nil, fmt.Errorf("export %q: function %d out of %d", entry.FieldStr, entry.Index, len(g.Funcs))
+			}
+			g.Funcs[entry.Index].Exports = append(g.Funcs[entry.Index].Exports, entry.FieldStr)
+		case "table":
+			t.open[entry.Index] = true
+		}
+	}
+
+	// the functions that may be in the tables.
+	addRefs := func(expr []toolkit.OP, set map[uint32]struct{}) {
+		for _, op := range expr {
+			if op.ReturnType == "ref" && op.Name == "func" {
+				set[op.Immediates.(uint32)] = struct{}{}
+			}
+		}
+	}
+	for _, entry := range elems {
+		var set map[uint32]struct{}
+		switch {
+		case entry.Flags&toolkit.ELEM_FLAG_PASSIVE == 0:
+			set = t.tables[entry.Index]
+			if set == nil {
+				set = map[uint32]struct{}{}
+				t.tables[entry.Index] = set
+			}
+		case entry.Flags&toolkit.ELEM_FLAG_INDEX == 0:
+			set = t.passive
+		default:
+			// a declarative segment only declares the references taken in the code.
+			set = t.refs
+		}
+		for _, elem := range entry.Elements {
+			set[uint32(elem)] = struct{}{}
+		}
+		for _, expr := range entry.Exprs {
+			addRefs(expr, set)
+		}
+	}
+	for _, entry := range globals {
+		addRefs(entry.Init, t.refs)
+	}
+	for _, body := range code {
+		addRefs(body.Code, t.refs)
+	}
+	for _, set := range append([]map[uint32]struct{}{t.passive, t.refs}, tableSets(t)...) {
+		for f := range set {
+			if f < uint32(len(g.Funcs)) {
+				g.Funcs[f].Escaping = true
+			}
+		}
+	}
+
+	importedFuncs := uint32(len(g.Funcs) - len(funcs))
+	for i, body := range code {
+		caller := importedFuncs + uint32(i)
+		for _, op := range body.Code {
+			switch op.Name {
+			case "call", "return_call":
+				callee := op.Immediates.(uint32)
+				if callee >= uint32(len(g.Funcs)) {
+					return nil, fmt.Errorf("function %d: call to function %d out of %d", caller, callee, len(g.Funcs))
+				}
+				g.addEdge(caller, callee, EdgeDirect)
+			case "call_indirect", "return_call_indirect":
+				imm := op.Immediates.(toolkit.JSON)
+				typ := uint32(imm["index"].(uint64))
+				table := imm["reserved"].(uint32)
+				for _, callee := range g.indirectTargets(t, types, table, typ) {
+					g.addEdge(caller, callee, EdgeIndirect)
+				}
+			case "call_ref", "return_call_ref":
+				typ := op.Immediates.(uint32)
+				for _, callee := range g.refTargets(t, types, typ) {
+					g.addEdge(caller, callee, EdgeRef)
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+func tableSets(t *targets) []map[uint32]struct{} {
+	sets := make([]map[uint32]struct{}, 0, len(t.tables))
+	for _, set := range t.tables {
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+func (g *Graph) addEdge(caller, callee uint32, kind EdgeKind) {
+	for _, e := range g.Funcs[caller].Callees {
+		if e.Callee == callee && e.Kind == kind {
+			return
+		}
+	}
+	edge := Edge{Caller: caller, Callee: callee, Kind: kind}
+	g.Funcs[caller].Callees = append(g.Funcs[caller].Callees, edge)
+	g.Funcs[callee].Callers = append(g.Funcs[callee].Callers, edge)
+}
+
+// sameSignature reports whether two function types have the same params and results.
+func sameSignature(types []toolkit.TypeEntry, a, b uint32) bool {
+	if a == b {
+		return true
+	}
+	if a >= uint32(len(types)) || b >= uint32(len(types)) {
+		return false
+	}
+	ta, tb := types[a], types[b]
+	return ta.Form == tb.Form && ta.ReturnType == tb.ReturnType && reflect.DeepEqual(ta.Params, tb.Params)
+}
+
+// indirectTargets returns the functions of a type that may be in a table: the ones of its active segments,
+// of the passive segments and the ones whose reference is taken. The host may store the exported functions
+// in an imported or exported table.
+func (g *Graph) indirectTargets(t *targets, types []toolkit.TypeEntry, table, typ uint32) []uint32 {
+	var callees []uint32
+	for _, f := range g.Funcs {
+		_, active := t.tables[table][f.Index]
+		_, passive := t.passive[f.Index]
+		_, ref := t.refs[f.Index]
+		host := t.open[table] && len(f.Exports) > 0
+		if (active || passive || ref || host) && sameSignature(types, f.Type, typ) {
+			callees = append(callees, f.Index)
+		}
+	}
+	return callees
+}
+
+// refTargets returns the functions of a type whose reference may be taken.
+func (g *Graph) refTargets(t *targets, types []toolkit.TypeEntry, typ uint32) []uint32 {
+	var callees []uint32
+	for _, f := range g.Funcs {
+		if (f.Escaping || len(f.Exports) > 0) && sameSignature(types, f.Type, typ) {
+			callees = append(callees, f.Index)
+		}
+	}
+	return callees
+}
+
+// SCCs returns the strongly connected components of the graph with the algorithm of Tarjan, the callees
+// before their callers. The functions of a component are sorted.
+func (g *Graph) SCCs() [][]uint32 {
+	var (
+		index   = make([]int, len(g.Funcs))
+		low     = make([]int, len(g.Funcs))
+		onStack = make([]bool, len(g.Funcs))
+		stack   []uint32
+		next    = 1
+		sccs    [][]uint32
+	)
+	var visit func(f uint32)
+	visit = func(f uint32) {
+		index[f], low[f] = next, next
+		next++
+		stack = append(stack, f)
+		onStack[f] = true
+		for _, e := range g.Funcs[f].Callees {
+			switch {
+			case index[e.Callee] == 0:
+				visit(e.Callee)
+				if low[e.Callee] < low[f] {
+					low[f] = low[e.Callee]
+				}
+			case onStack[e.Callee] && index[e.Callee] < low[f]:
+				low[f] = index[e.Callee]
+			}
+		}
+		if low[f] != index[f] {
+			return
+		}
+		var scc []uint32
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc = append(scc, top)
+			if top == f {
+				break
+			}
+		}
+		sort.Slice(scc, func(i, j int) bool { return scc[i] < scc[j] })
+		sccs = append(sccs, scc)
+	}
+	for f := range g.Funcs {
+		if index[f] == 0 {
+			visit(uint32(f))
+		}
+	}
+	return sccs
+}
+
+// Recursive returns the components whose functions may call themselves, directly or not.
+func (g *Graph) Recursive() [][]uint32 {
+	var recursive [][]uint32
+	for _, scc := range g.SCCs() {
+		if len(scc) > 1 || g.calls(scc[0], scc[0]) {
+			recursive = append(recursive, scc)
+		}
+	}
+	return recursive
+}
+
+func (g *Graph) calls(caller, callee uint32) bool {
+	for _, e := range g.Funcs[caller].Callees {
+		if e.Callee == callee {
+			return true
+		}
+	}
+	return false
+}
+
+// Reachable returns the functions the given ones may call, directly or not, themselves included.
+func (g *Graph) Reachable(roots ...uint32) []uint32 {
+	visited := make([]bool, len(g.Funcs))
+	stack := append([]uint32{}, roots...)
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f >= uint32(len(g.Funcs)) || visited[f] {
+			continue
+		}
+		visited[f] = true
+		for _, e := range g.Funcs[f].Callees {
+			stack = append(stack, e.Callee)
+		}
+	}
+	var reachable []uint32
+	for f, v := range visited {
+		if v {
+			reachable = append(reachable, uint32(f))
+		}
+	}
+	return reachable
+}
+
+// Exports returns the functions reachable from every exported function by export name.
+func (g *Graph) Exports() map[string][]uint32 {
+	reach := map[string][]uint32{}
+	for _, f := range g.Funcs {
+		for _, name := range f.Exports {
+			reach[name] = g.Reachable(f.Index)
+		}
+	}
+	return reach
+}
+
+// ReachableImports returns the imported functions reachable from the given ones.
+func (g *Graph) ReachableImports(roots ...uint32) []*toolkit.ImportEntry {
+	var imports []*toolkit.ImportEntry
+	for _, f := range g.Reachable(roots...) {
+		if g.Funcs[f].Imported() {
+			imports = append(imports, g.Funcs[f].Import)
+		}
+	}
+	return imports
+}
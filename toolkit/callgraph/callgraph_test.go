@@ -0,0 +1,57 @@
+package callgraph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+func body(t *testing.T, e *toolkit.Emitter) []toolkit.OP {
+	code, err := e.Code()
+	assert.Nil(t, err)
+	return code
+}
+
+func TestGraph(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	unary := b.FuncType([]string{"i32"}, "")
+	log, err := b.ImportFunction("env", "log", unary)
+	assert.Nil(t, err)
+
+	// 1 calls 2 or 3 through the table, 2 and 3 call each other, 4 calls itself and 5 isn't called.
+	main := b.AddFunction(void, nil, body(t, toolkit.NewEmitter().I32Const(0).CallIndirect(void, 0)))
+	even := b.AddFunction(void, nil, body(t, toolkit.NewEmitter().Call(3)))
+	odd := b.AddFunction(void, nil, body(t, toolkit.NewEmitter().I32Const(1).Call(log).Call(2)))
+	loop := b.AddFunction(void, nil, body(t, toolkit.NewEmitter().Call(4)))
+	b.AddFunction(unary, nil, body(t, toolkit.NewEmitter()))
+	assert.Nil(t, b.AddExport("main", "function", main))
+	assert.Nil(t, b.AddExport("loop", "function", loop))
+
+	space := toolkit.NewIndexSpace(b.Module())
+	_, err = space.Insert("table", 0, toolkit.Table{ElementType: "anyFunc", Limits: toolkit.MemLimits{Intial: 3}})
+	assert.Nil(t, err)
+	module := space.Module()
+	module = append(module[:len(module)-1], toolkit.JSON{
+		"name": "element",
+		"entries": []toolkit.ElementEntry{{
+			Offset:   toolkit.NewEmitter().I32Const(0).Ops(),
+			Elements: []uint64{uint64(even), uint64(odd), 5},
+		}},
+	}, module[len(module)-1])
+	assert.Nil(t, toolkit.ValidateModule(module))
+
+	g, err := New(module)
+	assert.Nil(t, err)
+	assert.Equal(t, []Edge{{Caller: main, Callee: even, Kind: EdgeIndirect}, {Caller: main, Callee: odd, Kind: EdgeIndirect}}, g.Funcs[main].Callees)
+	assert.True(t, g.Funcs[5].Escaping)
+	assert.False(t, g.Funcs[loop].Escaping)
+
+	assert.Equal(t, [][]uint32{{even, odd}, {loop}}, g.Recursive())
+	assert.Equal(t, []uint32{log, main, even, odd}, g.Exports()["main"])
+	assert.Equal(t, []uint32{loop}, g.Exports()["loop"])
+	imports := g.ReachableImports(main)
+	assert.Len(t, imports, 1)
+	assert.Equal(t, "log", imports[0].FieldStr)
+}
@@ -0,0 +1,320 @@
+// Package cfg builds the control flow graph of a function body, with the nesting of its blocks, its
+// dominators and its loops.
+package cfg
+
+import (
+	"fmt"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// EdgeKind tells how the control flows along an edge.
+type EdgeKind int
+
+const (
+	EdgeFallthrough EdgeKind = iota // to the next operator, i.e. before a branch target or out of the `then` arm.
+	EdgeBranch                      // a `br`, `br_if`, `br_table` or `br_on_*` to the end of a block.
+	EdgeBack                        // a branch to the start of an enclosing loop.
+	EdgeTrue                        // an `if` to its `then` arm.
+	EdgeFalse                       // an `if` to its `else` arm or its end.
+	EdgeReturn                      // out of the function, by `return` or its end.
+	EdgeThrow                       // an exception, to a handler or out of the function.
+)
+
+var edgeKindNames = map[EdgeKind]string{
+	EdgeFallthrough: "fallthrough",
+	EdgeBranch:      "branch",
+	EdgeBack:        "back",
+	EdgeTrue:        "true",
+	EdgeFalse:       "false",
+	EdgeReturn:      "return",
+	EdgeThrow:       "throw",
+}
+
+func (k EdgeKind) String() string {
+	return edgeKindNames[k]
+}
+
+type Edge struct {
+	From int
+	To   int
+	Kind EdgeKind
+}
+
+// Block is a basic block, the operators [Start, End) of the body.
+type Block struct {
+	ID    int
+	Start int
+	End   int
+	Scope *Scope // the innermost scope the block is in.
+	Succs []Edge
+	Preds []Edge
+}
+
+// Scope is a node of the nesting tree of the blocks, the root is the function body.
+type Scope struct {
+	Kind     string // "func", "block", "loop", "if", "try" or "try_table".
+	Start    int    // the index of the operator opening the scope, -1 for the function.
+	Else     int    // the index of the `else`, -1 if there's none.
+	End      int    // the index of the `end`.
+	Parent   *Scope
+	Children []*Scope
+}
+
+// Depth returns the number of scopes enclosing the scope.
+func (s *Scope) Depth() int {
+	depth := 0
+	for p := s.Parent; p != nil; p = p.Parent {
+		depth++
+	}
+	return depth
+}
+
+// Graph is the control flow graph of a function body. Its first block is the entry, its last one is
+// an empty block that is the exit of the function.
+type Graph struct {
+	Code   []toolkit.OP
+	Blocks []*Block
+	Root   *Scope
+}
+
+func (g *Graph) Entry() *Block {
+	return g.Blocks[0]
+}
+
+func (g *Graph) Exit() *Block {
+	return g.Blocks[len(g.Blocks)-1]
+}
+
+// BlockOf returns the block holding an operator.
+func (g *Graph) BlockOf(op int) *Block {
+	lo, hi := 0, len(g.Blocks)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if g.Blocks[mid].End <= op {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return g.Blocks[lo]
+}
+
+// the operators that can't fall through to the next one.
+var terminators = map[string]struct{}{
+	"br":                   {},
+	"br_table":             {},
+	"return":               {},
+	"unreachable":          {},
+	"return_call":          {},
+	"return_call_indirect": {},
+	"return_call_ref":      {},
+	"throw":                {},
+	"throw_ref":            {},
+	"rethrow":              {},
+}
+
+// the operators branching to a label or falling through.
+var conditionalBranches = map[string]struct{}{
+	"br_if":           {},
+	"br_on_null":      {},
+	"br_on_non_null":  {},
+	"br_on_cast":      {},
+	"br_on_cast_fail": {},
+}
+
+func opensScope(name string) bool {
+	switch name {
+	case "block", "loop", "if", "try", "try_table":
+		return true
+	}
+	return false
+}
+
+// New builds the control flow graph of a function body, the body is ended by `end`.
+func New(body toolkit.CodeBody) (*Graph, error) {
+	code := body.Code
+	if len(code) == 0 || code[len(code)-1].Name != "end" {
+		return nil, fmt.Errorf("the body isn't ended")
+	}
+
+	// the nesting tree and the scope of every operator.
+	root := &Scope{Kind: "func", Start: -1, Else: -1, End: len(code) - 1}
+	scopes := make([]*Scope, len(code))
+	scope := root
+	for i, op := range code {
+		scopes[i] = scope
+		switch {
+		case opensScope(op.Name):
+			child := &Scope{Kind: op.Name, Start: i, Else: -1, Parent: scope}
+			scope.Children = append(scope.Children, child)
+			scope = child
+		case op.Name == "else" || op.Name == "catch" || op.Name == "catch_all":
+			if scope.Parent == nil {
+				return nil, fmt.Errorf("op %d: %s out of a block", i, op.Name)
+			}
+			if op.Name == "else" {
+				scope.Else = i
+			}
+		case op.Name == "end" || op.Name == "delegate":
+			if scope.Parent == nil {
+				if i != len(code)-1 {
+					return nil, fmt.Errorf("op %d: operator after the end of the function", i+1)
+				}
+				break
+			}
+			scope.End = i
+			scope = scope.Parent
+		}
+	}
+	if scope != root {
+		return nil, fmt.Errorf("the block at op %d isn't ended", scope.Start)
+	}
+
+	// the leaders of the basic blocks.
+	leaders := make([]bool, len(code)+1)
+	leaders[0] = true
+	leaders[len(code)] = true
+	for i, op := range code {
+		_, terminator := terminators[op.Name]
+		_, conditional := conditionalBranches[op.Name]
+		switch {
+		case terminator || conditional || op.Name == "if" || op.Name == "try_table":
+			leaders[i+1] = true
+		case op.Name == "else" || op.Name == "catch" || op.Name == "catch_all" || op.Name == "loop":
+			leaders[i] = true
+		case op.Name == "end" || op.Name == "delegate":
+			// the ends of the blocks are branch targets.
+			if scopes[i] != root {
+				leaders[i] = true
+			}
+		case op.Name == "try":
+			// the handlers are entered from the start of the try.
+			leaders[i+1] = true
+		}
+	}
+
+	g := &Graph{Code: code, Root: root}
+	blockOf := make([]int, len(code)+1)
+	for i := 0; i < len(code); {
+		block := &Block{ID: len(g.Blocks), Start: i, Scope: scopes[i]}
+		for i++; !leaders[i]; i++ {
+		}
+		block.End = i
+		for j := block.Start; j < block.End; j++ {
+			blockOf[j] = block.ID
+		}
+		g.Blocks = append(g.Blocks, block)
+	}
+	exit := &Block{ID: len(g.Blocks), Start: len(code), End: len(code), Scope: root}
+	blockOf[len(code)] = exit.ID
+	g.Blocks = append(g.Blocks, exit)
+
+	addEdge := func(from, to int, kind EdgeKind) {
+		for _, e := range g.Blocks[from].Succs {
+			if e.To == to && e.Kind == kind {
+				return
+			}
+		}
+		edge := Edge{From: from, To: to, Kind: kind}
+		g.Blocks[from].Succs = append(g.Blocks[from].Succs, edge)
+		g.Blocks[to].Preds = append(g.Blocks[to].Preds, edge)
+	}
+	// branch adds the edge of a branch to a label, the depth is relative to the scope of the operator.
+	branch := func(from, op int, depth uint32) error {
+		s := scopes[op]
+		for ; depth > 0 && s != nil; depth-- {
+			s = s.Parent
+		}
+		switch {
+		case s == nil:
+			return fmt.Errorf("op %d: branch out of the function", op)
+		case s == root:
+			addEdge(from, exit.ID, EdgeReturn)
+		case s.Kind == "loop":
+			addEdge(from, blockOf[s.Start], EdgeBack)
+		default:
+			addEdge(from, blockOf[s.End], EdgeBranch)
+		}
+		return nil
+	}
+
+	for _, block := range g.Blocks[:exit.ID] {
+		last := block.End - 1
+		op := code[last]
+		falls := true
+		switch op.Name {
+		case "br", "br_if", "br_on_null", "br_on_non_null":
+			if err := branch(block.ID, last, labelOf(op.Immediates)); err != nil {
+				return nil, err
+			}
+		case "br_on_cast", "br_on_cast_fail":
+			imm, _ := op.Immediates.(toolkit.JSON)
+			if err := branch(block.ID, last, labelOf(imm["label"])); err != nil {
+				return nil, err
+			}
+		case "br_table":
+			imm, _ := op.Immediates.(toolkit.JSON)
+			targets, _ := imm["targets"].([]uint64)
+			for _, target := range append(targets, uint64(labelOf(imm["default_target"]))) {
+				if err := branch(block.ID, last, uint32(target)); err != nil {
+					return nil, err
+				}
+			}
+		case "return", "return_call", "return_call_indirect", "return_call_ref":
+			addEdge(block.ID, exit.ID, EdgeReturn)
+		case "throw", "throw_ref", "rethrow":
+			addEdge(block.ID, exit.ID, EdgeThrow)
+		case "if":
+			s := scopes[last+1]
+			addEdge(block.ID, blockOf[last+1], EdgeTrue)
+			if s.Else >= 0 {
+				addEdge(block.ID, blockOf[s.Else], EdgeFalse)
+			} else {
+				addEdge(block.ID, blockOf[s.End], EdgeFalse)
+			}
+			falls = false
+		case "try":
+			// an exception may be thrown anywhere in the try, it's approximated by its start.
+			s := scopes[last+1]
+			for j := s.Start + 1; j < s.End; j++ {
+				if scopes[j] == s && (code[j].Name == "catch" || code[j].Name == "catch_all") {
+					addEdge(block.ID, blockOf[j], EdgeThrow)
+				}
+			}
+		case "try_table":
+			// the labels of the handlers are the ones of the scope of the try_table.
+			imm, _ := op.Immediates.(toolkit.JSON)
+			catches, _ := imm["catches"].([]toolkit.JSON)
+			for _, catch := range catches {
+				if err := branch(block.ID, last, labelOf(catch["label"])); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if _, terminator := terminators[op.Name]; terminator || !falls {
+			continue
+		}
+
+		switch next := last + 1; {
+		case next == len(code):
+			addEdge(block.ID, exit.ID, EdgeReturn)
+		case code[next].Name == "else" || code[next].Name == "catch" || code[next].Name == "catch_all":
+			// the end of an arm, to the end of its scope.
+			addEdge(block.ID, blockOf[scopes[next].End], EdgeFallthrough)
+		default:
+			addEdge(block.ID, block.ID+1, EdgeFallthrough)
+		}
+	}
+	return g, nil
+}
+
+func labelOf(imm interface{}) uint32 {
+	switch imm := imm.(type) {
+	case uint32:
+		return imm
+	case uint64:
+		return uint32(imm)
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+func succs(b *Block) map[int]EdgeKind {
+	m := map[int]EdgeKind{}
+	for _, e := range b.Succs {
+		m[e.To] = e.Kind
+	}
+	return m
+}
+
+func TestGraph(t *testing.T) {
+	code, err := toolkit.NewEmitter().
+		Block(toolkit.BLOCK_TYPE_EMPTY, func(e *toolkit.Emitter) {
+			e.Loop(toolkit.BLOCK_TYPE_EMPTY, func(e *toolkit.Emitter) {
+				e.GetLocal(0).If(toolkit.BLOCK_TYPE_EMPTY, func(e *toolkit.Emitter) {
+					e.Br(2)
+				}, func(e *toolkit.Emitter) {
+					e.Nop()
+				})
+				e.Br(0)
+			})
+		}).
+		Code()
+	assert.Nil(t, err)
+	g, err := New(toolkit.CodeBody{Code: code})
+	assert.Nil(t, err)
+
+	// block | loop get_local if | br 2 | else nop | end br 0 | end | end end | exit
+	assert.Len(t, g.Blocks, 8)
+	assert.Equal(t, map[int]EdgeKind{1: EdgeFallthrough}, succs(g.Blocks[0]))
+	assert.Equal(t, map[int]EdgeKind{2: EdgeTrue, 3: EdgeFalse}, succs(g.Blocks[1]))
+	assert.Equal(t, map[int]EdgeKind{6: EdgeBranch}, succs(g.Blocks[2]))
+	assert.Equal(t, map[int]EdgeKind{4: EdgeFallthrough}, succs(g.Blocks[3]))
+	assert.Equal(t, map[int]EdgeKind{1: EdgeBack}, succs(g.Blocks[4]))
+	assert.Equal(t, map[int]EdgeKind{7: EdgeReturn}, succs(g.Blocks[6]))
+	assert.Equal(t, 3, g.BlockOf(6).ID)
+	assert.Equal(t, "if", g.Blocks[3].Scope.Kind)
+	assert.Equal(t, 3, g.Blocks[3].Scope.Depth())
+
+	dom := g.Dominators()
+	assert.Equal(t, []int{-1, 0, 1, 1, 3, -1, 2, 6}, dom.Idom)
+	assert.True(t, dom.Dominates(1, 4))
+	assert.False(t, dom.Dominates(3, 6))
+
+	nest := g.Loops()
+	assert.Len(t, nest.Loops, 1)
+	assert.Equal(t, []int{1, 3, 4}, nest.Loops[0].Blocks)
+	assert.Equal(t, 1, nest.Depth(3))
+	assert.Equal(t, 0, nest.Depth(2))
+
+	_, err = New(toolkit.CodeBody{Code: []toolkit.OP{{Name: "br", Immediates: uint32(1)}, {Name: "end"}}})
+	assert.NotNil(t, err)
+}
+
+func TestGraphModules(t *testing.T) {
+	dirName := path.Join("..", "test", "wasm")
+	dir, err := ioutil.ReadDir(dirName)
+	assert.Nil(t, err)
+	for _, fi := range dir {
+		wasm, err := ioutil.ReadFile(path.Join(dirName, fi.Name()))
+		assert.Nil(t, err)
+		for _, section := range toolkit.Wasm2Json(wasm) {
+			if section["name"] != "code" {
+				continue
+			}
+			for _, body := range section["entries"].([]toolkit.CodeBody) {
+				g, err := New(body)
+				assert.Nil(t, err, fi.Name())
+				if err == nil {
+					g.Loops()
+				}
+			}
+		}
+	}
+}
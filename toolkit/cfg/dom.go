@@ -0,0 +1,188 @@
+package cfg
+
+import "sort"
+
+// ReversePostorder returns the blocks reachable from the entry in reverse postorder.
+func (g *Graph) ReversePostorder() []int {
+	var (
+		order   []int
+		visited = make([]bool, len(g.Blocks))
+	)
+	var visit func(b int)
+	visit = func(b int) {
+		visited[b] = true
+		for _, e := range g.Blocks[b].Succs {
+			if !visited[e.To] {
+				visit(e.To)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(g.Entry().ID)
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Dominators is the dominator tree of a graph.
+type Dominators struct {
+	Idom     []int   // the immediate dominator of every block, -1 for the entry and the unreachable blocks.
+	Children [][]int // the blocks every block immediately dominates.
+}
+
+// Dominators computes the dominator tree with the algorithm of Cooper, Harvey and Kennedy.
+func (g *Graph) Dominators() *Dominators {
+	order := g.ReversePostorder()
+	rank := make([]int, len(g.Blocks))
+	for i := range rank {
+		rank[i] = -1
+	}
+	for i, b := range order {
+		rank[b] = i
+	}
+
+	idom := make([]int, len(g.Blocks))
+	for i := range idom {
+		idom[i] = -1
+	}
+	entry := g.Entry().ID
+	idom[entry] = entry
+	intersect := func(a, b int) int {
+		for a != b {
+			for rank[a] > rank[b] {
+				a = idom[a]
+			}
+			for rank[b] > rank[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order[1:] {
+			newIdom := -1
+			for _, e := range g.Blocks[b].Preds {
+				if idom[e.From] < 0 {
+					continue
+				}
+				if newIdom < 0 {
+					newIdom = e.From
+				} else {
+					newIdom = intersect(e.From, newIdom)
+				}
+			}
+			if idom[b] != newIdom {
+				idom[b] = newIdom
+				changed = true
+			}
+		}
+	}
+	idom[entry] = -1
+
+	d := &Dominators{Idom: idom, Children: make([][]int, len(g.Blocks))}
+	for b, parent := range idom {
+		if parent >= 0 {
+			d.Children[parent] = append(d.Children[parent], b)
+		}
+	}
+	return d
+}
+
+// Dominates reports whether the block a dominates the block b, a block dominates itself.
+func (d *Dominators) Dominates(a, b int) bool {
+	for ; b >= 0; b = d.Idom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Loop is a natural loop, the blocks reached from its header by the back-edges it dominates.
+type Loop struct {
+	Header   int
+	Blocks   []int // the blocks of the loop and of its inner loops, in order.
+	Parent   *Loop
+	Children []*Loop
+}
+
+// Depth returns the number of loops enclosing the loop, 1 for an outermost loop.
+func (l *Loop) Depth() int {
+	depth := 0
+	for ; l != nil; l = l.Parent {
+		depth++
+	}
+	return depth
+}
+
+// LoopNest is the loop nesting forest of a graph.
+type LoopNest struct {
+	Loops []*Loop // the loops, the outer ones before the inner ones.
+	Of    []*Loop // the innermost loop of every block, nil if it isn't in a loop.
+}
+
+// Depth returns the loop depth of a block, 0 if it isn't in a loop.
+func (n *LoopNest) Depth(b int) int {
+	return n.Of[b].Depth()
+}
+
+// Loops finds the natural loops of a graph, the loops sharing a header are merged.
+func (g *Graph) Loops() *LoopNest {
+	dom := g.Dominators()
+	bodies := map[int]map[int]struct{}{}
+	for _, block := range g.Blocks {
+		for _, e := range block.Succs {
+			if !dom.Dominates(e.To, e.From) {
+				continue
+			}
+			body, exist := bodies[e.To]
+			if !exist {
+				body = map[int]struct{}{e.To: {}}
+				bodies[e.To] = body
+			}
+			// the blocks reaching the back-edge without going through the header.
+			stack := []int{e.From}
+			for len(stack) > 0 {
+				b := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if _, exist := body[b]; exist {
+					continue
+				}
+				body[b] = struct{}{}
+				for _, pred := range g.Blocks[b].Preds {
+					stack = append(stack, pred.From)
+				}
+			}
+		}
+	}
+
+	nest := &LoopNest{Of: make([]*Loop, len(g.Blocks))}
+	for header, body := range bodies {
+		loop := &Loop{Header: header}
+		for b := range body {
+			loop.Blocks = append(loop.Blocks, b)
+		}
+		sort.Ints(loop.Blocks)
+		nest.Loops = append(nest.Loops, loop)
+	}
+	// the outer loops are larger than the loops they contain.
+	sort.Slice(nest.Loops, func(i, j int) bool {
+		if len(nest.Loops[i].Blocks) != len(nest.Loops[j].Blocks) {
+			return len(nest.Loops[i].Blocks) > len(nest.Loops[j].Blocks)
+		}
+		return nest.Loops[i].Header < nest.Loops[j].Header
+	})
+	for _, loop := range nest.Loops {
+		// the innermost loop holding the header so far is the parent.
+		loop.Parent = nest.Of[loop.Header]
+		if loop.Parent != nil {
+			loop.Parent.Children = append(loop.Parent.Children, loop)
+		}
+		for _, b := range loop.Blocks {
+			nest.Of[b] = loop
+		}
+	}
+	return nest
+}
@@ -0,0 +1,164 @@
+package toolkit
+
+import "sort"
+
+// CodeMap maps the offsets of the code section of a module to the ones of the module a transformation
+// generated from it. The functions keep their position in the code section, an offset keeps its distance
+// to the start of the operator it's in.
+type CodeMap struct {
+	Old   []FuncOffsets
+	New   []FuncOffsets
+	OpMap [][]int // the new index of each operator of each function, -1 if it's removed. nil keeps them.
+}
+
+// NewCodeMap creates the map between the code entries before and after a transformation.
+func NewCodeMap(oldCode, newCode []CodeBody, opMap [][]int) *CodeMap {
+	return &CodeMap{
+		Old:   CodeOffsets(oldCode),
+		New:   CodeOffsets(newCode),
+		OpMap: opMap,
+	}
+}
+
+func (m *CodeMap) opIndex(f, k int) int {
+	if f >= len(m.OpMap) || m.OpMap[f] == nil {
+		return k
+	}
+	return m.OpMap[f][k]
+}
+
+// Map maps an offset of the old code section payload, it's false if the operator is removed or the
+// offset is out of the code.
+func (m *CodeMap) Map(offset uint32) (uint32, bool) {
+	f := sort.Search(len(m.Old), func(i int) bool {
+		return m.Old[i].End > offset
+	})
+	if f == len(m.Old) {
+		// the end of the last function.
+		if f > 0 && offset == m.Old[f-1].End {
+			return m.New[f-1].End, true
+		}
+		return offset, false
+	}
+	if offset < m.Old[f].Start {
+		return offset, false
+	}
+	return m.MapFunc(f, offset)
+}
+
+// MapFunc maps an offset in the body of the function at position f of the code section.
+func (m *CodeMap) MapFunc(f int, offset uint32) (uint32, bool) {
+	if f >= len(m.Old) || f >= len(m.New) {
+		return offset, false
+	}
+	oldFunc, newFunc := m.Old[f], m.New[f]
+	if offset == oldFunc.Start {
+		return newFunc.Start, true
+	}
+	if offset == oldFunc.End {
+		return newFunc.End, true
+	}
+
+	ops := oldFunc.Ops
+	k := sort.Search(len(ops), func(i int) bool {
+		return ops[i] > offset
+	}) - 1
+	if k < 0 {
+		// the offset is in the locals, which keep their distance to the code.
+		first := newFunc.End
+		if len(newFunc.Ops) > 0 {
+			first = newFunc.Ops[0]
+		}
+		oldFirst := oldFunc.End
+		if len(ops) > 0 {
+			oldFirst = ops[0]
+		}
+		return first - (oldFirst - offset), true
+	}
+
+	newK := m.opIndex(f, k)
+	if newK < 0 {
+		return offset, false
+	}
+	return newFunc.Ops[newK] + offset - ops[k], true
+}
+
+// Reverse maps an offset of the new code section payload back to the old one, it's false if the offset is
+// in the code the transformation added.
+func (m *CodeMap) Reverse(offset uint32) (uint32, bool) {
+	f := sort.Search(len(m.New), func(i int) bool {
+		return m.New[i].End > offset
+	})
+	if f == len(m.New) || f >= len(m.Old) || offset < m.New[f].Start {
+		return offset, false
+	}
+	newFunc := m.New[f]
+	k := sort.Search(len(newFunc.Ops), func(i int) bool {
+		return newFunc.Ops[i] > offset
+	}) - 1
+	if k < 0 {
+		return offset, false
+	}
+	for old := range m.Old[f].Ops {
+		if m.opIndex(f, old) == k {
+			return m.Old[f].Ops[old] + offset - newFunc.Ops[k], true
+		}
+	}
+	return offset, false
+}
+
+// OpMapping is the offset of an operator before and after a transformation.
+type OpMapping struct {
+	Old uint32 `json:"old"`
+	New uint32 `json:"new"`
+}
+
+// Range is a range of offsets, its end is excluded.
+type Range struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+// FuncMap tells where the operators of a function body ended up after a transformation, the offsets
+// are the ones of the payload of the code section.
+type FuncMap struct {
+	Func     int         `json:"func"`     // the position of the function in the code section.
+	Ops      []OpMapping `json:"ops"`      // the operators that are kept.
+	Injected []Range     `json:"injected"` // the operators that are added.
+}
+
+// Funcs returns the map of every function of the code section.
+func (m *CodeMap) Funcs() []FuncMap {
+	funcs := make([]FuncMap, 0, len(m.New))
+	for f := range m.New {
+		if f >= len(m.Old) {
+			break
+		}
+		oldFunc, newFunc := m.Old[f], m.New[f]
+		funcMap := FuncMap{Func: f}
+
+		kept := make([]bool, len(newFunc.Ops))
+		for k, offset := range oldFunc.Ops {
+			if newK := m.opIndex(f, k); newK >= 0 {
+				funcMap.Ops = append(funcMap.Ops, OpMapping{Old: offset, New: newFunc.Ops[newK]})
+				kept[newK] = true
+			}
+		}
+
+		for k := 0; k < len(newFunc.Ops); k++ {
+			if kept[k] {
+				continue
+			}
+			injected := Range{Start: newFunc.Ops[k], End: newFunc.End}
+			for k < len(newFunc.Ops) && !kept[k] {
+				k++
+			}
+			if k < len(newFunc.Ops) {
+				injected.End = newFunc.Ops[k]
+			}
+			funcMap.Injected = append(funcMap.Injected, injected)
+		}
+		funcs = append(funcs, funcMap)
+	}
+	return funcs
+}
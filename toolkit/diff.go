@@ -0,0 +1,482 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Kinds of the changes of a diff.
+const (
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_CHANGED = "changed"
+	DIFF_KEPT    = "kept"
+)
+
+// the largest number of operator pairs the instruction diff compares, the larger bodies are replaced
+// as a whole past their common prefix and suffix.
+const diffOpsLimit = 1 << 22
+
+// ModuleDiff is the structural difference between two modules (see Diff).
+type ModuleDiff struct {
+	Changes   []Change   `json:"changes"`
+	Functions []FuncDiff `json:"functions"`
+}
+
+// Change is an entry added to a section, removed from it or changed. The imports are identified by their
+// names, the exports by their name, the custom sections by their name and the other entries by their index.
+type Change struct {
+	Section string `json:"section"`
+	Kind    string `json:"kind"`
+	Entry   string `json:"entry"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// FuncDiff is the difference between the bodies of a defined function of both modules, its index may differ
+// if they don't import the same number of functions.
+type FuncDiff struct {
+	Index    uint32  `json:"index"`
+	NewIndex uint32  `json:"new_index"`
+	Runs     []OpRun `json:"runs"`
+}
+
+// OpRun is a run of operators kept, removed or added, the kept ones are only counted.
+type OpRun struct {
+	Kind  string   `json:"kind"`
+	Count int      `json:"count"`
+	Ops   []string `json:"ops,omitempty"`
+}
+
+// Diff compares two modules decoded by Wasm2Json section by section and entry by entry, the bodies of the
+// defined functions are compared operator by operator.
+func Diff(a, b []JSON) *ModuleDiff {
+	d := &ModuleDiff{Changes: []Change{}, Functions: []FuncDiff{}}
+	ma, mb := newDiffModule(a), newDiffModule(b)
+
+	d.diffIndexed("type", ma.describeTypes(), mb.describeTypes())
+	d.diffNamed("import", ma.describeImports(), mb.describeImports())
+	d.diffIndexed("function", ma.describeFuncs(), mb.describeFuncs())
+	d.diffIndexed("table", describeEntries(ma.entries("table")), describeEntries(mb.entries("table")))
+	d.diffIndexed("memory", describeEntries(ma.entries("memory")), describeEntries(mb.entries("memory")))
+	d.diffIndexed("global", ma.describeGlobals(), mb.describeGlobals())
+	d.diffNamed("export", ma.describeExports(), mb.describeExports())
+	d.diffIndexed("start", ma.describeStart(), mb.describeStart())
+	d.diffIndexed("element", describeEntries(ma.entries("element")), describeEntries(mb.entries("element")))
+	d.diffIndexed("data", ma.describeData(), mb.describeData())
+	d.diffNamed("custom", ma.describeCustoms(), mb.describeCustoms())
+	d.diffCode(ma, mb)
+	return d
+}
+
+// Empty reports whether the modules are the same.
+func (d *ModuleDiff) Empty() bool {
+	return len(d.Changes) == 0 && len(d.Functions) == 0
+}
+
+// JSON returns the diff as indented JSON, i.e. for golden files.
+func (d *ModuleDiff) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// String returns the diff as text, a line per change and the runs of operators of every function.
+func (d *ModuleDiff) String() string {
+	var buf bytes.Buffer
+	for _, c := range d.Changes {
+		switch c.Kind {
+		case DIFF_ADDED:
+			fmt.Fprintf(&buf, "%s: + %s: %s\n", c.Section, c.Entry, c.New)
+		case DIFF_REMOVED:
+			fmt.Fprintf(&buf, "%s: - %s: %s\n", c.Section, c.Entry, c.Old)
+		default:
+			fmt.Fprintf(&buf, "%s: ~ %s: %s -> %s\n", c.Section, c.Entry, c.Old, c.New)
+		}
+	}
+	for _, f := range d.Functions {
+		if f.Index == f.NewIndex {
+			fmt.Fprintf(&buf, "function %d:\n", f.Index)
+		} else {
+			fmt.Fprintf(&buf, "function %d -> %d:\n", f.Index, f.NewIndex)
+		}
+		for _, run := range f.Runs {
+			switch run.Kind {
+			case DIFF_KEPT:
+				fmt.Fprintf(&buf, "  = %d ops\n", run.Count)
+			case DIFF_REMOVED:
+				for _, op := range run.Ops {
+					fmt.Fprintf(&buf, "  - %s\n", op)
+				}
+			case DIFF_ADDED:
+				for _, op := range run.Ops {
+					fmt.Fprintf(&buf, "  + %s\n", op)
+				}
+			}
+		}
+	}
+	return buf.String()
+}
+
+// diffIndexed compares the entries of a section by index.
+func (d *ModuleDiff) diffIndexed(section string, a, b []string) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		entry := fmt.Sprintf("%s %d", section, i)
+		switch {
+		case i >= len(b):
+			d.Changes = append(d.Changes, Change{Section: section, Kind: DIFF_REMOVED, Entry: entry, Old: a[i]})
+		case i >= len(a):
+			d.Changes = append(d.Changes, Change{Section: section, Kind: DIFF_ADDED, Entry: entry, New: b[i]})
+		case a[i] != b[i]:
+			d.Changes = append(d.Changes, Change{Section: section, Kind: DIFF_CHANGED, Entry: entry, Old: a[i], New: b[i]})
+		}
+	}
+}
+
+// namedEntry is an entry identified by a name, the entries of the same name are paired in order.
+type namedEntry struct {
+	name        string
+	description string
+}
+
+// diffNamed compares the entries of a section by name, in the order of the first module and then of the second one.
+func (d *ModuleDiff) diffNamed(section string, a, b []namedEntry) {
+	pending := map[string][]string{}
+	for _, entry := range b {
+		pending[entry.name] = append(pending[entry.name], entry.description)
+	}
+	for _, entry := range a {
+		descriptions := pending[entry.name]
+		if len(descriptions) == 0 {
+			d.Changes = append(d.Changes, Change{Section: section, Kind: DIFF_REMOVED, Entry: entry.name, Old: entry.description})
+			continue
+		}
+		if descriptions[0] != entry.description {
+			d.Changes = append(d.Changes, Change{Section: section, Kind: DIFF_CHANGED, Entry: entry.name, Old: entry.description, New: descriptions[0]})
+		}
+		pending[entry.name] = descriptions[1:]
+	}
+	for _, entry := range b {
+		if descriptions := pending[entry.name]; len(descriptions) > 0 {
+			d.Changes = append(d.Changes, Change{Section: section, Kind: DIFF_ADDED, Entry: entry.name, New: descriptions[0]})
+			pending[entry.name] = descriptions[1:]
+		}
+	}
+}
+
+// diffCode compares the locals and the bodies of the defined functions by position.
+func (d *ModuleDiff) diffCode(a, b *diffModule) {
+	bodiesA, _ := a.entries("code").([]CodeBody)
+	bodiesB, _ := b.entries("code").([]CodeBody)
+	for i := 0; i < len(bodiesA) && i < len(bodiesB); i++ {
+		index, newIndex := a.importedFuncs+uint32(i), b.importedFuncs+uint32(i)
+		if localsA, localsB := describeLocals(bodiesA[i].Locals), describeLocals(bodiesB[i].Locals); localsA != localsB {
+			d.Changes = append(d.Changes, Change{
+				Section: "code",
+				Kind:    DIFF_CHANGED,
+				Entry:   fmt.Sprintf("locals of function %d", index),
+				Old:     localsA,
+				New:     localsB,
+			})
+		}
+		opsA, opsB := describeOps(bodiesA[i].Code), describeOps(bodiesB[i].Code)
+		if reflect.DeepEqual(opsA, opsB) {
+			continue
+		}
+		d.Functions = append(d.Functions, FuncDiff{Index: index, NewIndex: newIndex, Runs: diffOps(opsA, opsB)})
+	}
+}
+
+// diffOps lines up the operators of two bodies with their longest common subsequence.
+func diffOps(a, b []string) []OpRun {
+	var runs []OpRun
+	add := func(kind, op string) {
+		if len(runs) == 0 || runs[len(runs)-1].Kind != kind {
+			runs = append(runs, OpRun{Kind: kind})
+		}
+		run := &runs[len(runs)-1]
+		run.Count++
+		if kind != DIFF_KEPT {
+			run.Ops = append(run.Ops, op)
+		}
+	}
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	for _, op := range a[:prefix] {
+		add(DIFF_KEPT, op)
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(midA)*len(midB) > diffOpsLimit {
+		for _, op := range midA {
+			add(DIFF_REMOVED, op)
+		}
+		for _, op := range midB {
+			add(DIFF_ADDED, op)
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:].
+		lcs := make([][]int, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				switch {
+				case midA[i] == midB[j]:
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				case lcs[i+1][j] >= lcs[i][j+1]:
+					lcs[i][j] = lcs[i+1][j]
+				default:
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(midA) || j < len(midB) {
+			switch {
+			case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+				add(DIFF_KEPT, midA[i])
+				i++
+				j++
+			case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+				add(DIFF_REMOVED, midA[i])
+				i++
+			default:
+				add(DIFF_ADDED, midB[j])
+				j++
+			}
+		}
+	}
+
+	for _, op := range a[len(a)-suffix:] {
+		add(DIFF_KEPT, op)
+	}
+	return runs
+}
+
+// diffModule is a module with the index spaces the descriptions of its entries need.
+type diffModule struct {
+	module        []JSON
+	types         []TypeEntry
+	importedFuncs uint32
+}
+
+func newDiffModule(module []JSON) *diffModule {
+	m := &diffModule{module: module}
+	m.types, _ = m.entries("type").([]TypeEntry)
+	for _, entry := range NewIndexSpace(module).imports() {
+		if entry.Kind == "function" {
+			m.importedFuncs++
+		}
+	}
+	return m
+}
+
+func (m *diffModule) entries(section string) interface{} {
+	if pos := findSectionByName(m.module, section); pos >= 0 {
+		return m.module[pos]["entries"]
+	}
+	return nil
+}
+
+func (m *diffModule) describeTypes() []string {
+	var descriptions []string
+	for _, typ := range m.types {
+		descriptions = append(descriptions, describeType(typ))
+	}
+	return descriptions
+}
+
+// describeFuncType describes a function type by its signature, the index of a type that doesn't exist otherwise.
+func (m *diffModule) describeFuncType(index uint64) string {
+	if index < uint64(len(m.types)) {
+		return describeType(m.types[index])
+	}
+	return fmt.Sprintf("(type %d)", index)
+}
+
+func (m *diffModule) describeImports() []namedEntry {
+	var entries []namedEntry
+	for _, entry := range NewIndexSpace(m.module).imports() {
+		description := fmt.Sprintf("%s %v", entry.Kind, entry.Type)
+		if entry.Kind == "function" {
+			description = m.describeFuncType(entry.Type.(uint64))
+		}
+		entries = append(entries, namedEntry{name: entry.ModuleStr + "." + entry.FieldStr, description: description})
+	}
+	return entries
+}
+
+func (m *diffModule) describeFuncs() []string {
+	var descriptions []string
+	funcs, _ := m.entries("function").([]uint64)
+	for _, typ := range funcs {
+		descriptions = append(descriptions, m.describeFuncType(typ))
+	}
+	return descriptions
+}
+
+func (m *diffModule) describeGlobals() []string {
+	var descriptions []string
+	globals, _ := m.entries("global").([]GlobalEntry)
+	for _, entry := range globals {
+		mutability := ""
+		if entry.Type.Mutability != 0 {
+			mutability = "mut "
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s%s = %s", mutability, entry.Type.ContentType, strings.Join(describeOps(entry.Init), " ")))
+	}
+	return descriptions
+}
+
+func (m *diffModule) describeExports() []namedEntry {
+	var entries []namedEntry
+	exports, _ := m.entries("export").([]ExportEntry)
+	for _, entry := range exports {
+		entries = append(entries, namedEntry{name: entry.FieldStr, description: fmt.Sprintf("%s %d", entry.Kind, entry.Index)})
+	}
+	return entries
+}
+
+func (m *diffModule) describeStart() []string {
+	if pos := findSectionByName(m.module, "start"); pos >= 0 {
+		return []string{fmt.Sprintf("function %d", m.module[pos]["index"])}
+	}
+	return nil
+}
+
+func (m *diffModule) describeData() []string {
+	var descriptions []string
+	segments, _ := m.entries("data").([]DataSegment)
+	for _, entry := range segments {
+		description := "passive"
+		if entry.Flags&DATA_FLAG_PASSIVE == 0 {
+			description = fmt.Sprintf("memory %d at %s", entry.Index, strings.Join(describeOps(entry.Offset), " "))
+		}
+		data := entry.Data
+		if len(data) > 16 {
+			data = data[:16]
+		}
+		description += fmt.Sprintf(": %d bytes %x", len(entry.Data), data)
+		if len(data) < len(entry.Data) {
+			description += fmt.Sprintf("... %08x", checksum(entry.Data))
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+func (m *diffModule) describeCustoms() []namedEntry {
+	var entries []namedEntry
+	for _, section := range m.module {
+		if section["name"] != "custom" {
+			continue
+		}
+		name, _ := section["section_name"].(string)
+		payload, _ := section["payload"].(string)
+		entries = append(entries, namedEntry{name: name, description: fmt.Sprintf("%d bytes %08x", len(payload), checksum([]byte(payload)))})
+	}
+	return entries
+}
+
+// checksum tells the payloads of the same size apart.
+func checksum(data []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(data)
+	return h.Sum32()
+}
+
+// describeType describes a type like the text format, i.e. `func (param i32) (result i64)`.
+func describeType(typ TypeEntry) string {
+	if typ.Form != "func" {
+		return fmt.Sprintf("%s %v", typ.Form, typ.Fields)
+	}
+	description := "func"
+	if len(typ.Params) > 0 {
+		description += " (param " + strings.Join(typ.Params, " ") + ")"
+	}
+	if typ.ReturnType != "" {
+		description += " (result " + typ.ReturnType + ")"
+	}
+	return description
+}
+
+func describeEntries(entries interface{}) []string {
+	var descriptions []string
+	if entries == nil {
+		return nil
+	}
+	v := reflect.ValueOf(entries)
+	for i := 0; i < v.Len(); i++ {
+		descriptions = append(descriptions, fmt.Sprintf("%+v", v.Index(i).Interface()))
+	}
+	return descriptions
+}
+
+func describeLocals(locals []LocalEntry) string {
+	var parts []string
+	for _, local := range locals {
+		parts = append(parts, fmt.Sprintf("%d %s", local.Count, local.Type))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeOps(ops []OP) []string {
+	descriptions := make([]string, 0, len(ops))
+	for _, op := range ops {
+		descriptions = append(descriptions, describeOp(op))
+	}
+	return descriptions
+}
+
+// describeOp describes an operator like Text2Json reads it, its name and then its immediates.
+func describeOp(op OP) string {
+	name := fullOpName(op)
+	switch imm := op.Immediates.(type) {
+	case nil:
+		return name
+	case string:
+		if imm == BLOCK_TYPE_EMPTY {
+			return name
+		}
+	case []byte:
+		if len(imm) == 4 {
+			return fmt.Sprintf("%s %v", name, math.Float32frombits(uint32(littleEndian(imm))))
+		}
+		if len(imm) == 8 {
+			return fmt.Sprintf("%s %v", name, math.Float64frombits(littleEndian(imm)))
+		}
+	case JSON:
+		keys := make([]string, 0, len(imm))
+		for key := range imm {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := []string{name}
+		for _, key := range keys {
+			parts = append(parts, fmt.Sprintf("%s=%v", key, imm[key]))
+		}
+		return strings.Join(parts, " ")
+	}
+	return fmt.Sprintf("%s %v", name, op.Immediates)
+}
+
+func littleEndian(buf []byte) uint64 {
+	var v uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[i])
+	}
+	return v
+}
@@ -0,0 +1,1046 @@
+package toolkit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The addresses of the DWARF sections of a WebAssembly module are offsets in the payload of the code section.
+// RewriteDWARF maps them through a CodeMap: the line programs are generated back, the addresses and the
+// lengths in the other sections are patched in place so that the offsets between the sections are kept.
+
+const DWARF_SECTION_PREFIX = ".debug_"
+
+// attributes.
+const (
+	DW_AT_location        = 0x02
+	DW_AT_stmt_list       = 0x10
+	DW_AT_low_pc          = 0x11
+	DW_AT_high_pc         = 0x12
+	DW_AT_frame_base      = 0x40
+	DW_AT_entry_pc        = 0x52
+	DW_AT_ranges          = 0x55
+	DW_AT_addr_base       = 0x73
+	DW_AT_rnglists_base   = 0x74
+	DW_AT_call_return_pc  = 0x7d
+	DW_AT_call_pc         = 0x81
+	DW_AT_loclists_base   = 0x8c
+	DW_AT_GNU_addr_base   = 0x2133
+	DW_AT_GNU_ranges_base = 0x2132
+)
+
+// forms.
+const (
+	DW_FORM_addr           = 0x01
+	DW_FORM_block2         = 0x03
+	DW_FORM_block4         = 0x04
+	DW_FORM_data2          = 0x05
+	DW_FORM_data4          = 0x06
+	DW_FORM_data8          = 0x07
+	DW_FORM_string         = 0x08
+	DW_FORM_block          = 0x09
+	DW_FORM_block1         = 0x0a
+	DW_FORM_data1          = 0x0b
+	DW_FORM_flag           = 0x0c
+	DW_FORM_sdata          = 0x0d
+	DW_FORM_strp           = 0x0e
+	DW_FORM_udata          = 0x0f
+	DW_FORM_ref_addr       = 0x10
+	DW_FORM_ref1           = 0x11
+	DW_FORM_ref2           = 0x12
+	DW_FORM_ref4           = 0x13
+	DW_FORM_ref8           = 0x14
+	DW_FORM_ref_udata      = 0x15
+	DW_FORM_indirect       = 0x16
+	DW_FORM_sec_offset     = 0x17
+	DW_FORM_exprloc        = 0x18
+	DW_FORM_flag_present   = 0x19
+	DW_FORM_strx           = 0x1a
+	DW_FORM_addrx          = 0x1b
+	DW_FORM_ref_sup4       = 0x1c
+	DW_FORM_strp_sup       = 0x1d
+	DW_FORM_data16         = 0x1e
+	DW_FORM_line_strp      = 0x1f
+	DW_FORM_ref_sig8       = 0x20
+	DW_FORM_implicit_const = 0x21
+	DW_FORM_loclistx       = 0x22
+	DW_FORM_rnglistx       = 0x23
+	DW_FORM_ref_sup8       = 0x24
+	DW_FORM_strx1          = 0x25
+	DW_FORM_strx2          = 0x26
+	DW_FORM_strx3          = 0x27
+	DW_FORM_strx4          = 0x28
+	DW_FORM_addrx1         = 0x29
+	DW_FORM_addrx2         = 0x2a
+	DW_FORM_addrx3         = 0x2b
+	DW_FORM_addrx4         = 0x2c
+	DW_FORM_GNU_addr_index = 0x1f01
+	DW_FORM_GNU_str_index  = 0x1f02
+	DW_FORM_GNU_ref_alt    = 0x1f20
+	DW_FORM_GNU_strp_alt   = 0x1f21
+)
+
+// standard and extended opcodes of the line number programs.
+const (
+	DW_LNS_copy             = 0x01
+	DW_LNS_advance_pc       = 0x02
+	DW_LNS_advance_line     = 0x03
+	DW_LNS_const_add_pc     = 0x08
+	DW_LNS_fixed_advance_pc = 0x09
+	DW_LNE_end_sequence     = 0x01
+	DW_LNE_set_address      = 0x02
+)
+
+// IsDWARFSection reports whether a section is a custom section holding DWARF debug info.
+func IsDWARFSection(section JSON) bool {
+	name, _ := section["section_name"].(string)
+	return section["name"] == "custom" && strings.HasPrefix(name, DWARF_SECTION_PREFIX)
+}
+
+// StripDWARF removes the DWARF sections of a module, it returns the names of the removed sections.
+func StripDWARF(module []JSON) ([]JSON, []string) {
+	var (
+		stripped []JSON
+		sections []string
+	)
+	for _, section := range module {
+		if IsDWARFSection(section) {
+			sections = append(sections, section["section_name"].(string))
+			continue
+		}
+		stripped = append(stripped, section)
+	}
+	return stripped, sections
+}
+
+// RewriteDWARF maps the code addresses of the DWARF sections of a module through the code map.
+// The call frame information isn't supported.
+func RewriteDWARF(module []JSON, codeMap *CodeMap) error {
+	w := &dwarfRewriter{
+		codeMap:  codeMap,
+		sections: map[string][]byte{},
+		lineMap:  map[uint64]uint64{},
+		ranges:   map[uint64]*dwarfUnit{},
+		locs:     map[uint64]*dwarfUnit{},
+		rnglists: map[uint64]*dwarfUnit{},
+		loclists: map[uint64]*dwarfUnit{},
+		addrs:    map[int]struct{}{},
+	}
+	for _, section := range module {
+		if IsDWARFSection(section) {
+			w.sections[section["section_name"].(string)] = []byte(section["payload"].(string))
+		}
+	}
+	if _, exist := w.sections[".debug_frame"]; exist {
+		return fmt.Errorf(".debug_frame: the call frame information can't be rewritten")
+	}
+	if addr, exist := w.sections[".debug_addr"]; exist {
+		w.oldAddr = append([]byte{}, addr...)
+	}
+
+	steps := []struct {
+		name    string
+		rewrite func(buf []byte) ([]byte, error)
+	}{
+		// the line programs change size, the units refer to their new offsets.
+		{".debug_line", w.rewriteLine},
+		{".debug_info", w.rewriteInfo},
+		{".debug_aranges", w.rewriteAranges},
+		{".debug_ranges", w.rewriteRanges},
+		{".debug_loc", w.rewriteLoc},
+		{".debug_rnglists", w.rewriteRnglists},
+		{".debug_loclists", w.rewriteLoclists},
+		{".debug_addr", w.rewriteAddr},
+	}
+	for _, step := range steps {
+		buf, exist := w.sections[step.name]
+		if !exist {
+			continue
+		}
+		buf, err := step.rewrite(buf)
+		if err != nil {
+			return fmt.Errorf("%s: %v", step.name, err)
+		}
+		w.sections[step.name] = buf
+	}
+
+	for _, section := range module {
+		if IsDWARFSection(section) {
+			section["payload"] = string(w.sections[section["section_name"].(string)])
+		}
+	}
+	return nil
+}
+
+type dwarfRewriter struct {
+	codeMap  *CodeMap
+	sections map[string][]byte
+
+	lineMap map[uint64]uint64 // the new offset of every line program.
+
+	// the unit of every list, by offset. The first unit that refers to a list wins.
+	ranges   map[uint64]*dwarfUnit
+	locs     map[uint64]*dwarfUnit
+	rnglists map[uint64]*dwarfUnit
+	loclists map[uint64]*dwarfUnit
+
+	oldAddr  []byte
+	addrSize int
+	addrs    map[int]struct{} // the offsets of the code addresses in .debug_addr.
+}
+
+// mapAddr maps a code address, the addresses out of the code (i.e. the tombstones of the removed
+// functions) are kept.
+func (w *dwarfRewriter) mapAddr(addr uint64) uint64 {
+	if addr > math.MaxUint32 {
+		return addr
+	}
+	newAddr, exist := w.codeMap.Map(uint32(addr))
+	if !exist {
+		return addr
+	}
+	return uint64(newAddr)
+}
+
+// mapRange maps a range of code given by its start and its length.
+func (w *dwarfRewriter) mapRange(start, length uint64) (uint64, uint64) {
+	newStart := w.mapAddr(start)
+	return newStart, w.mapAddr(start+length) - newStart
+}
+
+// dwarfReader reads the little endian numbers of the DWARF sections, it stops at the first error.
+type dwarfReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *dwarfReader) bytes(n int) []byte {
+	if n < 0 {
+		n = 0
+		r.err = fmt.Errorf("invalid size at offset %d", r.pos)
+	}
+	if r.err == nil && r.pos+n > len(r.buf) {
+		r.err = fmt.Errorf("unexpected end at offset %d", r.pos)
+	}
+	if r.err != nil {
+		r.pos = len(r.buf)
+		return make([]byte, n)
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *dwarfReader) u8() uint8 {
+	return r.bytes(1)[0]
+}
+
+// uint reads a number of the given size.
+func (r *dwarfReader) uint(size int) uint64 {
+	b := r.bytes(size)
+	v := uint64(0)
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// uleb reads an unsigned LEB128 number and returns its width.
+func (r *dwarfReader) uleb() (uint64, int) {
+	start := r.pos
+	v, shift := uint64(0), uint(0)
+	for {
+		b := r.u8()
+		if r.err != nil {
+			return 0, 0
+		}
+		if shift < 64 {
+			v |= uint64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			return v, r.pos - start
+		}
+	}
+}
+
+func (r *dwarfReader) sleb() int64 {
+	v, shift := int64(0), uint(0)
+	for {
+		b := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if shift < 64 {
+			v |= int64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= ^0 << shift
+			}
+			return v
+		}
+	}
+}
+
+func (r *dwarfReader) cstring() {
+	for r.err == nil && r.u8() != 0 {
+	}
+}
+
+// unitLength reads the length of a unit, it returns the size of the offsets of the unit and its end.
+func (r *dwarfReader) unitLength() (offsetSize int, end int) {
+	length := r.uint(4)
+	offsetSize = 4
+	if length == 0xffffffff {
+		length = r.uint(8)
+		offsetSize = 8
+	}
+	end = r.pos + int(length)
+	if length > uint64(len(r.buf)) || end > len(r.buf) {
+		r.err = fmt.Errorf("unit at offset %d overflows the section", r.pos)
+	}
+	return
+}
+
+func putUint(buf []byte, size int, v uint64) {
+	for i := 0; i < size; i++ {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+}
+
+// putULEB writes an unsigned LEB128 number over one of the given width.
+func putULEB(buf []byte, width int, v uint64) error {
+	if uleb128Len(v) > width {
+		return fmt.Errorf("%d doesn't fit in a LEB128 number of %d bytes", v, width)
+	}
+	for i := 0; i < width; i++ {
+		buf[i] = byte(v&0x7f) | 0x80
+		v >>= 7
+	}
+	buf[width-1] &= 0x7f
+	return nil
+}
+
+// rewriteLine generates back the line programs with the new addresses, a row is moved with the smallest
+// operators that reach its new address. The other operators are kept.
+func (w *dwarfRewriter) rewriteLine(buf []byte) ([]byte, error) {
+	var out []byte
+	r := &dwarfReader{buf: buf}
+	for r.pos < len(buf) && r.err == nil {
+		unitStart := r.pos
+		offsetSize, end := r.unitLength()
+		lengthSize := r.pos - unitStart
+		version := r.uint(2)
+		if version < 2 || version > 5 {
+			return nil, fmt.Errorf("unsupported line table version %d", version)
+		}
+		if version >= 5 {
+			r.bytes(2) // address_size and segment_selector_size.
+		}
+		headerLength := r.uint(offsetSize)
+		programStart := r.pos + int(headerLength)
+		minInst := uint64(r.u8())
+		if version >= 4 {
+			r.u8() // maximum_operations_per_instruction.
+		}
+		r.u8() // default_is_stmt.
+		lineBase := int(int8(r.u8()))
+		lineRange := int(r.u8())
+		opcodeBase := int(r.u8())
+		opcodeLengths := r.bytes(opcodeBase - 1)
+		if r.err != nil {
+			return nil, r.err
+		}
+		if lineRange == 0 || minInst == 0 || programStart > end {
+			return nil, fmt.Errorf("invalid line table header at offset %d", unitStart)
+		}
+
+		r.pos = programStart
+		program := &lineProgram{
+			w:          w,
+			minInst:    minInst,
+			lineBase:   lineBase,
+			lineRange:  lineRange,
+			opcodeBase: opcodeBase,
+		}
+		if err := program.rewrite(&dwarfReader{buf: buf[programStart:end]}, opcodeLengths); err != nil {
+			return nil, fmt.Errorf("line table at offset %d: %v", unitStart, err)
+		}
+		r.pos = end
+
+		w.lineMap[uint64(unitStart)] = uint64(len(out))
+		unit := append([]byte{}, buf[unitStart:programStart]...)
+		unit = append(unit, program.out...)
+		putUint(unit[lengthSize-offsetSize:], offsetSize, uint64(len(unit)-lengthSize))
+		out = append(out, unit...)
+	}
+	return out, r.err
+}
+
+type lineProgram struct {
+	w          *dwarfRewriter
+	minInst    uint64
+	lineBase   int
+	lineRange  int
+	opcodeBase int
+
+	out      []byte
+	address  uint64 // the old address of the state machine.
+	emitted  uint64 // the new address of the generated program.
+	setAddr  bool   // whether the next row is located by DW_LNE_set_address.
+	addrSize int
+}
+
+func (p *lineProgram) rewrite(r *dwarfReader, opcodeLengths []byte) error {
+	p.reset()
+	for r.pos < len(r.buf) && r.err == nil {
+		start := r.pos
+		opcode := int(r.u8())
+		switch {
+		case opcode >= p.opcodeBase:
+			adjusted := opcode - p.opcodeBase
+			p.address += uint64(adjusted/p.lineRange) * p.minInst
+			p.row(p.lineBase+adjusted%p.lineRange, false)
+		case opcode == 0:
+			length, _ := r.uleb()
+			body := r.bytes(int(length))
+			if length == 0 {
+				p.out = append(p.out, r.buf[start:r.pos]...)
+				continue
+			}
+			switch body[0] {
+			case DW_LNE_end_sequence:
+				p.moveTo()
+				p.out = append(p.out, 0, 1, DW_LNE_end_sequence)
+				p.reset()
+			case DW_LNE_set_address:
+				p.addrSize = len(body) - 1
+				p.address = (&dwarfReader{buf: body[1:]}).uint(p.addrSize)
+				p.setAddr = true
+			default:
+				p.out = append(p.out, r.buf[start:r.pos]...)
+			}
+		case opcode == DW_LNS_copy:
+			p.row(0, true)
+		case opcode == DW_LNS_advance_pc:
+			advance, _ := r.uleb()
+			p.address += advance * p.minInst
+		case opcode == DW_LNS_const_add_pc:
+			p.address += uint64((255-p.opcodeBase)/p.lineRange) * p.minInst
+		case opcode == DW_LNS_fixed_advance_pc:
+			p.address += r.uint(2)
+		default:
+			// the operators that don't move the address are kept, they skip their LEB128 arguments.
+			if opcode == DW_LNS_advance_line {
+				r.sleb()
+			} else {
+				for i := 0; i < int(opcodeLengths[opcode-1]); i++ {
+					r.uleb()
+				}
+			}
+			p.out = append(p.out, r.buf[start:r.pos]...)
+		}
+	}
+	return r.err
+}
+
+func (p *lineProgram) reset() {
+	p.address, p.emitted = 0, 0
+	p.setAddr = false
+	if p.addrSize == 0 {
+		p.addrSize = 4
+	}
+}
+
+// moveTo moves the generated program to the new address of the current row.
+func (p *lineProgram) moveTo() {
+	target := p.w.mapAddr(p.address)
+	if p.setAddr || target < p.emitted {
+		p.out = append(p.out, 0)
+		p.out = appendULEB(p.out, uint64(1+p.addrSize))
+		p.out = append(p.out, DW_LNE_set_address)
+		addr := make([]byte, p.addrSize)
+		putUint(addr, p.addrSize, target)
+		p.out = append(p.out, addr...)
+		p.setAddr = false
+	} else if target > p.emitted {
+		p.out = append(p.out, DW_LNS_advance_pc)
+		p.out = appendULEB(p.out, (target-p.emitted)/p.minInst)
+	}
+	p.emitted = target
+}
+
+// row appends a row, with a special opcode if it's possible.
+func (p *lineProgram) row(lineDelta int, isCopy bool) {
+	target := p.w.mapAddr(p.address)
+	if !isCopy && !p.setAddr && target >= p.emitted && lineDelta >= p.lineBase && lineDelta < p.lineBase+p.lineRange {
+		opcode := uint64(lineDelta-p.lineBase) + uint64(p.lineRange)*((target-p.emitted)/p.minInst) + uint64(p.opcodeBase)
+		if opcode <= 255 {
+			p.out = append(p.out, byte(opcode))
+			p.emitted = target
+			return
+		}
+	}
+
+	p.moveTo()
+	if isCopy {
+		p.out = append(p.out, DW_LNS_copy)
+		return
+	}
+	if lineDelta < p.lineBase || lineDelta >= p.lineBase+p.lineRange {
+		p.out = append(p.out, DW_LNS_advance_line)
+		p.out = appendSLEB(p.out, int64(lineDelta))
+		lineDelta = 0
+		if lineDelta < p.lineBase || lineDelta >= p.lineBase+p.lineRange {
+			p.out = append(p.out, DW_LNS_copy)
+			return
+		}
+	}
+	p.out = append(p.out, byte(lineDelta-p.lineBase+p.opcodeBase))
+}
+
+func appendULEB(out []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		out = append(out, c)
+		if v == 0 {
+			return out
+		}
+	}
+}
+
+func appendSLEB(out []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		s := v & 0x40
+		v >>= 7
+		if (v == 0 && s == 0) || (v == -1 && s != 0) {
+			return append(out, c)
+		}
+		out = append(out, c|0x80)
+	}
+}
+
+type abbrevAttr struct {
+	attr, form uint64
+}
+
+type abbrev struct {
+	attrs []abbrevAttr
+}
+
+func (w *dwarfRewriter) parseAbbrevs(offset uint64) (map[uint64]abbrev, error) {
+	r := &dwarfReader{buf: w.sections[".debug_abbrev"], pos: int(offset)}
+	abbrevs := map[uint64]abbrev{}
+	for r.err == nil {
+		code, _ := r.uleb()
+		if code == 0 {
+			break
+		}
+		r.uleb() // tag.
+		r.u8()   // children.
+		var a abbrev
+		for r.err == nil {
+			attr, _ := r.uleb()
+			form, _ := r.uleb()
+			if attr == 0 && form == 0 {
+				break
+			}
+			if form == DW_FORM_implicit_const {
+				r.sleb()
+			}
+			a.attrs = append(a.attrs, abbrevAttr{attr, form})
+		}
+		abbrevs[code] = a
+	}
+	return abbrevs, r.err
+}
+
+// dwarfUnit is a unit of .debug_info.
+type dwarfUnit struct {
+	version      uint64
+	offsetSize   int
+	addrSize     int
+	base         uint64
+	addrBase     uint64
+	rnglistsBase uint64
+	loclistsBase uint64
+
+	rnglistx []uint64
+	loclistx []uint64
+}
+
+// attrValue is an attribute of a DIE, its value starts at pos.
+type attrValue struct {
+	attr, form uint64
+	pos, width int
+	value      uint64
+}
+
+func (w *dwarfRewriter) rewriteInfo(buf []byte) ([]byte, error) {
+	r := &dwarfReader{buf: buf}
+	for r.pos < len(buf) && r.err == nil {
+		unit := &dwarfUnit{}
+		unitStart := r.pos
+		var end int
+		unit.offsetSize, end = r.unitLength()
+		unit.version = r.uint(2)
+		if unit.version < 2 || unit.version > 5 {
+			return nil, fmt.Errorf("unsupported unit version %d", unit.version)
+		}
+		var abbrevOffset uint64
+		if unit.version >= 5 {
+			unitType := r.u8()
+			unit.addrSize = int(r.u8())
+			abbrevOffset = r.uint(unit.offsetSize)
+			switch unitType {
+			case 2, 6: // type units.
+				r.bytes(8 + unit.offsetSize)
+			case 4, 5: // skeleton and split units.
+				r.bytes(8)
+			}
+		} else {
+			abbrevOffset = r.uint(unit.offsetSize)
+			unit.addrSize = int(r.u8())
+		}
+		w.addrSize = unit.addrSize
+		abbrevs, err := w.parseAbbrevs(abbrevOffset)
+		if err != nil {
+			return nil, fmt.Errorf("abbreviations at offset %d: %v", abbrevOffset, err)
+		}
+
+		for first := true; r.pos < end && r.err == nil; first = false {
+			code, _ := r.uleb()
+			if code == 0 {
+				continue
+			}
+			a, exist := abbrevs[code]
+			if !exist {
+				return nil, fmt.Errorf("unit at offset %d: unknown abbreviation %d", unitStart, code)
+			}
+			values := make([]attrValue, 0, len(a.attrs))
+			for _, attr := range a.attrs {
+				values = append(values, w.readAttr(r, unit, attr))
+			}
+			if r.err != nil {
+				break
+			}
+			if first {
+				w.unitBases(unit, values)
+			}
+			if err := w.rewriteDIE(buf, unit, values); err != nil {
+				return nil, fmt.Errorf("unit at offset %d: %v", unitStart, err)
+			}
+		}
+		w.resolveLists(unit)
+		r.pos = end
+	}
+	return buf, r.err
+}
+
+func (w *dwarfRewriter) readAttr(r *dwarfReader, unit *dwarfUnit, attr abbrevAttr) attrValue {
+	v := attrValue{attr: attr.attr, form: attr.form, pos: r.pos}
+	fixed := func(size int) {
+		v.width = size
+		v.value = r.uint(size)
+	}
+	switch attr.form {
+	case DW_FORM_addr:
+		fixed(unit.addrSize)
+	case DW_FORM_data1, DW_FORM_ref1, DW_FORM_flag, DW_FORM_strx1, DW_FORM_addrx1:
+		fixed(1)
+	case DW_FORM_data2, DW_FORM_ref2, DW_FORM_strx2, DW_FORM_addrx2:
+		fixed(2)
+	case DW_FORM_strx3, DW_FORM_addrx3:
+		fixed(3)
+	case DW_FORM_data4, DW_FORM_ref4, DW_FORM_ref_sup4, DW_FORM_strx4, DW_FORM_addrx4:
+		fixed(4)
+	case DW_FORM_data8, DW_FORM_ref8, DW_FORM_ref_sig8, DW_FORM_ref_sup8:
+		fixed(8)
+	case DW_FORM_data16:
+		r.bytes(16)
+	case DW_FORM_strp, DW_FORM_sec_offset, DW_FORM_line_strp, DW_FORM_strp_sup, DW_FORM_GNU_ref_alt, DW_FORM_GNU_strp_alt:
+		fixed(unit.offsetSize)
+	case DW_FORM_ref_addr:
+		if unit.version <= 2 {
+			fixed(unit.addrSize)
+		} else {
+			fixed(unit.offsetSize)
+		}
+	case DW_FORM_udata, DW_FORM_ref_udata, DW_FORM_strx, DW_FORM_addrx, DW_FORM_loclistx, DW_FORM_rnglistx,
+		DW_FORM_GNU_addr_index, DW_FORM_GNU_str_index:
+		v.value, v.width = r.uleb()
+	case DW_FORM_sdata:
+		v.value = uint64(r.sleb())
+		v.width = r.pos - v.pos
+	case DW_FORM_string:
+		r.cstring()
+	case DW_FORM_block1:
+		r.bytes(int(r.u8()))
+	case DW_FORM_block2:
+		r.bytes(int(r.uint(2)))
+	case DW_FORM_block4:
+		r.bytes(int(r.uint(4)))
+	case DW_FORM_block, DW_FORM_exprloc:
+		length, _ := r.uleb()
+		r.bytes(int(length))
+	case DW_FORM_flag_present, DW_FORM_implicit_const:
+	case DW_FORM_indirect:
+		form, _ := r.uleb()
+		v = w.readAttr(r, unit, abbrevAttr{attr.attr, form})
+	default:
+		r.err = fmt.Errorf("unknown form 0x%x", attr.form)
+	}
+	return v
+}
+
+// unitBases reads the base address and the base offsets of the unit from its first DIE.
+func (w *dwarfRewriter) unitBases(unit *dwarfUnit, values []attrValue) {
+	for _, v := range values {
+		switch v.attr {
+		case DW_AT_addr_base, DW_AT_GNU_addr_base:
+			unit.addrBase = v.value
+		case DW_AT_rnglists_base:
+			unit.rnglistsBase = v.value
+		case DW_AT_loclists_base:
+			unit.loclistsBase = v.value
+		}
+	}
+	for _, v := range values {
+		if v.attr == DW_AT_low_pc {
+			unit.base, _ = w.attrAddr(unit, v)
+		}
+	}
+}
+
+func isAddrxForm(form uint64) bool {
+	switch form {
+	case DW_FORM_addrx, DW_FORM_addrx1, DW_FORM_addrx2, DW_FORM_addrx3, DW_FORM_addrx4, DW_FORM_GNU_addr_index:
+		return true
+	}
+	return false
+}
+
+// attrAddr returns the address of an address attribute, it's false if it's not an address.
+func (w *dwarfRewriter) attrAddr(unit *dwarfUnit, v attrValue) (uint64, bool) {
+	if v.form == DW_FORM_addr {
+		return v.value, true
+	}
+	if isAddrxForm(v.form) {
+		return w.indexedAddr(unit, v.value), true
+	}
+	return 0, false
+}
+
+// indexedAddr reads an address of .debug_addr as it was before the rewrite.
+func (w *dwarfRewriter) indexedAddr(unit *dwarfUnit, index uint64) uint64 {
+	r := &dwarfReader{buf: w.oldAddr, pos: int(unit.addrBase + index*uint64(unit.addrSize))}
+	return r.uint(unit.addrSize)
+}
+
+// mapIndexedAddr marks an address of .debug_addr as a code address.
+func (w *dwarfRewriter) mapIndexedAddr(unit *dwarfUnit, index uint64) {
+	w.addrs[int(unit.addrBase+index*uint64(unit.addrSize))] = struct{}{}
+}
+
+func isDataForm(form uint64) bool {
+	switch form {
+	case DW_FORM_data1, DW_FORM_data2, DW_FORM_data4, DW_FORM_data8, DW_FORM_udata:
+		return true
+	}
+	return false
+}
+
+func (w *dwarfRewriter) rewriteDIE(buf []byte, unit *dwarfUnit, values []attrValue) error {
+	var (
+		low    uint64
+		hasLow bool
+	)
+	for _, v := range values {
+		if v.attr == DW_AT_low_pc {
+			low, hasLow = w.attrAddr(unit, v)
+		}
+	}
+
+	for _, v := range values {
+		switch v.attr {
+		case DW_AT_low_pc, DW_AT_high_pc, DW_AT_entry_pc, DW_AT_call_return_pc, DW_AT_call_pc:
+			switch {
+			case v.form == DW_FORM_addr:
+				putUint(buf[v.pos:], v.width, w.mapAddr(v.value))
+			case isAddrxForm(v.form):
+				w.mapIndexedAddr(unit, v.value)
+			case v.attr == DW_AT_high_pc && hasLow && isDataForm(v.form):
+				// the high pc is the length of the code.
+				_, length := w.mapRange(low, v.value)
+				if err := putWidth(buf[v.pos:], v.form, v.width, length); err != nil {
+					return err
+				}
+			}
+		case DW_AT_stmt_list:
+			if offset, exist := w.lineMap[v.value]; exist && v.width > 0 {
+				putUint(buf[v.pos:], v.width, offset)
+			}
+		case DW_AT_ranges:
+			switch {
+			case v.form == DW_FORM_rnglistx:
+				unit.rnglistx = append(unit.rnglistx, v.value)
+			case unit.version >= 5:
+				addList(w.rnglists, v.value, unit)
+			case v.width > 0:
+				addList(w.ranges, v.value, unit)
+			}
+		case DW_AT_location, DW_AT_frame_base:
+			switch {
+			case v.form == DW_FORM_loclistx:
+				unit.loclistx = append(unit.loclistx, v.value)
+			case v.form == DW_FORM_sec_offset || (unit.version < 4 && (v.form == DW_FORM_data4 || v.form == DW_FORM_data8)):
+				if unit.version >= 5 {
+					addList(w.loclists, v.value, unit)
+				} else {
+					addList(w.locs, v.value, unit)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// putWidth writes a constant over one of the given form and width.
+func putWidth(buf []byte, form uint64, width int, v uint64) error {
+	if form == DW_FORM_udata {
+		return putULEB(buf, width, v)
+	}
+	if width < 8 && v >= 1<<(8*uint(width)) {
+		return fmt.Errorf("%d doesn't fit in %d bytes", v, width)
+	}
+	putUint(buf, width, v)
+	return nil
+}
+
+func addList(lists map[uint64]*dwarfUnit, offset uint64, unit *dwarfUnit) {
+	if _, exist := lists[offset]; !exist {
+		lists[offset] = unit
+	}
+}
+
+// resolveLists finds the offsets of the lists the unit refers to by index.
+func (w *dwarfRewriter) resolveLists(unit *dwarfUnit) {
+	resolve := func(section string, base uint64, indices []uint64, lists map[uint64]*dwarfUnit) {
+		r := &dwarfReader{buf: w.sections[section]}
+		for _, index := range indices {
+			r.pos = int(base + index*uint64(unit.offsetSize))
+			addList(lists, base+r.uint(unit.offsetSize), unit)
+		}
+	}
+	resolve(".debug_rnglists", unit.rnglistsBase, unit.rnglistx, w.rnglists)
+	resolve(".debug_loclists", unit.loclistsBase, unit.loclistx, w.loclists)
+}
+
+func (w *dwarfRewriter) rewriteAranges(buf []byte) ([]byte, error) {
+	r := &dwarfReader{buf: buf}
+	for r.pos < len(buf) && r.err == nil {
+		unitStart := r.pos
+		offsetSize, end := r.unitLength()
+		r.uint(2) // version.
+		r.uint(offsetSize)
+		addrSize := int(r.u8())
+		r.u8() // segment_selector_size.
+		// the tuples are aligned on their size.
+		tupleSize := 2 * addrSize
+		if tupleSize == 0 {
+			return nil, fmt.Errorf("invalid address size at offset %d", unitStart)
+		}
+		if rem := (r.pos - unitStart) % tupleSize; rem != 0 {
+			r.pos += tupleSize - rem
+		}
+		for r.pos+tupleSize <= end && r.err == nil {
+			pos := r.pos
+			addr, length := r.uint(addrSize), r.uint(addrSize)
+			if addr == 0 && length == 0 {
+				break
+			}
+			addr, length = w.mapRange(addr, length)
+			putUint(buf[pos:], addrSize, addr)
+			putUint(buf[pos+addrSize:], addrSize, length)
+		}
+		r.pos = end
+	}
+	return buf, r.err
+}
+
+// rewriteRanges rewrites the range lists of the units before DWARF 5, their addresses are relative to
+// the base address of the unit.
+func (w *dwarfRewriter) rewriteRanges(buf []byte) ([]byte, error) {
+	return buf, w.rewriteLegacyLists(buf, w.ranges, false)
+}
+
+func (w *dwarfRewriter) rewriteLoc(buf []byte) ([]byte, error) {
+	return buf, w.rewriteLegacyLists(buf, w.locs, true)
+}
+
+func (w *dwarfRewriter) rewriteLegacyLists(buf []byte, lists map[uint64]*dwarfUnit, exprs bool) error {
+	for offset, unit := range lists {
+		addrSize, base := unit.addrSize, unit.base
+		maxAddr := uint64(1)<<(8*uint(addrSize)) - 1
+		r := &dwarfReader{buf: buf, pos: int(offset)}
+		for r.err == nil {
+			pos := r.pos
+			begin, end := r.uint(addrSize), r.uint(addrSize)
+			if begin == 0 && end == 0 {
+				break
+			}
+			if begin == maxAddr {
+				// a base address selection.
+				base = end
+				putUint(buf[pos+addrSize:], addrSize, w.mapAddr(end))
+				continue
+			}
+			newBase := w.mapAddr(base)
+			putUint(buf[pos:], addrSize, w.mapAddr(base+begin)-newBase)
+			putUint(buf[pos+addrSize:], addrSize, w.mapAddr(base+end)-newBase)
+			if exprs {
+				r.bytes(int(r.uint(2)))
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("list at offset %d: %v", offset, r.err)
+		}
+	}
+	return nil
+}
+
+// the entries of the DWARF 5 range and location lists.
+const (
+	DW_RLE_end_of_list      = 0x00
+	DW_RLE_base_addressx    = 0x01
+	DW_RLE_startx_endx      = 0x02
+	DW_RLE_startx_length    = 0x03
+	DW_RLE_offset_pair      = 0x04
+	DW_RLE_base_address     = 0x05
+	DW_RLE_start_end        = 0x06
+	DW_RLE_start_length     = 0x07
+	DW_LLE_default_location = 0x05
+	DW_LLE_base_address     = 0x06
+	DW_LLE_start_end        = 0x07
+	DW_LLE_start_length     = 0x08
+)
+
+func (w *dwarfRewriter) rewriteRnglists(buf []byte) ([]byte, error) {
+	return buf, w.rewriteLists(buf, w.rnglists, false)
+}
+
+func (w *dwarfRewriter) rewriteLoclists(buf []byte) ([]byte, error) {
+	return buf, w.rewriteLists(buf, w.loclists, true)
+}
+
+// rewriteLists rewrites the DWARF 5 lists, the LEB128 numbers keep their width.
+func (w *dwarfRewriter) rewriteLists(buf []byte, lists map[uint64]*dwarfUnit, exprs bool) error {
+	for offset, unit := range lists {
+		addrSize, base := unit.addrSize, unit.base
+
+		r := &dwarfReader{buf: buf, pos: int(offset)}
+		for r.err == nil {
+			kind := r.u8()
+			if kind == DW_RLE_end_of_list {
+				break
+			}
+			if exprs && kind == DW_LLE_default_location {
+				// the default location has no address.
+				length, _ := r.uleb()
+				r.bytes(int(length))
+				continue
+			}
+			if exprs && kind > DW_LLE_default_location {
+				// the other location entries are numbered like the range entries after it.
+				kind--
+			}
+
+			var err error
+			switch kind {
+			case DW_RLE_base_addressx:
+				index, _ := r.uleb()
+				base = w.indexedAddr(unit, index)
+				w.mapIndexedAddr(unit, index)
+				continue
+			case DW_RLE_startx_endx:
+				start, _ := r.uleb()
+				end, _ := r.uleb()
+				w.mapIndexedAddr(unit, start)
+				w.mapIndexedAddr(unit, end)
+			case DW_RLE_startx_length:
+				index, _ := r.uleb()
+				pos := r.pos
+				length, width := r.uleb()
+				_, length = w.mapRange(w.indexedAddr(unit, index), length)
+				w.mapIndexedAddr(unit, index)
+				err = putULEB(buf[pos:], width, length)
+			case DW_RLE_offset_pair:
+				beginPos := r.pos
+				begin, beginWidth := r.uleb()
+				endPos := r.pos
+				end, endWidth := r.uleb()
+				newBase := w.mapAddr(base)
+				if err = putULEB(buf[beginPos:], beginWidth, w.mapAddr(base+begin)-newBase); err == nil {
+					err = putULEB(buf[endPos:], endWidth, w.mapAddr(base+end)-newBase)
+				}
+			case DW_RLE_base_address:
+				pos := r.pos
+				base = r.uint(addrSize)
+				putUint(buf[pos:], addrSize, w.mapAddr(base))
+				continue
+			case DW_RLE_start_end:
+				pos := r.pos
+				start, end := r.uint(addrSize), r.uint(addrSize)
+				putUint(buf[pos:], addrSize, w.mapAddr(start))
+				putUint(buf[pos+addrSize:], addrSize, w.mapAddr(end))
+			case DW_RLE_start_length:
+				pos := r.pos
+				start := r.uint(addrSize)
+				lengthPos := r.pos
+				length, width := r.uleb()
+				start, length = w.mapRange(start, length)
+				putUint(buf[pos:], addrSize, start)
+				err = putULEB(buf[lengthPos:], width, length)
+			default:
+				return fmt.Errorf("list at offset %d: unknown entry kind %d", offset, kind)
+			}
+			if err != nil {
+				return fmt.Errorf("list at offset %d: %v", offset, err)
+			}
+			if exprs {
+				length, _ := r.uleb()
+				r.bytes(int(length))
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("list at offset %d: %v", offset, r.err)
+		}
+	}
+	return nil
+}
+
+// rewriteAddr maps the code addresses of .debug_addr, the others (i.e. the addresses of the data) are kept.
+func (w *dwarfRewriter) rewriteAddr(buf []byte) ([]byte, error) {
+	addrSize := w.addrSize
+	if addrSize == 0 {
+		addrSize = 4
+	}
+	for pos := range w.addrs {
+		if pos+addrSize > len(buf) {
+			return nil, fmt.Errorf("address at offset %d overflows the section", pos)
+		}
+		r := &dwarfReader{buf: w.oldAddr, pos: pos}
+		putUint(buf[pos:], addrSize, w.mapAddr(r.uint(addrSize)))
+	}
+	return buf, nil
+}
@@ -0,0 +1,249 @@
+package toolkit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Emitter emits the operators of a function body or of a constant expression. It checks that the control
+// flow is structured: the blocks are ended, `else` is only in `if`, `catch` and `delegate` in `try` and the
+// branches target enclosing blocks.
+// The first error stops the emission, it's returned by Code.
+type Emitter struct {
+	ops    []OP
+	blocks []string // the kinds of the open blocks.
+	err    error
+}
+
+func NewEmitter() *Emitter {
+	return &Emitter{}
+}
+
+func (e *Emitter) fail(format string, args ...interface{}) *Emitter {
+	if e.err == nil {
+		e.err = fmt.Errorf("op %d: %s", len(e.ops), fmt.Sprintf(format, args...))
+	}
+	return e
+}
+
+// Op emits any operator, the blocks it opens or ends are checked.
+func (e *Emitter) Op(op OP) *Emitter {
+	if e.err != nil {
+		return e
+	}
+	switch op.Name {
+	case "block", "loop", "if", "try", "try_table":
+		e.blocks = append(e.blocks, op.Name)
+	case "else":
+		if len(e.blocks) == 0 || e.blocks[len(e.blocks)-1] != "if" {
+			return e.fail("else out of if")
+		}
+		e.blocks[len(e.blocks)-1] = "else"
+	case "catch", "catch_all":
+		// the catch clauses follow the body of a try, catch_all is the last one.
+		if len(e.blocks) == 0 || (e.blocks[len(e.blocks)-1] != "try" && e.blocks[len(e.blocks)-1] != "catch") {
+			return e.fail("%s out of try", op.Name)
+		}
+		e.blocks[len(e.blocks)-1] = op.Name
+	case "delegate":
+		// delegate ends a try without catch clauses, its depth is the one of a branch following it.
+		if len(e.blocks) == 0 || e.blocks[len(e.blocks)-1] != "try" {
+			return e.fail("delegate out of try")
+		}
+		e.blocks = e.blocks[:len(e.blocks)-1]
+		if depth, ok := op.Immediates.(uint32); ok {
+			e.checkDepth(depth)
+		}
+	case "end":
+		if len(e.blocks) == 0 {
+			return e.fail("end out of a block, the body is ended by Code")
+		}
+		e.blocks = e.blocks[:len(e.blocks)-1]
+	case "br", "br_if":
+		if depth, ok := op.Immediates.(uint32); ok {
+			e.checkDepth(depth)
+		}
+	case "br_table":
+		if imm, ok := op.Immediates.(JSON); ok {
+			targets, _ := imm["targets"].([]uint64)
+			for _, target := range targets {
+				e.checkDepth(uint32(target))
+			}
+			if def, ok := imm["default_target"].(uint64); ok {
+				e.checkDepth(uint32(def))
+			}
+		}
+	}
+	if e.err == nil {
+		e.ops = append(e.ops, op)
+	}
+	return e
+}
+
+// checkDepth checks the target of a branch, the depth of the function body is the number of open blocks.
+func (e *Emitter) checkDepth(depth uint32) {
+	if int(depth) > len(e.blocks) {
+		e.fail("branch to depth %d out of %d blocks", depth, len(e.blocks))
+	}
+}
+
+// Code returns the operators followed by the `end` of the body.
+func (e *Emitter) Code() ([]OP, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if len(e.blocks) > 0 {
+		return nil, fmt.Errorf("%d blocks aren't ended", len(e.blocks))
+	}
+	return append(append([]OP{}, e.ops...), OP{Name: "end"}), nil
+}
+
+// Ops returns the operators emitted so far without the `end` of the body, i.e. to insert them in another
+// body. They are nil if the emission failed.
+func (e *Emitter) Ops() []OP {
+	if e.err != nil {
+		return nil
+	}
+	return append([]OP{}, e.ops...)
+}
+
+// Instr emits an operator without immediates, i.e. Instr("i32", "add").
+func (e *Emitter) Instr(typ, name string) *Emitter {
+	return e.Op(OP{Name: name, ReturnType: typ})
+}
+
+func (e *Emitter) I32Const(v int32) *Emitter {
+	return e.Op(OP{Name: "const", ReturnType: "i32", Immediates: v})
+}
+
+func (e *Emitter) I64Const(v int64) *Emitter {
+	return e.Op(OP{Name: "const", ReturnType: "i64", Immediates: v})
+}
+
+func (e *Emitter) F32Const(v float32) *Emitter {
+	bits := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bits, math.Float32bits(v))
+	return e.Op(OP{Name: "const", ReturnType: "f32", Immediates: bits})
+}
+
+func (e *Emitter) F64Const(v float64) *Emitter {
+	bits := make([]byte, 8)
+	binary.LittleEndian.PutUint64(bits, math.Float64bits(v))
+	return e.Op(OP{Name: "const", ReturnType: "f64", Immediates: bits})
+}
+
+// Const emits the constant of a number type, the floats are converted from the integer.
+func (e *Emitter) Const(typ string, v uint64) *Emitter {
+	switch typ {
+	case "i32":
+		return e.I32Const(int32(v))
+	case "i64":
+		return e.I64Const(int64(v))
+	case "f32":
+		return e.F32Const(float32(v))
+	case "f64":
+		return e.F64Const(float64(v))
+	}
+	return e.fail("no constant of type %s", typ)
+}
+
+func (e *Emitter) GetLocal(index uint32) *Emitter {
+	return e.Op(OP{Name: "get_local", Immediates: index})
+}
+
+func (e *Emitter) SetLocal(index uint32) *Emitter {
+	return e.Op(OP{Name: "set_local", Immediates: index})
+}
+
+func (e *Emitter) TeeLocal(index uint32) *Emitter {
+	return e.Op(OP{Name: "tee_local", Immediates: index})
+}
+
+func (e *Emitter) GetGlobal(index uint32) *Emitter {
+	return e.Op(OP{Name: "get_global", Immediates: index})
+}
+
+func (e *Emitter) SetGlobal(index uint32) *Emitter {
+	return e.Op(OP{Name: "set_global", Immediates: index})
+}
+
+// Load emits a load of the memory 0 from the operator name, i.e. Load("i32", "load8_u", 0, 16).
+func (e *Emitter) Load(typ, name string, align, offset uint64) *Emitter {
+	return e.Op(OP{Name: name, ReturnType: typ, Immediates: JSON{"flags": align, "offset": offset}})
+}
+
+// Store emits a store to the memory 0 from the operator name, i.e. Store("i64", "store", 3, 0).
+func (e *Emitter) Store(typ, name string, align, offset uint64) *Emitter {
+	return e.Load(typ, name, align, offset)
+}
+
+func (e *Emitter) Call(index uint32) *Emitter {
+	return e.Op(OP{Name: "call", Immediates: index})
+}
+
+func (e *Emitter) CallIndirect(typ, table uint32) *Emitter {
+	return e.Op(OP{Name: "call_indirect", Immediates: JSON{"index": uint64(typ), "reserved": table}})
+}
+
+func (e *Emitter) Drop() *Emitter {
+	return e.Op(OP{Name: "drop"})
+}
+
+func (e *Emitter) Nop() *Emitter {
+	return e.Op(OP{Name: "nop"})
+}
+
+func (e *Emitter) Unreachable() *Emitter {
+	return e.Op(OP{Name: "unreachable"})
+}
+
+func (e *Emitter) Return() *Emitter {
+	return e.Op(OP{Name: "return"})
+}
+
+func (e *Emitter) Br(depth uint32) *Emitter {
+	return e.Op(OP{Name: "br", Immediates: depth})
+}
+
+func (e *Emitter) BrIf(depth uint32) *Emitter {
+	return e.Op(OP{Name: "br_if", Immediates: depth})
+}
+
+func (e *Emitter) BrTable(targets []uint32, defaultTarget uint32) *Emitter {
+	depths := make([]uint64, 0, len(targets))
+	for _, target := range targets {
+		depths = append(depths, uint64(target))
+	}
+	return e.Op(OP{Name: "br_table", Immediates: JSON{"targets": depths, "default_target": uint64(defaultTarget)}})
+}
+
+// the empty block type.
+const BLOCK_TYPE_EMPTY = "block_type"
+
+// Block emits a block of the given type (a value type, BLOCK_TYPE_EMPTY or `(type N)`) around the body.
+func (e *Emitter) Block(blockType string, body func(e *Emitter)) *Emitter {
+	return e.block("block", blockType, body)
+}
+
+// Loop emits a loop of the given type around the body, a branch to it continues the loop.
+func (e *Emitter) Loop(blockType string, body func(e *Emitter)) *Emitter {
+	return e.block("loop", blockType, body)
+}
+
+// If emits an if of the given type, the else body is omitted if it's nil.
+func (e *Emitter) If(blockType string, then, otherwise func(e *Emitter)) *Emitter {
+	e.Op(OP{Name: "if", Immediates: blockType})
+	then(e)
+	if otherwise != nil {
+		e.Op(OP{Name: "else"})
+		otherwise(e)
+	}
+	return e.Op(OP{Name: "end"})
+}
+
+func (e *Emitter) block(name, blockType string, body func(e *Emitter)) *Emitter {
+	e.Op(OP{Name: name, Immediates: blockType})
+	body(e)
+	return e.Op(OP{Name: "end"})
+}
@@ -0,0 +1,766 @@
+// Package gen generates random valid modules from a seed, i.e. to test the round trips of the encoding and
+// the invariants of the passes on more modules than the fixed ones. The modules import functions, globals
+// and sometimes their memory, nest blocks, loops and ifs, branch with br_table, call through a table and
+// access the memory initialized by data segments. The same seed and limits generate the same module.
+//
+// The generated code doesn't trap and terminates: the calls only go to the functions of lower indices, the
+// loops are counted, the memory accesses are masked to the first page and the integer divisions are left out.
+package gen
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// Limits are the maxima of the generated modules, the counts are drawn up to them. A module has at least a
+// type and a defined function.
+type Limits struct {
+	Types     int // the function types.
+	Imports   int // the imported functions and globals, the memory aside.
+	Functions int // the defined functions.
+	Globals   int // the defined globals.
+	Ops       int // the operators of a function body, the expressions started are ended beyond it.
+	Depth     int // the nesting of the blocks, loops and ifs of a body.
+	Data      int // the data segments.
+	DataBytes int // the bytes of a data segment.
+	Table     int // the functions of the table.
+}
+
+// DefaultLimits generate modules of up to a few kilobytes.
+var DefaultLimits = Limits{
+	Types:     6,
+	Imports:   4,
+	Functions: 8,
+	Globals:   4,
+	Ops:       64,
+	Depth:     4,
+	Data:      4,
+	DataBytes: 64,
+	Table:     8,
+}
+
+var valueTypes = []string{"i32", "i64", "f32", "f64"}
+
+// the accesses are masked to the first page, minus the room of the widest access and of its offset.
+const (
+	pageSize    = 1 << 16
+	addressMask = pageSize - 32
+	maxOffset   = 16
+)
+
+// Generate generates a valid module from a seed.
+func Generate(seed int64, limits Limits) ([]toolkit.JSON, error) {
+	for _, limit := range []int{limits.Types, limits.Imports, limits.Functions, limits.Globals, limits.Ops,
+		limits.Depth, limits.Data, limits.DataBytes, limits.Table} {
+		if limit < 0 {
+			return nil, fmt.Errorf("gen: negative limit in %+v", limits)
+		}
+	}
+	g := &generator{
+		rand:   rand.New(rand.NewSource(seed)),
+		limits: limits,
+		b:      toolkit.NewBuilder(nil),
+		types:  map[uint32]toolkit.TypeEntry{},
+	}
+	g.genTypes()
+	g.genImports()
+	g.genMemory()
+	g.genGlobals()
+	if err := g.genFunctions(); err != nil {
+		return nil, err
+	}
+	g.genTable()
+	g.genExports()
+	g.genData()
+	return g.b.Module(), nil
+}
+
+type generator struct {
+	rand   *rand.Rand
+	limits Limits
+	b      *toolkit.Builder
+
+	types     map[uint32]toolkit.TypeEntry
+	typeList  []uint32 // the distinct type indices, in order.
+	funcTypes []uint32 // the type of every function, the imported ones first.
+	imported  uint32   // the imported functions.
+	globals   []toolkit.Global
+	mutable   []uint32 // the defined mutable globals.
+	memory    bool
+	table     []uint64 // the function of every slot of the table.
+}
+
+// upTo returns a count from 0 to n.
+func (g *generator) upTo(n int) int {
+	return g.rand.Intn(n + 1)
+}
+
+// oneTo returns a count from 1 to n, 1 if n is 0.
+func (g *generator) oneTo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 + g.rand.Intn(n)
+}
+
+func (g *generator) valueType() string {
+	return valueTypes[g.rand.Intn(len(valueTypes))]
+}
+
+func (g *generator) genTypes() {
+	n := g.oneTo(g.limits.Types)
+	for i := 0; i < n; i++ {
+		params := make([]string, g.upTo(3))
+		for j := range params {
+			params[j] = g.valueType()
+		}
+		result := ""
+		if g.rand.Intn(3) > 0 {
+			result = g.valueType()
+		}
+		typ := g.b.FuncType(params, result)
+		if _, exist := g.types[typ]; !exist {
+			g.types[typ] = toolkit.TypeEntry{Form: "func", Params: params, ReturnType: result}
+			g.typeList = append(g.typeList, typ)
+		}
+	}
+}
+
+func (g *generator) funcType() uint32 {
+	return g.typeList[g.rand.Intn(len(g.typeList))]
+}
+
+func (g *generator) genImports() {
+	n := g.upTo(g.limits.Imports)
+	for i := 0; i < n; i++ {
+		if g.rand.Intn(4) == 0 {
+			typ := toolkit.Global{ContentType: g.valueType()}
+			g.b.ImportGlobal("env", fmt.Sprintf("g%d", len(g.globals)), typ)
+			g.globals = append(g.globals, typ)
+			continue
+		}
+		typ := g.funcType()
+		g.b.ImportFunction("env", fmt.Sprintf("f%d", g.imported), typ)
+		g.funcTypes = append(g.funcTypes, typ)
+		g.imported++
+	}
+}
+
+func (g *generator) genMemory() {
+	if g.rand.Intn(8) == 0 {
+		return
+	}
+	g.memory = true
+	limits := toolkit.MemLimits{Intial: 1 + uint64(g.upTo(2))}
+	if g.rand.Intn(2) == 0 {
+		limits.Maximum = limits.Intial + uint64(g.upTo(4))
+	}
+	if g.rand.Intn(4) == 0 {
+		g.b.ImportMemory("env", "memory", limits)
+		return
+	}
+	g.b.AddMemory(limits)
+}
+
+func (g *generator) genGlobals() {
+	imported := len(g.globals)
+	n := g.upTo(g.limits.Globals)
+	for i := 0; i < n; i++ {
+		typ := toolkit.Global{ContentType: g.valueType()}
+		if g.rand.Intn(2) == 0 {
+			typ.Mutability = 1
+		}
+		e := toolkit.NewEmitter()
+		// an imported immutable global is a constant expression too.
+		var inits []uint32
+		for j := 0; j < imported; j++ {
+			if g.globals[j].ContentType == typ.ContentType {
+				inits = append(inits, uint32(j))
+			}
+		}
+		if len(inits) > 0 && g.rand.Intn(2) == 0 {
+			e.GetGlobal(inits[g.rand.Intn(len(inits))])
+		} else {
+			g.constant(e, typ.ContentType)
+		}
+		index := g.b.AddGlobal(typ, e.Ops())
+		g.globals = append(g.globals, typ)
+		if typ.Mutability == 1 {
+			g.mutable = append(g.mutable, index)
+		}
+	}
+}
+
+// constant emits a constant of a value type, the small ones are frequent.
+func (g *generator) constant(e *toolkit.Emitter, typ string) {
+	var v int64
+	switch g.rand.Intn(4) {
+	case 0:
+		v = int64(g.upTo(8))
+	case 1:
+		v = -int64(g.upTo(8))
+	case 2:
+		v = int64(g.rand.Int31())
+	default:
+		v = g.rand.Int63()
+	}
+	switch typ {
+	case "i32":
+		e.I32Const(int32(v))
+	case "i64":
+		e.I64Const(v)
+	case "f32":
+		e.F32Const(float32(v) / 16)
+	case "f64":
+		e.F64Const(float64(v) / 16)
+	}
+}
+
+func (g *generator) genFunctions() error {
+	n := g.oneTo(g.limits.Functions)
+	for i := 0; i < n; i++ {
+		g.funcTypes = append(g.funcTypes, g.funcType())
+	}
+	// the table is filled before the bodies, call_indirect needs its slots.
+	if g.limits.Table > 0 && g.rand.Intn(4) > 0 {
+		g.table = make([]uint64, g.oneTo(g.limits.Table))
+		for i := range g.table {
+			g.table[i] = uint64(g.rand.Intn(len(g.funcTypes)))
+		}
+	}
+	for i := 0; i < n; i++ {
+		index := g.imported + uint32(i)
+		f := newFuncGen(g, index, g.types[g.funcTypes[index]])
+		locals, code, err := f.body()
+		if err != nil {
+			return fmt.Errorf("gen: function %d: %v", index, err)
+		}
+		g.b.AddFunction(g.funcTypes[index], locals, code)
+	}
+	return nil
+}
+
+func (g *generator) genTable() {
+	if g.table == nil {
+		return
+	}
+	limits := toolkit.MemLimits{Intial: uint64(len(g.table))}
+	if g.rand.Intn(2) == 0 {
+		limits.Maximum = limits.Intial + uint64(g.upTo(4))
+	}
+	table := g.b.AddTable(toolkit.Table{ElementType: "anyFunc", Limits: limits})
+	g.b.AddElements(table, toolkit.NewEmitter().I32Const(0).Ops(), g.table)
+}
+
+func (g *generator) genExports() {
+	last := uint32(len(g.funcTypes) - 1)
+	for index := g.imported; index <= last; index++ {
+		// the last function may call all the others.
+		if index == last || g.rand.Intn(2) == 0 {
+			g.b.AddExport(fmt.Sprintf("f%d", index), "function", index)
+		}
+	}
+	for index, global := range g.globals {
+		if global.Mutability == 0 && g.rand.Intn(4) == 0 {
+			g.b.AddExport(fmt.Sprintf("g%d", index), "global", uint32(index))
+		}
+	}
+	if g.memory && g.rand.Intn(2) == 0 {
+		g.b.AddExport("memory", "memory", 0)
+	}
+	if g.table != nil && g.rand.Intn(2) == 0 {
+		g.b.AddExport("table", "table", 0)
+	}
+}
+
+func (g *generator) genData() {
+	if !g.memory {
+		return
+	}
+	n := g.upTo(g.limits.Data)
+	for i := 0; i < n; i++ {
+		size := g.upTo(g.limits.DataBytes)
+		if size > pageSize {
+			size = pageSize
+		}
+		data := make([]byte, size)
+		g.rand.Read(data)
+		offset := g.rand.Intn(pageSize - len(data) + 1)
+		g.b.AddData(0, toolkit.NewEmitter().I32Const(int32(offset)).Ops(), data)
+	}
+}
+
+// label is an enclosing block of the code being generated.
+type label struct {
+	result string // the type of the values of a branch to the label, "" if it has none.
+	loop   bool
+}
+
+// funcGen generates the body of a function, the budget is the number of operators left to generate.
+type funcGen struct {
+	*generator
+	index  uint32
+	typ    toolkit.TypeEntry
+	locals []string // the types of the params and of the locals.
+	usable int      // the locals the code may set, the loop counters follow.
+	labels []label
+	budget int
+	e      *toolkit.Emitter
+}
+
+func newFuncGen(g *generator, index uint32, typ toolkit.TypeEntry) *funcGen {
+	f := &funcGen{generator: g, index: index, typ: typ, budget: g.limits.Ops, e: toolkit.NewEmitter()}
+	f.locals = append(f.locals, typ.Params...)
+	for n := g.upTo(4); n > 0; n-- {
+		f.locals = append(f.locals, g.valueType())
+	}
+	f.usable = len(f.locals)
+	return f
+}
+
+func (f *funcGen) body() ([]toolkit.LocalEntry, []toolkit.OP, error) {
+	f.labels = []label{{result: f.typ.ReturnType}}
+	f.statements()
+	if f.typ.ReturnType != "" {
+		f.value(f.typ.ReturnType)
+	}
+	code, err := f.e.Code()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	locals := []toolkit.LocalEntry{}
+	for _, typ := range f.locals[len(f.typ.Params):] {
+		if n := len(locals); n > 0 && locals[n-1].Type == typ {
+			locals[n-1].Count++
+			continue
+		}
+		locals = append(locals, toolkit.LocalEntry{Count: 1, Type: typ})
+	}
+	return locals, code, nil
+}
+
+// nested reports whether another block can be opened.
+func (f *funcGen) nested() bool {
+	return len(f.labels)-1 < f.limits.Depth && f.budget > 0
+}
+
+// block generates the body of a block with a label, it leaves a value of the result type.
+func (f *funcGen) block(l label) func(e *toolkit.Emitter) {
+	return func(e *toolkit.Emitter) {
+		f.labels = append(f.labels, l)
+		f.statements()
+		if l.result != "" {
+			f.value(l.result)
+		}
+		f.labels = f.labels[:len(f.labels)-1]
+	}
+}
+
+// blockType returns the type of a block leaving a value of a type, "" if it leaves none.
+func blockType(result string) string {
+	if result == "" {
+		return toolkit.BLOCK_TYPE_EMPTY
+	}
+	return result
+}
+
+// statements generates instructions leaving the operand stack as it is, they end after a branch.
+func (f *funcGen) statements() {
+	for f.budget > 0 && f.rand.Intn(5) > 0 {
+		if f.statement() {
+			return
+		}
+	}
+}
+
+// statement generates an instruction leaving the operand stack as it is, it reports whether the code
+// following it is unreachable.
+func (f *funcGen) statement() bool {
+	f.budget--
+	switch f.rand.Intn(14) {
+	case 0:
+		if local, ok := f.local(""); ok {
+			f.value(f.locals[local])
+			f.e.SetLocal(local)
+			return false
+		}
+	case 1:
+		if len(f.mutable) > 0 {
+			global := f.mutable[f.rand.Intn(len(f.mutable))]
+			f.value(f.globals[global].ContentType)
+			f.e.SetGlobal(global)
+			return false
+		}
+	case 2:
+		if f.memory {
+			typ := f.valueType()
+			name, width := f.access(typ, storeOps)
+			f.address()
+			f.value(typ)
+			f.e.Store(typ, name, f.align(width), uint64(f.upTo(maxOffset)))
+			return false
+		}
+	case 3:
+		if f.call("") {
+			return false
+		}
+	case 4:
+		if f.callIndirect("") {
+			return false
+		}
+	case 5:
+		if f.nested() {
+			f.e.Block(toolkit.BLOCK_TYPE_EMPTY, f.block(label{}))
+			return false
+		}
+	case 6:
+		if f.nested() {
+			f.loop()
+			return false
+		}
+	case 7:
+		if f.nested() {
+			f.value("i32")
+			var otherwise func(e *toolkit.Emitter)
+			if f.rand.Intn(2) == 0 {
+				otherwise = f.block(label{})
+			}
+			f.e.If(toolkit.BLOCK_TYPE_EMPTY, f.block(label{}), otherwise)
+			return false
+		}
+	case 8:
+		depth, target := f.target()
+		if target.result != "" {
+			f.value(target.result)
+		}
+		f.value("i32")
+		f.e.BrIf(depth)
+		if target.result != "" {
+			f.e.Drop()
+		}
+		return false
+	case 9:
+		depth, target := f.target()
+		if target.result != "" {
+			f.value(target.result)
+		}
+		f.e.Br(depth)
+		return true
+	case 10:
+		f.brTable()
+		return true
+	case 11:
+		if f.memory {
+			f.e.I32Const(int32(f.upTo(1)))
+			f.e.Op(toolkit.OP{Name: "grow_memory", Immediates: uint32(0)}).Drop()
+			return false
+		}
+	case 12:
+		if f.rand.Intn(4) == 0 {
+			if f.typ.ReturnType != "" {
+				f.value(f.typ.ReturnType)
+			}
+			f.e.Return()
+			return true
+		}
+		f.e.Nop()
+		return false
+	}
+	f.value(f.valueType())
+	f.e.Drop()
+	return false
+}
+
+// loop generates a loop counted by a local of its own.
+func (f *funcGen) loop() {
+	counter := uint32(len(f.locals))
+	f.locals = append(f.locals, "i32")
+	f.e.I32Const(int32(1 + f.upTo(3))).SetLocal(counter)
+	f.e.Loop(toolkit.BLOCK_TYPE_EMPTY, func(e *toolkit.Emitter) {
+		f.block(label{loop: true})(e)
+		e.GetLocal(counter).I32Const(1).Instr("i32", "sub").TeeLocal(counter).BrIf(0)
+	})
+}
+
+// target returns a label a branch may target and its depth, the loops are left out so the code terminates.
+func (f *funcGen) target() (uint32, label) {
+	for {
+		i := f.rand.Intn(len(f.labels))
+		if !f.labels[i].loop {
+			return uint32(len(f.labels) - 1 - i), f.labels[i]
+		}
+	}
+}
+
+func (f *funcGen) brTable() {
+	depth, target := f.target()
+	var depths []uint32
+	for i, l := range f.labels {
+		if !l.loop && l.result == target.result {
+			depths = append(depths, uint32(len(f.labels)-1-i))
+		}
+	}
+	targets := make([]uint32, f.upTo(4))
+	for i := range targets {
+		targets[i] = depths[f.rand.Intn(len(depths))]
+	}
+	if target.result != "" {
+		f.value(target.result)
+	}
+	f.value("i32")
+	f.e.BrTable(targets, depth)
+}
+
+// local returns a local the code may use of a type, of any type if it's "".
+func (f *funcGen) local(typ string) (uint32, bool) {
+	var locals []uint32
+	for i := 0; i < f.usable; i++ {
+		if typ == "" || f.locals[i] == typ {
+			locals = append(locals, uint32(i))
+		}
+	}
+	if len(locals) == 0 {
+		return 0, false
+	}
+	return locals[f.rand.Intn(len(locals))], true
+}
+
+// call calls a function of a lower index returning a value of a type, the value is dropped if the type is "".
+func (f *funcGen) call(result string) bool {
+	var funcs []uint32
+	for index := uint32(0); index < f.index; index++ {
+		if result == "" || f.types[f.funcTypes[index]].ReturnType == result {
+			funcs = append(funcs, index)
+		}
+	}
+	if len(funcs) == 0 {
+		return false
+	}
+	index := funcs[f.rand.Intn(len(funcs))]
+	typ := f.types[f.funcTypes[index]]
+	f.args(typ)
+	f.e.Call(index)
+	if result == "" && typ.ReturnType != "" {
+		f.e.Drop()
+	}
+	return true
+}
+
+// callIndirect calls the function of a slot of the table like call.
+func (f *funcGen) callIndirect(result string) bool {
+	var slots []int
+	for slot, index := range f.table {
+		if uint32(index) < f.index && (result == "" || f.types[f.funcTypes[index]].ReturnType == result) {
+			slots = append(slots, slot)
+		}
+	}
+	if len(slots) == 0 {
+		return false
+	}
+	slot := slots[f.rand.Intn(len(slots))]
+	typ := f.funcTypes[f.table[slot]]
+	f.args(f.types[typ])
+	f.e.I32Const(int32(slot))
+	f.e.CallIndirect(typ, 0)
+	if result == "" && f.types[typ].ReturnType != "" {
+		f.e.Drop()
+	}
+	return true
+}
+
+func (f *funcGen) args(typ toolkit.TypeEntry) {
+	for _, param := range typ.Params {
+		f.value(param)
+	}
+}
+
+// address leaves an address of the first page.
+func (f *funcGen) address() {
+	if f.rand.Intn(2) == 0 {
+		f.e.I32Const(int32(f.rand.Intn(addressMask)))
+		return
+	}
+	f.value("i32")
+	f.e.I32Const(addressMask).Instr("i32", "and")
+}
+
+// the memory operators by value type.
+var (
+	loadOps = map[string][]string{
+		"i32": {"load", "load8_s", "load8_u", "load16_s", "load16_u"},
+		"i64": {"load", "load8_s", "load8_u", "load16_s", "load16_u", "load32_s", "load32_u"},
+		"f32": {"load"},
+		"f64": {"load"},
+	}
+	storeOps = map[string][]string{
+		"i32": {"store", "store8", "store16"},
+		"i64": {"store", "store8", "store16", "store32"},
+		"f32": {"store"},
+		"f64": {"store"},
+	}
+	typeSizes = map[string]uint64{"i32": 4, "i64": 8, "f32": 4, "f64": 8}
+)
+
+// access returns a memory operator of a value type and the bytes it accesses, i.e. 2 for `load16_u`.
+func (f *funcGen) access(typ string, ops map[string][]string) (string, uint64) {
+	name := f.pick(ops[typ])
+	digits := strings.TrimLeft(strings.SplitN(name, "_", 2)[0], "loadstore")
+	if digits == "" {
+		return name, typeSizes[typ]
+	}
+	bits, _ := strconv.Atoi(digits)
+	return name, uint64(bits / 8)
+}
+
+// align returns the log2 of an alignment up to the natural one of an access.
+func (f *funcGen) align(width uint64) uint64 {
+	return uint64(f.upTo(bits.TrailingZeros64(width)))
+}
+
+// the operators by operand type.
+var (
+	intBinary    = []string{"add", "sub", "mul", "and", "or", "xor", "shl", "shr_s", "shr_u", "rotl", "rotr"}
+	floatBinary  = []string{"add", "sub", "mul", "div", "min", "max", "copysign"}
+	intUnary     = []string{"clz", "ctz", "popcnt"}
+	floatUnary   = []string{"abs", "neg", "ceil", "floor", "trunc", "nearest", "sqrt"}
+	intCompare   = []string{"eq", "ne", "lt_s", "lt_u", "gt_s", "gt_u", "le_s", "le_u", "ge_s", "ge_u"}
+	floatCompare = []string{"eq", "ne", "lt", "gt", "le", "ge"}
+
+	// the conversions that don't trap by result type, with their operand type.
+	conversions = map[string][][2]string{
+		"i32": {{"wrap/i64", "i64"}, {"reinterpret/f32", "f32"}},
+		"i64": {{"extend_s/i32", "i32"}, {"extend_u/i32", "i32"}, {"reinterpret/f64", "f64"}},
+		"f32": {{"convert_s/i32", "i32"}, {"convert_u/i64", "i64"}, {"demote/f64", "f64"}, {"reinterpret/i32", "i32"}},
+		"f64": {{"convert_s/i32", "i32"}, {"convert_u/i64", "i64"}, {"promote/f32", "f32"}, {"reinterpret/i64", "i64"}},
+	}
+)
+
+func isInt(typ string) bool {
+	return typ == "i32" || typ == "i64"
+}
+
+func (f *funcGen) pick(names []string) string {
+	return names[f.rand.Intn(len(names))]
+}
+
+// value generates instructions leaving a value of a type on the operand stack.
+func (f *funcGen) value(typ string) {
+	f.budget--
+	if f.budget <= 0 {
+		f.leaf(typ)
+		return
+	}
+	switch f.rand.Intn(14) {
+	case 0:
+		f.value(typ)
+		f.value(typ)
+		if isInt(typ) {
+			f.e.Instr(typ, f.pick(intBinary))
+		} else {
+			f.e.Instr(typ, f.pick(floatBinary))
+		}
+		return
+	case 1:
+		f.value(typ)
+		if isInt(typ) {
+			f.e.Instr(typ, f.pick(intUnary))
+		} else {
+			f.e.Instr(typ, f.pick(floatUnary))
+		}
+		return
+	case 2:
+		if typ == "i32" {
+			operand := f.valueType()
+			f.value(operand)
+			if isInt(operand) && f.rand.Intn(3) == 0 {
+				f.e.Instr(operand, "eqz")
+				return
+			}
+			f.value(operand)
+			if isInt(operand) {
+				f.e.Instr(operand, f.pick(intCompare))
+			} else {
+				f.e.Instr(operand, f.pick(floatCompare))
+			}
+			return
+		}
+	case 3:
+		conversion := conversions[typ][f.rand.Intn(len(conversions[typ]))]
+		f.value(conversion[1])
+		f.e.Instr(typ, conversion[0])
+		return
+	case 4:
+		if f.memory {
+			name, width := f.access(typ, loadOps)
+			f.address()
+			f.e.Load(typ, name, f.align(width), uint64(f.upTo(maxOffset)))
+			return
+		}
+	case 5:
+		if f.call(typ) {
+			return
+		}
+	case 6:
+		if f.callIndirect(typ) {
+			return
+		}
+	case 7:
+		if f.nested() {
+			f.e.Block(typ, f.block(label{result: typ}))
+			return
+		}
+	case 8:
+		if f.nested() {
+			f.value("i32")
+			f.e.If(typ, f.block(label{result: typ}), f.block(label{result: typ}))
+			return
+		}
+	case 9:
+		f.value(typ)
+		f.value(typ)
+		f.value("i32")
+		f.e.Op(toolkit.OP{Name: "select"})
+		return
+	case 10:
+		if local, ok := f.local(typ); ok {
+			f.value(typ)
+			f.e.TeeLocal(local)
+			return
+		}
+	case 11:
+		if typ == "i32" && f.memory {
+			f.e.Op(toolkit.OP{Name: "current_memory", Immediates: uint32(0)})
+			return
+		}
+	}
+	f.leaf(typ)
+}
+
+// leaf leaves a value of a type with a single instruction.
+func (f *funcGen) leaf(typ string) {
+	if f.rand.Intn(2) == 0 {
+		if local, ok := f.local(typ); ok {
+			f.e.GetLocal(local)
+			return
+		}
+	}
+	if f.rand.Intn(3) == 0 {
+		var globals []uint32
+		for index, global := range f.globals {
+			if global.ContentType == typ {
+				globals = append(globals, uint32(index))
+			}
+		}
+		if len(globals) > 0 {
+			f.e.GetGlobal(globals[f.rand.Intn(len(globals))])
+			return
+		}
+	}
+	f.constant(f.e, typ)
+}
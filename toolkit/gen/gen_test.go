@@ -0,0 +1,85 @@
+package gen
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+const seeds = 300
+
+func TestGenerate(t *testing.T) {
+	total := &toolkit.Stats{}
+	for seed := int64(0); seed < seeds; seed++ {
+		module, err := Generate(seed, DefaultLimits)
+		assert.Nil(t, err)
+		if err := toolkit.ValidateModuleStrict(module); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+
+		// the encoding round trips and the same seed generates the same module.
+		wasm := toolkit.Json2Wasm(module)
+		if !bytes.Equal(wasm, toolkit.Json2Wasm(toolkit.Wasm2Json(wasm))) {
+			t.Fatalf("seed %d: the round trip changes the module", seed)
+		}
+		again, _ := Generate(seed, DefaultLimits)
+		assert.Equal(t, wasm, toolkit.Json2Wasm(again))
+
+		stats, err := toolkit.Analyze(wasm)
+		assert.Nil(t, err)
+		assert.True(t, stats.MaxDepth <= DefaultLimits.Depth)
+		assert.True(t, len(stats.Functions) <= DefaultLimits.Functions)
+		total.Add(stats)
+	}
+
+	// the seeds cover what the modules may contain.
+	for _, section := range []string{"import", "table", "memory", "global", "export", "element", "data"} {
+		assert.True(t, total.Sections[section] > 0, section)
+	}
+	for _, op := range []string{"block", "loop", "if", "br", "br_if", "br_table", "call", "call_indirect",
+		"i32.load", "i64.store8", "grow_memory", "select", "i64.extend_u/i32"} {
+		assert.True(t, total.Opcodes[op] > 0, op)
+	}
+	imported := false
+	for _, memory := range total.Memories {
+		imported = imported || memory.Imported
+	}
+	assert.True(t, imported)
+}
+
+func TestGenerateLimits(t *testing.T) {
+	limits := Limits{Functions: 2, Ops: 8, Depth: 1, Data: 1, DataBytes: 4}
+	for seed := int64(0); seed < seeds; seed++ {
+		module, err := Generate(seed, limits)
+		assert.Nil(t, err)
+		assert.Nil(t, toolkit.ValidateModuleStrict(module))
+		space := toolkit.NewIndexSpace(module)
+		imported, defined := space.Len("function")
+		assert.Equal(t, uint32(0), imported)
+		assert.True(t, defined >= 1 && defined <= 2)
+		imported, defined = space.Len("table")
+		assert.Equal(t, []uint32{0, 0}, []uint32{imported, defined})
+
+		stats, _ := toolkit.Analyze(toolkit.Json2Wasm(module))
+		assert.True(t, stats.MaxDepth <= 1)
+		for _, section := range module {
+			if section["name"] == "data" {
+				segments := section["entries"].([]toolkit.DataSegment)
+				assert.Len(t, segments, 1)
+				assert.True(t, len(segments[0].Data) <= 4)
+			}
+		}
+	}
+
+	// the smallest module has a function.
+	module, err := Generate(1, Limits{})
+	assert.Nil(t, err)
+	assert.Nil(t, toolkit.ValidateModuleStrict(module))
+	_, defined := toolkit.NewIndexSpace(module).Len("function")
+	assert.Equal(t, uint32(1), defined)
+
+	_, err = Generate(1, Limits{Ops: -1})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,821 @@
+package toolkit
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// IndexMap maps the indices of an index space to the ones after it's modified, -1 if the entity is removed.
+type IndexMap []int
+
+// Map maps an index, it's false if the entity is removed or the index is out of the index space.
+func (m IndexMap) Map(index uint32) (uint32, bool) {
+	if int(index) >= len(m) || m[index] < 0 {
+		return index, false
+	}
+	return uint32(m[index]), true
+}
+
+// Function is a defined function, its type and its body.
+type Function struct {
+	Type uint32
+	Body CodeBody
+}
+
+// the sections holding the defined entities of the index spaces IndexSpace manages.
+var indexSpaceSections = map[string]string{
+	"function": "function",
+	"global":   "global",
+	"table":    "table",
+	"memory":   "memory",
+}
+
+// the subsections of the "name" section holding the names of the index spaces, the local names are
+// the ones of the functions.
+var nameSubsections = map[string]byte{
+	"function": 1,
+	"type":     NAME_SUBSECTION_TYPES,
+	"table":    5,
+	"memory":   6,
+	"global":   7,
+}
+
+const (
+	NAME_SUBSECTION_LOCALS = 2
+	NAME_SUBSECTION_TYPES  = 4
+)
+
+// IndexSpace inserts and removes the functions, globals, tables and memories of a module and renumbers
+// every reference to them: the exports, the start function, the element and data segments, the constant
+// expressions, the operators and the "name" section. The "linking" and "reloc.*" sections of an object
+// file aren't rewritten, see Relocator.
+type IndexSpace struct {
+	module []JSON
+}
+
+func NewIndexSpace(module []JSON) *IndexSpace {
+	return &IndexSpace{module: module}
+}
+
+// Module returns the module, its sections are modified in place but new ones may be inserted.
+func (s *IndexSpace) Module() []JSON {
+	return s.module
+}
+
+func (s *IndexSpace) section(name string) JSON {
+	if pos := findSectionByName(s.module, name); pos >= 0 {
+		return s.module[pos]
+	}
+	return nil
+}
+
+func (s *IndexSpace) imports() []ImportEntry {
+	if section := s.section("import"); section != nil {
+		entries, _ := section["entries"].([]ImportEntry)
+		return entries
+	}
+	return nil
+}
+
+// Len returns the number of imported and defined entities of a kind.
+func (s *IndexSpace) Len(kind string) (imported, defined uint32) {
+	for _, entry := range s.imports() {
+		if entry.Kind == kind {
+			imported++
+		}
+	}
+	if section := s.section(indexSpaceSections[kind]); section != nil {
+		defined = uint32(entriesLen(section["entries"]))
+	}
+	return imported, defined
+}
+
+func entriesLen(entries interface{}) int {
+	switch entries := entries.(type) {
+	case []uint64:
+		return len(entries)
+	case []GlobalEntry:
+		return len(entries)
+	case []Table:
+		return len(entries)
+	case []MemLimits:
+		return len(entries)
+	case []ElementEntry:
+		return len(entries)
+	case []DataSegment:
+		return len(entries)
+	}
+	return 0
+}
+
+func (s *IndexSpace) checkKind(kind string) error {
+	if _, exist := indexSpaceSections[kind]; !exist {
+		return fmt.Errorf("no index space of %s", kind)
+	}
+	return nil
+}
+
+// AddImport appends an import to the import section, its index follows the ones of the imports of its kind.
+func (s *IndexSpace) AddImport(entry ImportEntry) (uint32, IndexMap, error) {
+	imported, _ := s.Len(entry.Kind)
+	m, err := s.InsertImport(imported, entry)
+	return imported, m, err
+}
+
+// InsertImport inserts an import at an index of its index space, the index is at most the number of imports
+// of its kind. The import section is created if the module has none.
+func (s *IndexSpace) InsertImport(index uint32, entry ImportEntry) (IndexMap, error) {
+	if err := s.checkKind(entry.Kind); err != nil {
+		return nil, err
+	}
+	imported, defined := s.Len(entry.Kind)
+	if index > imported {
+		return nil, fmt.Errorf("%s import %d out of %d imports", entry.Kind, index, imported)
+	}
+
+	entries := s.imports()
+	pos, k := len(entries), uint32(0)
+	for i, e := range entries {
+		if e.Kind != entry.Kind {
+			continue
+		}
+		if k == index {
+			pos = i
+			break
+		}
+		k++
+	}
+	b := NewBuilder(s.module)
+	b.section("import")["entries"] = append(entries[:pos:pos], append([]ImportEntry{entry}, entries[pos:]...)...)
+	s.module = b.Module()
+
+	m := insertionMap(imported+defined, index)
+	return m, s.Remap(entry.Kind, m)
+}
+
+// Insert inserts a defined entity at an index of its index space, the index follows the imports. The entity is
+// a Function, a GlobalEntry, a Table or a MemLimits.
+func (s *IndexSpace) Insert(kind string, index uint32, entity interface{}) (IndexMap, error) {
+	if err := s.checkKind(kind); err != nil {
+		return nil, err
+	}
+	imported, defined := s.Len(kind)
+	if index < imported || index > imported+defined {
+		return nil, fmt.Errorf("%s %d out of the defined ones [%d, %d]", kind, index, imported, imported+defined)
+	}
+	pos := int(index - imported)
+
+	b := NewBuilder(s.module)
+	switch entity := entity.(type) {
+	case Function:
+		if kind != "function" {
+			return nil, fmt.Errorf("a function isn't a %s", kind)
+		}
+		code := b.section("code")
+		bodies, _ := code["entries"].([]CodeBody)
+		if len(bodies) != int(defined) {
+			return nil, fmt.Errorf("the code section has %d bodies for %d functions", len(bodies), defined)
+		}
+		funcs := b.section("function")
+		types, _ := funcs["entries"].([]uint64)
+		funcs["entries"] = append(types[:pos:pos], append([]uint64{uint64(entity.Type)}, types[pos:]...)...)
+		code["entries"] = append(bodies[:pos:pos], append([]CodeBody{entity.Body}, bodies[pos:]...)...)
+	case GlobalEntry:
+		if kind != "global" {
+			return nil, fmt.Errorf("a global isn't a %s", kind)
+		}
+		section := b.section("global")
+		entries, _ := section["entries"].([]GlobalEntry)
+		section["entries"] = append(entries[:pos:pos], append([]GlobalEntry{entity}, entries[pos:]...)...)
+	case Table:
+		if kind != "table" {
+			return nil, fmt.Errorf("a table isn't a %s", kind)
+		}
+		section := b.section("table")
+		entries, _ := section["entries"].([]Table)
+		section["entries"] = append(entries[:pos:pos], append([]Table{entity}, entries[pos:]...)...)
+	case MemLimits:
+		if kind != "memory" {
+			return nil, fmt.Errorf("a memory isn't a %s", kind)
+		}
+		section := b.section("memory")
+		entries, _ := section["entries"].([]MemLimits)
+		section["entries"] = append(entries[:pos:pos], append([]MemLimits{entity}, entries[pos:]...)...)
+	default:
+		return nil, fmt.Errorf("invalid %s: %T", kind, entity)
+	}
+	s.module = b.Module()
+
+	m := insertionMap(imported+defined, index)
+	return m, s.Remap(kind, m)
+}
+
+// insertionMap maps the indices of an index space of the given size once an entity is inserted at index.
+func insertionMap(size, index uint32) IndexMap {
+	m := make(IndexMap, size)
+	for i := range m {
+		m[i] = i
+		if uint32(i) >= index {
+			m[i]++
+		}
+	}
+	return m
+}
+
+// Remove removes imported or defined entities of a kind. It's an error if the module still refers to one of
+// them out of the removed entities, but their names are removed.
+func (s *IndexSpace) Remove(kind string, indices ...uint32) (IndexMap, error) {
+	if err := s.checkKind(kind); err != nil {
+		return nil, err
+	}
+	imported, defined := s.Len(kind)
+	removed := map[uint32]struct{}{}
+	for _, index := range indices {
+		if index >= imported+defined {
+			return nil, fmt.Errorf("%s %d out of %d", kind, index, imported+defined)
+		}
+		removed[index] = struct{}{}
+	}
+
+	// the removed functions and globals may refer to each other.
+	var referenced []uint32
+	s.walkRefs(kind, func(index uint32) uint32 {
+		if _, exist := removed[index]; exist {
+			referenced = append(referenced, index)
+		}
+		return index
+	}, func(section string, pos int) bool {
+		if section != indexSpaceSections[kind] {
+			return false
+		}
+		_, exist := removed[imported+uint32(pos)]
+		return exist
+	})
+	if len(referenced) > 0 {
+		return nil, fmt.Errorf("%s %d is still referenced", kind, referenced[0])
+	}
+
+	m := make(IndexMap, imported+defined)
+	next := 0
+	for i := range m {
+		if _, exist := removed[uint32(i)]; exist {
+			m[i] = -1
+			continue
+		}
+		m[i] = next
+		next++
+	}
+
+	// the imports.
+	if section := s.section("import"); section != nil {
+		entries := s.imports()
+		kept := make([]ImportEntry, 0, len(entries))
+		k := uint32(0)
+		for _, entry := range entries {
+			if entry.Kind == kind {
+				k++
+				if _, exist := removed[k-1]; exist {
+					continue
+				}
+			}
+			kept = append(kept, entry)
+		}
+		section["entries"] = kept
+	}
+
+	// the defined entities.
+	isRemoved := func(pos int) bool {
+		_, exist := removed[imported+uint32(pos)]
+		return exist
+	}
+	if section := s.section(indexSpaceSections[kind]); section != nil {
+		switch entries := section["entries"].(type) {
+		case []uint64:
+			kept := make([]uint64, 0, len(entries))
+			for pos, entry := range entries {
+				if !isRemoved(pos) {
+					kept = append(kept, entry)
+				}
+			}
+			section["entries"] = kept
+			if code := s.section("code"); code != nil {
+				bodies, _ := code["entries"].([]CodeBody)
+				keptBodies := make([]CodeBody, 0, len(bodies))
+				for pos, body := range bodies {
+					if !isRemoved(pos) {
+						keptBodies = append(keptBodies, body)
+					}
+				}
+				code["entries"] = keptBodies
+			}
+		case []GlobalEntry:
+			kept := make([]GlobalEntry, 0, len(entries))
+			for pos, entry := range entries {
+				if !isRemoved(pos) {
+					kept = append(kept, entry)
+				}
+			}
+			section["entries"] = kept
+		case []Table:
+			kept := make([]Table, 0, len(entries))
+			for pos, entry := range entries {
+				if !isRemoved(pos) {
+					kept = append(kept, entry)
+				}
+			}
+			section["entries"] = kept
+		case []MemLimits:
+			kept := make([]MemLimits, 0, len(entries))
+			for pos, entry := range entries {
+				if !isRemoved(pos) {
+					kept = append(kept, entry)
+				}
+			}
+			section["entries"] = kept
+		}
+	}
+	return m, s.Remap(kind, m)
+}
+
+// Remap renumbers the references to the entities of a kind, the names of the removed ones are removed. The
+// entities themselves are left in place.
+func (s *IndexSpace) Remap(kind string, m IndexMap) error {
+	if err := s.checkKind(kind); err != nil {
+		return err
+	}
+	s.walkRefs(kind, func(index uint32) uint32 {
+		newIndex, _ := m.Map(index)
+		return newIndex
+	}, nil)
+	return s.remapNames(kind, m)
+}
+
+// walkRefs replaces every reference to an entity of a kind by the index visit returns. The bodies of the
+// functions and the initializers of the globals skip reports by their section ("function" or "global") and
+// their position aren't walked.
+func (s *IndexSpace) walkRefs(kind string, visit func(index uint32) uint32, skip func(section string, pos int) bool) {
+	if skip == nil {
+		skip = func(string, int) bool { return false }
+	}
+	walkOps := func(ops []OP) {
+		for i := range ops {
+			walkOpRefs(&ops[i], kind, visit)
+		}
+	}
+
+	for _, section := range s.module {
+		switch section["name"] {
+		case "export":
+			entries, _ := section["entries"].([]ExportEntry)
+			for i, entry := range entries {
+				if entry.Kind == kind {
+					entries[i].Index = visit(entry.Index)
+				}
+			}
+		case "start":
+			if kind == "function" {
+				section["index"] = visit(section["index"].(uint32))
+			}
+		case "global":
+			entries, _ := section["entries"].([]GlobalEntry)
+			for pos, entry := range entries {
+				if !skip("global", pos) {
+					walkOps(entry.Init)
+				}
+			}
+		case "element":
+			entries, _ := section["entries"].([]ElementEntry)
+			for i, entry := range entries {
+				if entry.Flags&ELEM_FLAG_PASSIVE == 0 {
+					if kind == "table" {
+						entries[i].Index = visit(entry.Index)
+					}
+					walkOps(entry.Offset)
+				}
+				if kind == "function" {
+					for j, elem := range entry.Elements {
+						entry.Elements[j] = uint64(visit(uint32(elem)))
+					}
+				}
+				for _, expr := range entry.Exprs {
+					walkOps(expr)
+				}
+			}
+		case "data":
+			entries, _ := section["entries"].([]DataSegment)
+			for i, entry := range entries {
+				if entry.Flags&DATA_FLAG_PASSIVE != 0 {
+					continue
+				}
+				if kind == "memory" {
+					entries[i].Index = visit(entry.Index)
+					if entries[i].Index != 0 {
+						entries[i].Flags |= DATA_FLAG_INDEX
+					}
+				}
+				walkOps(entry.Offset)
+			}
+		case "code":
+			entries, _ := section["entries"].([]CodeBody)
+			for pos, entry := range entries {
+				if !skip("function", pos) {
+					walkOps(entry.Code)
+				}
+			}
+		}
+	}
+}
+
+// walkOpRefs replaces the references of an operator to an entity of a kind.
+func walkOpRefs(op *OP, kind string, visit func(index uint32) uint32) {
+	visitImm := func() {
+		if index, ok := op.Immediates.(uint32); ok {
+			op.Immediates = visit(index)
+		}
+	}
+	visitField := func(imm JSON, field string) {
+		if index, ok := imm[field].(uint32); ok {
+			imm[field] = visit(index)
+		}
+	}
+	imm, _ := op.Immediates.(JSON)
+
+	switch kind {
+	case "function":
+		if op.Name == "call" || op.Name == "return_call" || (op.ReturnType == "ref" && op.Name == "func") {
+			visitImm()
+		}
+	case "global":
+		if op.Name == "get_global" || op.Name == "set_global" {
+			visitImm()
+		}
+	case "table":
+		switch {
+		case op.Name == "call_indirect" || op.Name == "return_call_indirect":
+			if table, ok := imm["reserved"].(uint32); ok {
+				imm["reserved"] = visit(table)
+			}
+		case op.ReturnType == "table" && op.Name == "init":
+			visitField(imm, "table")
+		case op.ReturnType == "table" && op.Name == "copy":
+			visitField(imm, "dst")
+			visitField(imm, "src")
+		case op.ReturnType == "table":
+			visitImm()
+		}
+	case "memory":
+		switch {
+		case op.Name == "current_memory" || op.Name == "grow_memory":
+			visitImm()
+		case op.ReturnType == "memory" && op.Name == "init":
+			visitField(imm, "memory")
+		case op.ReturnType == "memory" && op.Name == "copy":
+			visitField(imm, "dst")
+			visitField(imm, "src")
+		case op.ReturnType == "memory" && op.Name == "fill":
+			visitImm()
+		default:
+			if kind, _ := LookupImmediates(op.ReturnType, op.Name); kind != "memory_immediate" || imm == nil {
+				return
+			}
+			memory, _ := imm["memory"].(uint32)
+			if newMemory := visit(memory); newMemory != memory {
+				imm["memory"] = newMemory
+				imm["flags"] = imm["flags"].(uint64) | MEMORY_FLAG_INDEX
+			}
+		}
+	}
+}
+
+// nameAssoc is an entry of a name map of the "name" section, the locals are the local names of a function.
+type nameAssoc struct {
+	index  uint32
+	name   string
+	locals []nameAssoc
+}
+
+// remapNames renumbers the names of the entities of a kind in the "name" section.
+func (s *IndexSpace) remapNames(kind string, m IndexMap) error {
+	pos := findCustomSection(s.module, "name")
+	if pos < 0 {
+		return nil
+	}
+	subsections := []byte{nameSubsections[kind]}
+	if kind == "function" {
+		subsections = append(subsections, NAME_SUBSECTION_LOCALS)
+	}
+
+	stream := NewStream([]byte(s.module[pos]["payload"].(string)))
+	out := NewStream(nil)
+	for stream.Len() > 0 {
+		id := stream.ReadByte()
+		size := DecodeULEB128(stream)
+		if size > uint64(stream.Len()) {
+			return fmt.Errorf("name subsection %d: truncated", id)
+		}
+		payload := stream.Read(int(size))
+		if id != subsections[0] && (len(subsections) == 1 || id != subsections[1]) {
+			out.WriteByte(id)
+			EncodeULEB128(size, out)
+			out.Write(payload)
+			continue
+		}
+
+		assocs := readNameMap(NewStream(payload), id == NAME_SUBSECTION_LOCALS)
+		kept := assocs[:0]
+		for _, assoc := range assocs {
+			if index, exist := m.Map(assoc.index); exist {
+				assoc.index = index
+				kept = append(kept, assoc)
+			}
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			return kept[i].index < kept[j].index
+		})
+
+		sub := NewStream(nil)
+		writeNameMap(kept, id == NAME_SUBSECTION_LOCALS, sub)
+		out.WriteByte(id)
+		EncodeULEB128(uint64(sub.Len()), out)
+		out.Write(sub.Bytes())
+	}
+	s.module[pos]["payload"] = out.String()
+	return nil
+}
+
+func readNameMap(stream *Stream, indirect bool) []nameAssoc {
+	count := DecodeULEB128(stream)
+	assocs := make([]nameAssoc, 0, count)
+	for i := uint64(0); i < count && stream.Len() > 0; i++ {
+		assoc := nameAssoc{index: uint32(DecodeULEB128(stream))}
+		if indirect {
+			assoc.locals = readNameMap(stream, false)
+		} else {
+			assoc.name = readName(stream)
+		}
+		assocs = append(assocs, assoc)
+	}
+	return assocs
+}
+
+func writeNameMap(assocs []nameAssoc, indirect bool, stream *Stream) {
+	EncodeULEB128(uint64(len(assocs)), stream)
+	for _, assoc := range assocs {
+		EncodeULEB128(uint64(assoc.index), stream)
+		if indirect {
+			writeNameMap(assoc.locals, false, stream)
+		} else {
+			writeName(assoc.name, stream)
+		}
+	}
+}
+
+// a value type or a heap type referring to a type by its index, i.e. `(ref null 3)`.
+var typeRefPattern = regexp.MustCompile(`^(\(ref (null )?)?\d+\)?$`)
+
+// the kinds of the immediates that may refer to the types of the garbage collection proposal.
+var gcImmediates = map[string]struct{}{
+	"heap_type":   {},
+	"field":       {},
+	"array_fixed": {},
+	"array_data":  {},
+	"array_elem":  {},
+	"br_on_cast":  {},
+	"select_t":    {},
+}
+
+// RemapTypes rebuilds the type section from a map of its indices: the type at a new index is the first one
+// mapped to it, the ones mapped to -1 are removed. The references to the types are renumbered, so the types
+// mapped to the same index must be equal. Only the modules whose types are function signatures, that aren't
+// referred to by value types, can be remapped.
+func (s *IndexSpace) RemapTypes(m IndexMap) error {
+	section := s.section("type")
+	if section == nil {
+		return nil
+	}
+	types, _ := section["entries"].([]TypeEntry)
+	if len(m) != len(types) {
+		return fmt.Errorf("the map has %d types out of %d", len(m), len(types))
+	}
+	if err := s.checkPlainTypes(types); err != nil {
+		return err
+	}
+
+	var newTypes []TypeEntry
+	for old, index := range m {
+		if index < 0 {
+			continue
+		}
+		if index > len(newTypes) {
+			return fmt.Errorf("type %d mapped to %d before %d", old, index, len(newTypes))
+		}
+		if index == len(newTypes) {
+			newTypes = append(newTypes, types[old])
+		}
+	}
+	if newTypes == nil {
+		newTypes = []TypeEntry{}
+	}
+
+	var err error
+	remap := func(index uint32) uint32 {
+		newIndex, exist := m.Map(index)
+		if !exist && err == nil {
+			err = fmt.Errorf("type %d is still referenced", index)
+		}
+		return newIndex
+	}
+	remapBlockType := func(blockType string) string {
+		if index, exist := ParseTypeIndex(blockType); exist {
+			return fmt.Sprintf("(type %d)", remap(index))
+		}
+		return blockType
+	}
+	s.walkTypeRefs(remap, remapBlockType)
+	if err != nil {
+		return err
+	}
+	section["entries"] = newTypes
+	return s.remapNames("type", m)
+}
+
+// checkPlainTypes returns an error if the types of a module aren't only function signatures.
+func (s *IndexSpace) checkPlainTypes(types []TypeEntry) error {
+	isTypeRef := func(typ string) bool {
+		return typeRefPattern.MatchString(typ)
+	}
+	for i, typ := range types {
+		if typ.Form != "func" || typ.Sub != "" || len(typ.Supertypes) > 0 || typ.RecGroup > 0 {
+			return fmt.Errorf("type %d isn't a function signature", i)
+		}
+		for _, param := range append([]string{typ.ReturnType}, typ.Params...) {
+			if isTypeRef(param) {
+				return fmt.Errorf("type %d refers to a type", i)
+			}
+		}
+	}
+	for _, section := range s.module {
+		switch section["name"] {
+		case "global":
+			entries, _ := section["entries"].([]GlobalEntry)
+			for i, entry := range entries {
+				if isTypeRef(entry.Type.ContentType) {
+					return fmt.Errorf("global %d refers to a type", i)
+				}
+			}
+		case "table":
+			entries, _ := section["entries"].([]Table)
+			for i, entry := range entries {
+				if isTypeRef(entry.ElementType) {
+					return fmt.Errorf("table %d refers to a type", i)
+				}
+			}
+		case "code":
+			entries, _ := section["entries"].([]CodeBody)
+			for i, entry := range entries {
+				for _, local := range entry.Locals {
+					if isTypeRef(local.Type) {
+						return fmt.Errorf("function %d: a local refers to a type", i)
+					}
+				}
+				for _, op := range entry.Code {
+					kind, _ := LookupImmediates(op.ReturnType, op.Name)
+					if _, exist := gcImmediates[kind]; exist || op.ReturnType == "struct" || op.ReturnType == "array" {
+						return fmt.Errorf("function %d: %s may refer to a type", i, op.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// walkTypeRefs replaces the references to the types by the index remap returns, the block types are
+// replaced by the ones remapBlockType returns.
+func (s *IndexSpace) walkTypeRefs(remap func(index uint32) uint32, remapBlockType func(blockType string) string) {
+	for _, section := range s.module {
+		switch section["name"] {
+		case "import":
+			entries, _ := section["entries"].([]ImportEntry)
+			for i, entry := range entries {
+				switch typ := entry.Type.(type) {
+				case uint64:
+					if entry.Kind == "function" {
+						entries[i].Type = uint64(remap(uint32(typ)))
+					}
+				case Tag:
+					typ.Type = remap(typ.Type)
+					entries[i].Type = typ
+				}
+			}
+		case "function":
+			entries, _ := section["entries"].([]uint64)
+			for i, typ := range entries {
+				entries[i] = uint64(remap(uint32(typ)))
+			}
+		case "tag":
+			entries, _ := section["entries"].([]Tag)
+			for i, tag := range entries {
+				entries[i].Type = remap(tag.Type)
+			}
+		case "code":
+			entries, _ := section["entries"].([]CodeBody)
+			for _, entry := range entries {
+				for i, op := range entry.Code {
+					switch imm := op.Immediates.(type) {
+					case string:
+						if kind, _ := LookupImmediates(op.ReturnType, op.Name); kind == "block_type" {
+							entry.Code[i].Immediates = remapBlockType(imm)
+						}
+					case uint32:
+						if op.Name == "call_ref" || op.Name == "return_call_ref" {
+							entry.Code[i].Immediates = remap(imm)
+						}
+					case JSON:
+						switch op.Name {
+						case "call_indirect", "return_call_indirect":
+							imm["index"] = uint64(remap(uint32(imm["index"].(uint64))))
+						case "try_table":
+							imm["block_type"] = remapBlockType(imm["block_type"].(string))
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// CanRemapTypes reports whether the types of the module can be remapped (see RemapTypes).
+func (s *IndexSpace) CanRemapTypes() bool {
+	if section := s.section("type"); section != nil {
+		types, _ := section["entries"].([]TypeEntry)
+		return s.checkPlainTypes(types) == nil
+	}
+	return true
+}
+
+// RemoveUnusedTypes removes the types the module doesn't refer to, the types must be remappable.
+func (s *IndexSpace) RemoveUnusedTypes() (IndexMap, error) {
+	section := s.section("type")
+	if section == nil {
+		return IndexMap{}, nil
+	}
+	types, _ := section["entries"].([]TypeEntry)
+	used := make([]bool, len(types))
+	s.walkTypeRefs(func(index uint32) uint32 {
+		if index < uint32(len(used)) {
+			used[index] = true
+		}
+		return index
+	}, func(blockType string) string {
+		if index, exist := ParseTypeIndex(blockType); exist && index < uint32(len(used)) {
+			used[index] = true
+		}
+		return blockType
+	})
+
+	m := make(IndexMap, len(types))
+	next := 0
+	for i := range m {
+		m[i] = -1
+		if used[i] {
+			m[i] = next
+			next++
+		}
+	}
+	return m, s.RemapTypes(m)
+}
+
+// DedupTypes merges the identical function types into the first one of them, the types must be remappable.
+func (s *IndexSpace) DedupTypes() (IndexMap, error) {
+	section := s.section("type")
+	if section == nil {
+		return IndexMap{}, nil
+	}
+	types, _ := section["entries"].([]TypeEntry)
+	m := make(IndexMap, len(types))
+	next := 0
+	for i, typ := range types {
+		m[i] = next
+		for j := 0; j < i; j++ {
+			if types[j].Form == typ.Form && types[j].ReturnType == typ.ReturnType && equalTypes(types[j].Params, typ.Params) {
+				m[i] = m[j]
+				break
+			}
+		}
+		if m[i] == next {
+			next++
+		}
+	}
+	return m, s.RemapTypes(m)
+}
+
+// ParseTypeIndex parses the index of a type from a block type, i.e. `(type 3)`.
+func ParseTypeIndex(blockType string) (uint32, bool) {
+	var index uint32
+	if _, err := fmt.Sscanf(blockType, "(type %d)", &index); err != nil {
+		return 0, false
+	}
+	return index, true
+}
@@ -1,6 +1,10 @@
 package toolkit
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 var (
 	J2W_LANGUAGE_TYPES = map[string]byte{
@@ -10,7 +14,45 @@ var (
 		"f64":        0x7c,
 		"anyFunc":    0x70,
 		"func":       0x60,
+		"exnref":     0x69,
 		"block_type": 0x40,
+
+		// reference types abbreviating `(ref null ht)`.
+		"externref":     0x6f,
+		"anyref":        0x6e,
+		"eqref":         0x6d,
+		"i31ref":        0x6c,
+		"structref":     0x6b,
+		"arrayref":      0x6a,
+		"nullfuncref":   0x73,
+		"nullexternref": 0x72,
+		"nullref":       0x71,
+		"nullexnref":    0x74,
+
+		// garbage collection types.
+		"v128":      0x7b,
+		"i8":        0x78,
+		"i16":       0x77,
+		"struct":    0x5f,
+		"array":     0x5e,
+		"sub":       0x50,
+		"sub_final": 0x4f,
+		"rec":       0x4e,
+	}
+
+	J2W_HEAP_TYPES = map[string]byte{
+		"func":     0x70,
+		"extern":   0x6f,
+		"any":      0x6e,
+		"eq":       0x6d,
+		"i31":      0x6c,
+		"struct":   0x6b,
+		"array":    0x6a,
+		"nofunc":   0x73,
+		"noextern": 0x72,
+		"none":     0x71,
+		"exn":      0x69,
+		"noexn":    0x74,
 	}
 
 	J2W_EXTERNAL_KIND = map[string]byte{
@@ -18,21 +60,24 @@ var (
 		"table":    1,
 		"memory":   2,
 		"global":   3,
+		"tag":      4,
 	}
 
 	J2W_SECTION_IDS = map[string]byte{
-		"custom":   0,
-		"type":     1,
-		"import":   2,
-		"function": 3,
-		"table":    4,
-		"memory":   5,
-		"global":   6,
-		"export":   7,
-		"start":    8,
-		"element":  9,
-		"code":     10,
-		"data":     11,
+		"custom":    0,
+		"type":      1,
+		"import":    2,
+		"function":  3,
+		"table":     4,
+		"memory":    5,
+		"global":    6,
+		"export":    7,
+		"start":     8,
+		"element":   9,
+		"code":      10,
+		"data":      11,
+		"datacount": 12,
+		"tag":       13,
 	}
 
 	J2W_OPCODES = map[string]byte{
@@ -42,6 +87,11 @@ var (
 		"loop":                0x3,
 		"if":                  0x4,
 		"else":                0x5,
+		"try":                 0x6,
+		"catch":               0x7,
+		"throw":               0x8,
+		"rethrow":             0x9,
+		"throw_ref":           0xa,
 		"end":                 0xb,
 		"br":                  0xc,
 		"br_if":               0xd,
@@ -49,8 +99,11 @@ var (
 		"return":              0xf,
 		"call":                0x10,
 		"call_indirect":       0x11,
+		"delegate":            0x18,
+		"catch_all":           0x19,
 		"drop":                0x1a,
 		"select":              0x1b,
+		"try_table":           0x1f,
 		"get_local":           0x20,
 		"set_local":           0x21,
 		"tee_local":           0x22,
@@ -208,6 +261,167 @@ var (
 		"i64.reinterpret/f64": 0xbd,
 		"f32.reinterpret/i32": 0xbe,
 		"f64.reinterpret/i64": 0xbf,
+
+		// tail calls.
+		"return_call":          0x12,
+		"return_call_indirect": 0x13,
+
+		// reference types.
+		"select_t":    0x1c,
+		"table.get":   0x25,
+		"table.set":   0x26,
+		"ref.null":    0xd0,
+		"ref.is_null": 0xd1,
+		"ref.func":    0xd2,
+
+		// typed function references.
+		"call_ref":        0x14,
+		"return_call_ref": 0x15,
+		"ref.as_non_null": 0xd3,
+		"br_on_null":      0xd4,
+		"ref.eq":          0xd5,
+		"br_on_non_null":  0xd6,
+	}
+
+	J2W_MISC_OPCODES = map[string]uint32{
+		"i32.trunc_s:sat/f32": 0x0,
+		"i32.trunc_u:sat/f32": 0x1,
+		"i32.trunc_s:sat/f64": 0x2,
+		"i32.trunc_u:sat/f64": 0x3,
+		"i64.trunc_s:sat/f32": 0x4,
+		"i64.trunc_u:sat/f32": 0x5,
+		"i64.trunc_s:sat/f64": 0x6,
+		"i64.trunc_u:sat/f64": 0x7,
+		"memory.init":         0x8,
+		"data.drop":           0x9,
+		"memory.copy":         0xa,
+		"memory.fill":         0xb,
+		"table.init":          0xc,
+		"elem.drop":           0xd,
+		"table.copy":          0xe,
+
+		// reference types.
+		"table.grow": 0xf,
+		"table.size": 0x10,
+		"table.fill": 0x11,
+	}
+
+	J2W_ATOMIC_OPCODES = map[string]uint32{
+		"memory.atomic.notify":       0x0,
+		"memory.atomic.wait32":       0x1,
+		"memory.atomic.wait64":       0x2,
+		"atomic.fence":               0x3,
+		"i32.atomic.load":            0x10,
+		"i64.atomic.load":            0x11,
+		"i32.atomic.load8_u":         0x12,
+		"i32.atomic.load16_u":        0x13,
+		"i64.atomic.load8_u":         0x14,
+		"i64.atomic.load16_u":        0x15,
+		"i64.atomic.load32_u":        0x16,
+		"i32.atomic.store":           0x17,
+		"i64.atomic.store":           0x18,
+		"i32.atomic.store8":          0x19,
+		"i32.atomic.store16":         0x1a,
+		"i64.atomic.store8":          0x1b,
+		"i64.atomic.store16":         0x1c,
+		"i64.atomic.store32":         0x1d,
+		"i32.atomic.rmw.add":         0x1e,
+		"i64.atomic.rmw.add":         0x1f,
+		"i32.atomic.rmw8.add_u":      0x20,
+		"i32.atomic.rmw16.add_u":     0x21,
+		"i64.atomic.rmw8.add_u":      0x22,
+		"i64.atomic.rmw16.add_u":     0x23,
+		"i64.atomic.rmw32.add_u":     0x24,
+		"i32.atomic.rmw.sub":         0x25,
+		"i64.atomic.rmw.sub":         0x26,
+		"i32.atomic.rmw8.sub_u":      0x27,
+		"i32.atomic.rmw16.sub_u":     0x28,
+		"i64.atomic.rmw8.sub_u":      0x29,
+		"i64.atomic.rmw16.sub_u":     0x2a,
+		"i64.atomic.rmw32.sub_u":     0x2b,
+		"i32.atomic.rmw.and":         0x2c,
+		"i64.atomic.rmw.and":         0x2d,
+		"i32.atomic.rmw8.and_u":      0x2e,
+		"i32.atomic.rmw16.and_u":     0x2f,
+		"i64.atomic.rmw8.and_u":      0x30,
+		"i64.atomic.rmw16.and_u":     0x31,
+		"i64.atomic.rmw32.and_u":     0x32,
+		"i32.atomic.rmw.or":          0x33,
+		"i64.atomic.rmw.or":          0x34,
+		"i32.atomic.rmw8.or_u":       0x35,
+		"i32.atomic.rmw16.or_u":      0x36,
+		"i64.atomic.rmw8.or_u":       0x37,
+		"i64.atomic.rmw16.or_u":      0x38,
+		"i64.atomic.rmw32.or_u":      0x39,
+		"i32.atomic.rmw.xor":         0x3a,
+		"i64.atomic.rmw.xor":         0x3b,
+		"i32.atomic.rmw8.xor_u":      0x3c,
+		"i32.atomic.rmw16.xor_u":     0x3d,
+		"i64.atomic.rmw8.xor_u":      0x3e,
+		"i64.atomic.rmw16.xor_u":     0x3f,
+		"i64.atomic.rmw32.xor_u":     0x40,
+		"i32.atomic.rmw.xchg":        0x41,
+		"i64.atomic.rmw.xchg":        0x42,
+		"i32.atomic.rmw8.xchg_u":     0x43,
+		"i32.atomic.rmw16.xchg_u":    0x44,
+		"i64.atomic.rmw8.xchg_u":     0x45,
+		"i64.atomic.rmw16.xchg_u":    0x46,
+		"i64.atomic.rmw32.xchg_u":    0x47,
+		"i32.atomic.rmw.cmpxchg":     0x48,
+		"i64.atomic.rmw.cmpxchg":     0x49,
+		"i32.atomic.rmw8.cmpxchg_u":  0x4a,
+		"i32.atomic.rmw16.cmpxchg_u": 0x4b,
+		"i64.atomic.rmw8.cmpxchg_u":  0x4c,
+		"i64.atomic.rmw16.cmpxchg_u": 0x4d,
+		"i64.atomic.rmw32.cmpxchg_u": 0x4e,
+	}
+
+	J2W_GC_OPCODES = map[string]uint32{
+		"struct.new":         0x0,
+		"struct.new_default": 0x1,
+		"struct.get":         0x2,
+		"struct.get_s":       0x3,
+		"struct.get_u":       0x4,
+		"struct.set":         0x5,
+		"array.new":          0x6,
+		"array.new_default":  0x7,
+		"array.new_fixed":    0x8,
+		"array.new_data":     0x9,
+		"array.new_elem":     0xa,
+		"array.get":          0xb,
+		"array.get_s":        0xc,
+		"array.get_u":        0xd,
+		"array.set":          0xe,
+		"array.len":          0xf,
+		"array.fill":         0x10,
+		"array.copy":         0x11,
+		"array.init_data":    0x12,
+		"array.init_elem":    0x13,
+		"ref.test":           0x14,
+		"ref.test_null":      0x15,
+		"ref.cast":           0x16,
+		"ref.cast_null":      0x17,
+		"br_on_cast":         0x18,
+		"br_on_cast_fail":    0x19,
+		"any.convert_extern": 0x1a,
+		"extern.convert_any": 0x1b,
+		"ref.i31":            0x1c,
+		"i31.get_s":          0x1d,
+		"i31.get_u":          0x1e,
+	}
+
+	// J2W_OPCODE_PREFIXES maps a prefix byte to the table of the operators it introduces.
+	J2W_OPCODE_PREFIXES = map[byte]map[string]uint32{
+		MISC_PREFIX:   J2W_MISC_OPCODES,
+		ATOMIC_PREFIX: J2W_ATOMIC_OPCODES,
+		GC_PREFIX:     J2W_GC_OPCODES,
+	}
+
+	J2W_CATCH_KINDS = map[string]byte{
+		"catch":         0x00,
+		"catch_ref":     0x01,
+		"catch_all":     0x02,
+		"catch_all_ref": 0x03,
 	}
 
 	typeGen  = typeGenerators{}
@@ -221,32 +435,116 @@ func (t typeGenerators) Function(num uint64, stream *Stream) {
 	EncodeULEB128(num, stream)
 }
 
-func (typeGenerators) Table(table Table, stream *Stream) {
-	stream.Write([]byte{J2W_LANGUAGE_TYPES[table.ElementType]})
-	typeGenerators{}.Memory(table.Limits, stream)
+func (t typeGenerators) Table(table Table, stream *Stream) {
+	t.ValueType(table.ElementType, stream)
+	t.Memory(table.Limits, stream)
 }
 
 // Generates a [`global_type`](https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#global_type)
-func (typeGenerators) Global(global Global, stream *Stream) {
-	stream.WriteByte(J2W_LANGUAGE_TYPES[global.ContentType])
+func (t typeGenerators) Global(global Global, stream *Stream) {
+	t.ValueType(global.ContentType, stream)
 	stream.WriteByte(global.Mutability)
 }
 
+// ValueType generates a value type, either abbreviated or named as `(ref ht)` or `(ref null ht)`.
+func (t typeGenerators) ValueType(typ string, stream *Stream) {
+	if b, exist := J2W_LANGUAGE_TYPES[typ]; exist {
+		stream.WriteByte(b)
+		return
+	}
+
+	heapType := strings.TrimSuffix(strings.TrimPrefix(typ, "(ref "), ")")
+	if strings.HasPrefix(heapType, "null ") {
+		stream.WriteByte(REF_NULL_TYPE)
+		heapType = strings.TrimPrefix(heapType, "null ")
+	} else {
+		stream.WriteByte(REF_TYPE)
+	}
+	t.HeapType(heapType, stream)
+}
+
+// HeapType generates an abstract heap type by its name or a concrete one by its type index.
+func (typeGenerators) HeapType(heapType string, stream *Stream) {
+	if b, exist := J2W_HEAP_TYPES[heapType]; exist {
+		stream.WriteByte(b)
+		return
+	}
+	index, _ := strconv.ParseInt(heapType, 10, 64)
+	EncodeSLEB128(index, stream)
+}
+
+func (t typeGenerators) Field(field FieldType, stream *Stream) {
+	t.ValueType(field.Type, stream)
+	stream.WriteByte(field.Mutability)
+}
+
+// SubType generates a type of the type section with its supertypes if they are declared.
+func (t typeGenerators) SubType(entry TypeEntry, stream *Stream) {
+	if entry.Sub != "" {
+		stream.WriteByte(J2W_LANGUAGE_TYPES[entry.Sub])
+		EncodeULEB128(uint64(len(entry.Supertypes)), stream)
+		for _, supertype := range entry.Supertypes {
+			EncodeULEB128(uint64(supertype), stream)
+		}
+	}
+	t.CompType(entry, stream)
+}
+
+// CompType generates a function, struct or array type.
+func (t typeGenerators) CompType(entry TypeEntry, stream *Stream) {
+	stream.WriteByte(J2W_LANGUAGE_TYPES[entry.Form])
+
+	switch entry.Form {
+	case "struct":
+		EncodeULEB128(uint64(len(entry.Fields)), stream)
+		for _, field := range entry.Fields {
+			t.Field(field, stream)
+		}
+		return
+	case "array":
+		t.Field(entry.Fields[0], stream)
+		return
+	}
+
+	// number of parameters
+	EncodeULEB128(uint64(len(entry.Params)), stream)
+	for _, typ := range entry.Params {
+		t.ValueType(typ, stream)
+	}
+
+	// number of return types
+	if entry.ReturnType != "" {
+		stream.WriteByte(1)
+		t.ValueType(entry.ReturnType, stream)
+	} else {
+		stream.WriteByte(0)
+	}
+}
+
 // Generates a [resizable_limits](https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#resizable_limits)
 func (typeGenerators) Memory(mem MemLimits, stream *Stream) {
+	// keep the other flags (i.e. shared), the maximum flag follows the maximum field.
+	flags := mem.Flags &^ LIMITS_FLAG_MAXIMUM
 	if mem.Maximum != nil {
-		EncodeULEB128(1, stream)
+		EncodeULEB128(flags|LIMITS_FLAG_MAXIMUM, stream)
 		EncodeULEB128(mem.Intial, stream)
 		EncodeULEB128(mem.Maximum.(uint64), stream)
 	} else {
-		EncodeULEB128(0, stream)
+		EncodeULEB128(flags, stream)
 		EncodeULEB128(mem.Intial, stream)
 	}
+}
 
+func (typeGenerators) Tag(tag Tag, stream *Stream) {
+	stream.WriteByte(tag.Attribute)
+	EncodeULEB128(uint64(tag.Type), stream)
 }
 
-func (typeGenerators) InitExpr(op OP, stream *Stream) {
-	GenerateOP(op, stream)
+// InitExpr generates a constant expression followed by its `end`.
+func (typeGenerators) InitExpr(ops []OP, stream *Stream) {
+	for _, op := range ops {
+		GenerateOP(op, stream)
+	}
 	GenerateOP(OP{
 		Name: "end",
 		Type: "void",
@@ -286,7 +584,57 @@ func (immediataryGenerators) Uint64(j []byte, stream *Stream) *Stream {
 }
 
 func (immediataryGenerators) BlockType(j string, stream *Stream) *Stream {
-	stream.WriteByte(J2W_LANGUAGE_TYPES[j])
+	if strings.HasPrefix(j, "(type ") {
+		index, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(j, "(type "), ")"), 10, 64)
+		EncodeSLEB128(index, stream)
+		return stream
+	}
+	typeGen.ValueType(j, stream)
+	return stream
+}
+
+func (immediataryGenerators) HeapType(j string, stream *Stream) *Stream {
+	typeGen.HeapType(j, stream)
+	return stream
+}
+
+func (immediataryGenerators) Field(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["type"].(uint32)), stream)
+	EncodeULEB128(uint64(j["field"].(uint32)), stream)
+	return stream
+}
+
+func (immediataryGenerators) ArrayFixed(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["type"].(uint32)), stream)
+	EncodeULEB128(uint64(j["size"].(uint32)), stream)
+	return stream
+}
+
+func (immediataryGenerators) ArrayData(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["type"].(uint32)), stream)
+	EncodeULEB128(uint64(j["data"].(uint32)), stream)
+	return stream
+}
+
+func (immediataryGenerators) ArrayElem(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["type"].(uint32)), stream)
+	EncodeULEB128(uint64(j["element"].(uint32)), stream)
+	return stream
+}
+
+func (immediataryGenerators) BrOnCast(j JSON, stream *Stream) *Stream {
+	stream.WriteByte(j["flags"].(byte))
+	EncodeULEB128(uint64(j["label"].(uint32)), stream)
+	typeGen.HeapType(j["from"].(string), stream)
+	typeGen.HeapType(j["to"].(string), stream)
+	return stream
+}
+
+func (immediataryGenerators) SelectT(j []string, stream *Stream) *Stream {
+	EncodeULEB128(uint64(len(j)), stream)
+	for _, typ := range j {
+		typeGen.ValueType(typ, stream)
+	}
 	return stream
 }
 
@@ -304,44 +652,70 @@ func (immediataryGenerators) BrTable(j JSON, stream *Stream) *Stream {
 func (immediataryGenerators) CallIndirect(j JSON, stream *Stream) *Stream {
 	index := j["index"]
 	EncodeULEB128(index.(uint64), stream)
-	stream.WriteByte(j["reserved"].(byte))
+	EncodeULEB128(uint64(j["reserved"].(uint32)), stream)
 	return stream
 }
 
 func (immediataryGenerators) MemoryImmediate(j JSON, stream *Stream) *Stream {
-	EncodeULEB128(j["flags"].(uint64), stream)
+	flags := j["flags"].(uint64)
+	EncodeULEB128(flags, stream)
+	if flags&MEMORY_FLAG_INDEX != 0 {
+		EncodeULEB128(uint64(j["memory"].(uint32)), stream)
+	}
 	EncodeULEB128(j["offset"].(uint64), stream)
 	return stream
 }
 
-type entryGenerators struct{}
+func (immediataryGenerators) MemoryInit(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["data"].(uint32)), stream)
+	EncodeULEB128(uint64(j["memory"].(uint32)), stream)
+	return stream
+}
 
-func (entryGenerators) Type(entry TypeEntry, stream *Stream) []byte {
-	// a single type entry binary encoded
-	stream.WriteByte(J2W_LANGUAGE_TYPES[entry.Form])
+func (immediataryGenerators) TableInit(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["element"].(uint32)), stream)
+	EncodeULEB128(uint64(j["table"].(uint32)), stream)
+	return stream
+}
 
-	// number of parameters
-	paramsLen := len(entry.Params)
-	EncodeULEB128(uint64(paramsLen), stream)
-	if paramsLen != 0 {
-		paramsType := make([]byte, 0, paramsLen)
-		for _, typ := range entry.Params {
-			paramsType = append(paramsType, J2W_LANGUAGE_TYPES[typ])
+func (immediataryGenerators) Copy(j JSON, stream *Stream) *Stream {
+	EncodeULEB128(uint64(j["dst"].(uint32)), stream)
+	EncodeULEB128(uint64(j["src"].(uint32)), stream)
+	return stream
+}
+
+func (immediataryGenerators) TryTable(j JSON, stream *Stream) *Stream {
+	immeGen.BlockType(j["block_type"].(string), stream)
+	catches := j["catches"].([]JSON)
+	EncodeULEB128(uint64(len(catches)), stream)
+	for _, catch := range catches {
+		kind := catch["kind"].(string)
+		stream.WriteByte(J2W_CATCH_KINDS[kind])
+		if kind == "catch" || kind == "catch_ref" {
+			EncodeULEB128(uint64(catch["tag"].(uint32)), stream)
 		}
-		stream.Write(paramsType)
+		EncodeULEB128(uint64(catch["label"].(uint32)), stream)
 	}
+	return stream
+}
 
-	// number of return types
-	if entry.ReturnType != "" {
-		stream.WriteByte(1)
-		stream.WriteByte(J2W_LANGUAGE_TYPES[entry.ReturnType])
-	} else {
-		stream.WriteByte(0)
-	}
+type entryGenerators struct{}
 
+func (entryGenerators) Type(entry TypeEntry, stream *Stream) []byte {
+	// a single type entry binary encoded
+	typeGen.SubType(entry, stream)
 	return stream.Bytes()
 }
 
+// RecGroup generates the types of a rec group, the first of which holds the size of the group.
+func (entryGenerators) RecGroup(entries []TypeEntry, stream *Stream) {
+	stream.WriteByte(J2W_LANGUAGE_TYPES["rec"])
+	EncodeULEB128(uint64(len(entries)), stream)
+	for _, entry := range entries {
+		typeGen.SubType(entry, stream)
+	}
+}
+
 func (entryGenerators) Import(entry ImportEntry, stream *Stream) {
 	// write the module string
 	moduleStr := entry.ModuleStr
@@ -363,6 +737,8 @@ func (entryGenerators) Import(entry ImportEntry, stream *Stream) {
 		typeGen.Memory(entry.Type.(MemLimits), stream)
 	case "global":
 		typeGen.Global(entry.Type.(Global), stream)
+	case "tag":
+		typeGen.Tag(entry.Type.(Tag), stream)
 	}
 }
 
@@ -395,8 +771,38 @@ func (entryGenerators) Export(entry ExportEntry, stream *Stream) *Stream {
 }
 
 func (entryGenerators) Element(entry ElementEntry, stream *Stream) *Stream {
-	EncodeULEB128(uint64(entry.Index), stream)
-	typeGenerators{}.InitExpr(entry.Offset, stream)
+	// an active segment of a table other than 0 needs an explicit index.
+	flags := entry.Flags
+	passive := flags&ELEM_FLAG_PASSIVE != 0
+	if !passive && entry.Index != 0 {
+		flags |= ELEM_FLAG_INDEX
+	}
+
+	EncodeULEB128(uint64(flags), stream)
+	if !passive && flags&ELEM_FLAG_INDEX != 0 {
+		EncodeULEB128(uint64(entry.Index), stream)
+	}
+	if !passive {
+		typeGen.InitExpr(entry.Offset, stream)
+	}
+
+	exprs := flags&ELEM_FLAG_EXPRS != 0
+	if flags&(ELEM_FLAG_PASSIVE|ELEM_FLAG_INDEX) != 0 {
+		if exprs {
+			typeGen.ValueType(entry.Type, stream)
+		} else {
+			stream.WriteByte(0x00)
+		}
+	}
+
+	if exprs {
+		EncodeULEB128(uint64(len(entry.Exprs)), stream)
+		for _, expr := range entry.Exprs {
+			typeGen.InitExpr(expr, stream)
+		}
+		return stream
+	}
+
 	EncodeULEB128(uint64(len(entry.Elements)), stream)
 	for _, elem := range entry.Elements {
 		EncodeULEB128(elem, stream)
@@ -406,26 +812,79 @@ func (entryGenerators) Element(entry ElementEntry, stream *Stream) *Stream {
 
 func (entryGenerators) Code(entry CodeBody, stream *Stream) *Stream {
 	codeStream := NewStream(nil)
+	generateCodeBody(entry, codeStream)
+
+	EncodeULEB128(uint64(codeStream.bytesWrote), stream)
+	stream.Write(codeStream.Bytes())
+	return stream
+}
+
+// generateCodeBody generates the locals and the code of a function body without its size,
+// it returns the offsets of the operators in the body.
+func generateCodeBody(entry CodeBody, codeStream *Stream) []uint32 {
 	// write the locals
 	EncodeULEB128(uint64(len(entry.Locals)), codeStream)
 	for _, local := range entry.Locals {
 		EncodeULEB128(uint64(local.Count), codeStream)
-		codeStream.WriteByte(J2W_LANGUAGE_TYPES[local.Type])
+		typeGen.ValueType(local.Type, codeStream)
 	}
 
 	// write opcode
+	offsets := make([]uint32, 0, len(entry.Code))
 	for _, op := range entry.Code {
+		offsets = append(offsets, uint32(codeStream.bytesWrote))
 		GenerateOP(op, codeStream)
 	}
+	return offsets
+}
 
-	EncodeULEB128(uint64(codeStream.bytesWrote), stream)
-	stream.Write(codeStream.Bytes())
-	return stream
+// FuncOffsets locates a function body in the payload of the code section.
+type FuncOffsets struct {
+	Start uint32   `json:"start"` // the offset of the size of the body.
+	Ops   []uint32 `json:"ops"`   // the offsets of the operators.
+	End   uint32   `json:"end"`   // the offset following the body.
+}
+
+// CodeOffsets returns the offsets of the function bodies and of their operators in the payload of
+// the code section generated for the entries. As the encoding round-trips, the offsets of a decoded
+// module are the ones of its binary.
+func CodeOffsets(entries []CodeBody) []FuncOffsets {
+	offsets := make([]FuncOffsets, 0, len(entries))
+	offset := uint32(uleb128Len(uint64(len(entries))))
+	for _, entry := range entries {
+		body := NewStream(nil)
+		ops := generateCodeBody(entry, body)
+
+		funcOffsets := FuncOffsets{Start: offset}
+		offset += uint32(uleb128Len(uint64(body.bytesWrote)))
+		for _, op := range ops {
+			funcOffsets.Ops = append(funcOffsets.Ops, offset+op)
+		}
+		offset += uint32(body.bytesWrote)
+		funcOffsets.End = offset
+		offsets = append(offsets, funcOffsets)
+	}
+	return offsets
+}
+
+func (entryGenerators) Tag(entry Tag, stream *Stream) {
+	typeGen.Tag(entry, stream)
 }
 
 func (entryGenerators) Data(entry DataSegment, stream *Stream) *Stream {
-	EncodeULEB128(uint64(entry.Index), stream)
-	typeGenerators{}.InitExpr(entry.Offset, stream)
+	// an active segment of a memory other than 0 needs an explicit index.
+	flags := entry.Flags
+	if flags&DATA_FLAG_PASSIVE == 0 && entry.Index != 0 {
+		flags |= DATA_FLAG_INDEX
+	}
+
+	EncodeULEB128(uint64(flags), stream)
+	if flags&DATA_FLAG_INDEX != 0 {
+		EncodeULEB128(uint64(entry.Index), stream)
+	}
+	if flags&DATA_FLAG_PASSIVE == 0 {
+		typeGenerators{}.InitExpr(entry.Offset, stream)
+	}
 	EncodeULEB128(uint64(len(entry.Data)), stream)
 	stream.Write(entry.Data)
 	return stream
@@ -444,6 +903,18 @@ func Json2Wasm(j []JSON) []byte {
 	return stream.Bytes()
 }
 
+// Json2WasmOffsets converts the JSON array like Json2Wasm, it also returns the offsets of the function
+// bodies and of their operators in the payload of the code section.
+func Json2WasmOffsets(j []JSON) ([]byte, []FuncOffsets) {
+	var offsets []FuncOffsets
+	for _, section := range j {
+		if section["name"] == "code" {
+			offsets = CodeOffsets(section["entries"].([]CodeBody))
+		}
+	}
+	return Json2Wasm(j), offsets
+}
+
 func GeneratePreramble(j JSON, stream *Stream) *Stream {
 	if stream == nil {
 		stream = NewStream(nil)
@@ -466,13 +937,20 @@ func GenerateOP(op OP, stream *Stream) *Stream {
 		name = op.ReturnType + "." + name
 	}
 
-	stream.WriteByte(J2W_OPCODES[name])
+	// the padded LEB128 numbers keep their widths.
+	stream.widths = op.Widths
+	defer func() {
+		stream.widths = nil
+	}()
 
-	immediateKey := op.Name
-	if immediateKey == "const" {
-		immediateKey = op.ReturnType
+	if prefix, code, exist := lookupPrefixedOpcode(name); exist {
+		stream.WriteByte(prefix)
+		EncodeULEB128(uint64(code), stream)
+	} else {
+		stream.WriteByte(J2W_OPCODES[name])
 	}
-	immediates, exist := OP_IMMEDIATES[immediateKey]
+
+	immediates, exist := LookupImmediates(op.ReturnType, op.Name)
 	if exist {
 		switch immediates {
 		case "block_type":
@@ -495,6 +973,28 @@ func GenerateOP(op OP, stream *Stream) *Stream {
 			immeGen.MemoryImmediate(op.Immediates.(JSON), stream)
 		case "br_table":
 			immeGen.BrTable(op.Immediates.(JSON), stream)
+		case "try_table":
+			immeGen.TryTable(op.Immediates.(JSON), stream)
+		case "memory_init":
+			immeGen.MemoryInit(op.Immediates.(JSON), stream)
+		case "table_init":
+			immeGen.TableInit(op.Immediates.(JSON), stream)
+		case "copy":
+			immeGen.Copy(op.Immediates.(JSON), stream)
+		case "heap_type":
+			immeGen.HeapType(op.Immediates.(string), stream)
+		case "field":
+			immeGen.Field(op.Immediates.(JSON), stream)
+		case "array_fixed":
+			immeGen.ArrayFixed(op.Immediates.(JSON), stream)
+		case "array_data":
+			immeGen.ArrayData(op.Immediates.(JSON), stream)
+		case "array_elem":
+			immeGen.ArrayElem(op.Immediates.(JSON), stream)
+		case "br_on_cast":
+			immeGen.BrOnCast(op.Immediates.(JSON), stream)
+		case "select_t":
+			immeGen.SelectT(op.Immediates.([]string), stream)
 		default:
 			panic(fmt.Sprintf("invalid op immediate: %s", immediates))
 		}
@@ -502,6 +1002,28 @@ func GenerateOP(op OP, stream *Stream) *Stream {
 	return stream
 }
 
+// countRecGroups counts the entries of the type section, a rec group being a single entry.
+func countRecGroups(entries []TypeEntry) int {
+	count := 0
+	for i := 0; i < len(entries); i++ {
+		if size := int(entries[i].RecGroup); size > 0 && i+size <= len(entries) {
+			i += size - 1
+		}
+		count++
+	}
+	return count
+}
+
+// lookupPrefixedOpcode finds the prefix and the opcode of an operator that is encoded with a prefix byte.
+func lookupPrefixedOpcode(name string) (prefix byte, code uint32, exist bool) {
+	for prefix, table := range J2W_OPCODE_PREFIXES {
+		if code, exist = table[name]; exist {
+			return prefix, code, true
+		}
+	}
+	return 0, 0, false
+}
+
 func GenerateSection(j JSON, stream *Stream) *Stream {
 	if stream == nil {
 		stream = NewStream(nil)
@@ -522,6 +1044,8 @@ func GenerateSection(j JSON, stream *Stream) *Stream {
 		payload.Write([]byte(j["payload"].(string)))
 	} else if name == "start" {
 		EncodeULEB128(uint64(j["index"].(uint32)), payload)
+	} else if name == "datacount" {
+		EncodeULEB128(uint64(j["count"].(uint32)), payload)
 	} else {
 		ientries, exist := j["entries"]
 		if exist {
@@ -529,9 +1053,14 @@ func GenerateSection(j JSON, stream *Stream) *Stream {
 			switch name {
 			case "type":
 				entries := ientries.([]TypeEntry)
-				EncodeULEB128(uint64(len(entries)), payload)
-				for _, entry := range entries {
-					entryGen.Type(entry, payload)
+				EncodeULEB128(uint64(countRecGroups(entries)), payload)
+				for i := 0; i < len(entries); i++ {
+					if size := int(entries[i].RecGroup); size > 0 && i+size <= len(entries) {
+						entryGen.RecGroup(entries[i:i+size], payload)
+						i += size - 1
+						continue
+					}
+					entryGen.Type(entries[i], payload)
 				}
 			case "import":
 				entries := ientries.([]ImportEntry)
@@ -587,6 +1116,12 @@ func GenerateSection(j JSON, stream *Stream) *Stream {
 				for _, entry := range entries {
 					entryGen.Data(entry, payload)
 				}
+			case "tag":
+				entries := ientries.([]Tag)
+				EncodeULEB128(uint64(len(entries)), payload)
+				for _, entry := range entries {
+					entryGen.Tag(entry, payload)
+				}
 			default:
 				panic(fmt.Sprintf("invalid section name: %s", name))
 			}
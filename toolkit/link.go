@@ -0,0 +1,446 @@
+package toolkit
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// the index spaces Link merges, the types, the element and the data segments are merged too.
+var linkKinds = []string{"function", "global", "table", "memory"}
+
+// Link links a library into a module: the imports of the module from the library, by the name it's imported
+// under, are resolved to the exports of the library with the same name and the same kind. The types, the
+// functions, the globals, the memories, the tables and the segments of the library are appended to the ones
+// of the module and every reference is renumbered. The imports of the library are imported by the module
+// unless it already imports them, its exports and its custom sections are dropped.
+//
+// The memories and the tables can't be merged: a module and a library can't both have one unless the library
+// imports it from the module's imports or the module imports it from the library. Neither the object files
+// nor the libraries with tags or types other than function signatures can be linked. The modules aren't
+// modified.
+func Link(main, lib []JSON, name string) ([]JSON, error) {
+	if IsRelocatable(main) || IsRelocatable(lib) {
+		return nil, fmt.Errorf("link: the object files can't be linked")
+	}
+	l := &linker{
+		name:       name,
+		main:       cloneModule(main),
+		lib:        cloneModule(lib),
+		resolved:   map[string]map[uint32]uint32{},
+		mainMaps:   map[string]IndexMap{},
+		libMaps:    map[string]IndexMap{},
+		nameMaps:   map[string]IndexMap{},
+		importMaps: map[string]IndexMap{},
+	}
+	for _, step := range []func() error{l.checkLib, l.mergeTypes, l.resolveImports, l.mergeImports, l.mapIndices, l.checkConflicts, l.renumber} {
+		if err := step(); err != nil {
+			return nil, fmt.Errorf("link: %v", err)
+		}
+	}
+	return l.merge()
+}
+
+// cloneModule copies a module deeply, the linker renumbers the references in place.
+func cloneModule(module []JSON) []JSON {
+	return Wasm2Json(Json2Wasm(module))
+}
+
+type linker struct {
+	name      string
+	main, lib []JSON
+	b         *Builder // the builder of the linked module, the one of the module.
+
+	libSpace *IndexSpace
+	types    []TypeEntry // the types of the linked module.
+	typeMap  IndexMap    // maps the types of the library to the ones of the linked module.
+	imports  []ImportEntry
+
+	resolved   map[string]map[uint32]uint32 // the imports of the module resolved to the entities of the library.
+	importMaps map[string]IndexMap          // maps the imports of the library to the imports of the linked module.
+	mainMaps   map[string]IndexMap
+	libMaps    map[string]IndexMap
+	nameMaps   map[string]IndexMap // the maps of the names of the module, the names of the resolved imports are removed.
+}
+
+func (l *linker) checkLib() error {
+	l.libSpace = NewIndexSpace(l.lib)
+	if l.libSpace.section("tag") != nil {
+		return fmt.Errorf("the library has tags")
+	}
+	for _, entry := range l.libSpace.imports() {
+		if entry.Kind == "tag" {
+			return fmt.Errorf("the library imports the tag %s.%s", entry.ModuleStr, entry.FieldStr)
+		}
+	}
+	if !l.libSpace.CanRemapTypes() {
+		return fmt.Errorf("the library has types that aren't function signatures")
+	}
+	return nil
+}
+
+// mergeTypes appends the types of the library to the ones of the module, the identical signatures are shared.
+func (l *linker) mergeTypes() error {
+	l.b = NewBuilder(l.main)
+	var libTypes []TypeEntry
+	if section := l.libSpace.section("type"); section != nil {
+		libTypes, _ = section["entries"].([]TypeEntry)
+	}
+	l.typeMap = make(IndexMap, len(libTypes))
+	for i, typ := range libTypes {
+		l.typeMap[i] = int(l.b.AddType(typ))
+	}
+	if pos := findSectionByName(l.b.module, "type"); pos >= 0 {
+		l.types, _ = l.b.module[pos]["entries"].([]TypeEntry)
+	}
+	l.main = l.b.Module()
+	return nil
+}
+
+func (l *linker) sameSignature(a, b uint32) bool {
+	if a >= uint32(len(l.types)) || b >= uint32(len(l.types)) {
+		return false
+	}
+	ta, tb := l.types[a], l.types[b]
+	return ta.Form == tb.Form && ta.ReturnType == tb.ReturnType && equalTypes(ta.Params, tb.Params)
+}
+
+// libEntityType returns the type of an entity of the library, a type of the linked module for the functions.
+func (l *linker) libEntityType(kind string, index uint32) (interface{}, bool) {
+	var imported uint32
+	for _, entry := range l.libSpace.imports() {
+		if entry.Kind != kind {
+			continue
+		}
+		if imported == index {
+			if kind == "function" {
+				typ, _ := l.typeMap.Map(uint32(entry.Type.(uint64)))
+				return uint64(typ), true
+			}
+			return entry.Type, true
+		}
+		imported++
+	}
+	index -= imported
+
+	section := l.libSpace.section(indexSpaceSections[kind])
+	if section == nil {
+		return nil, false
+	}
+	switch entries := section["entries"].(type) {
+	case []uint64:
+		if index < uint32(len(entries)) {
+			typ, _ := l.typeMap.Map(uint32(entries[index]))
+			return uint64(typ), true
+		}
+	case []GlobalEntry:
+		if index < uint32(len(entries)) {
+			return entries[index].Type, true
+		}
+	case []Table:
+		if index < uint32(len(entries)) {
+			return entries[index], true
+		}
+	case []MemLimits:
+		if index < uint32(len(entries)) {
+			return entries[index], true
+		}
+	}
+	return nil, false
+}
+
+// resolveImports resolves the imports of the module from the library to its exports.
+func (l *linker) resolveImports() error {
+	var exports []ExportEntry
+	if section := l.libSpace.section("export"); section != nil {
+		exports, _ = section["entries"].([]ExportEntry)
+	}
+	counts := map[string]uint32{}
+	for _, entry := range NewIndexSpace(l.main).imports() {
+		index := counts[entry.Kind]
+		counts[entry.Kind]++
+		if entry.ModuleStr != l.name {
+			continue
+		}
+
+		var export *ExportEntry
+		for i := range exports {
+			if exports[i].FieldStr == entry.FieldStr {
+				export = &exports[i]
+				break
+			}
+		}
+		if export == nil {
+			return fmt.Errorf("import %s.%s: the library doesn't export it", entry.ModuleStr, entry.FieldStr)
+		}
+		if export.Kind != entry.Kind {
+			return fmt.Errorf("import %s.%s: a %s is imported but the library exports a %s", entry.ModuleStr, entry.FieldStr, entry.Kind, export.Kind)
+		}
+		typ, exist := l.libEntityType(export.Kind, export.Index)
+		if !exist {
+			return fmt.Errorf("export %q of the library: %s %d doesn't exist", export.FieldStr, export.Kind, export.Index)
+		}
+		if !l.matchImport(entry, typ) {
+			return fmt.Errorf("import %s.%s: the %s of the library has another type", entry.ModuleStr, entry.FieldStr, entry.Kind)
+		}
+
+		if l.resolved[entry.Kind] == nil {
+			l.resolved[entry.Kind] = map[uint32]uint32{}
+		}
+		l.resolved[entry.Kind][index] = export.Index
+	}
+	return nil
+}
+
+// matchImport reports whether an entity of a type may be imported by an import.
+func (l *linker) matchImport(entry ImportEntry, typ interface{}) bool {
+	switch entry.Kind {
+	case "function":
+		return l.sameSignature(uint32(entry.Type.(uint64)), uint32(typ.(uint64)))
+	case "table":
+		imported, table := entry.Type.(Table), typ.(Table)
+		return imported.ElementType == table.ElementType && matchLimits(imported.Limits, table.Limits)
+	case "memory":
+		return matchLimits(entry.Type.(MemLimits), typ.(MemLimits))
+	default:
+		return reflect.DeepEqual(entry.Type, typ)
+	}
+}
+
+// matchLimits reports whether the limits of an entity match the ones of an import, the address type and the
+// sharing must be the same.
+func matchLimits(imported, limits MemLimits) bool {
+	if imported.Is64() != limits.Is64() || imported.IsShared() != limits.IsShared() {
+		return false
+	}
+	if limits.Intial < imported.Intial {
+		return false
+	}
+	if imported.Maximum == nil {
+		return true
+	}
+	maximum, exist := limits.Maximum.(uint64)
+	return exist && maximum <= imported.Maximum.(uint64)
+}
+
+// mergeImports keeps the imports of the module that aren't resolved and adds the ones of the library the
+// module doesn't import yet.
+func (l *linker) mergeImports() error {
+	for _, entry := range NewIndexSpace(l.main).imports() {
+		if entry.ModuleStr != l.name {
+			l.imports = append(l.imports, entry)
+		}
+	}
+
+	for _, entry := range l.libSpace.imports() {
+		if entry.Kind == "function" {
+			typ, _ := l.typeMap.Map(uint32(entry.Type.(uint64)))
+			entry.Type = uint64(typ)
+		}
+		index := -1
+		kindIndex := 0
+		for _, imported := range l.imports {
+			if imported.Kind != entry.Kind {
+				continue
+			}
+			if imported.ModuleStr == entry.ModuleStr && imported.FieldStr == entry.FieldStr && l.sameImportType(imported, entry) {
+				index = kindIndex
+				break
+			}
+			kindIndex++
+		}
+		if index < 0 {
+			index = kindIndex
+			l.imports = append(l.imports, entry)
+		}
+		l.importMaps[entry.Kind] = append(l.importMaps[entry.Kind], index)
+	}
+	return nil
+}
+
+func (l *linker) sameImportType(a, b ImportEntry) bool {
+	if a.Kind == "function" {
+		return l.sameSignature(uint32(a.Type.(uint64)), uint32(b.Type.(uint64)))
+	}
+	return reflect.DeepEqual(a.Type, b.Type)
+}
+
+// mapIndices maps the index spaces of the module and of the library to the ones of the linked module: the
+// imports, the entities of the module and then the ones of the library.
+func (l *linker) mapIndices() error {
+	mainSpace := NewIndexSpace(l.main)
+	for _, kind := range linkKinds {
+		var imported uint32
+		for _, entry := range l.imports {
+			if entry.Kind == kind {
+				imported++
+			}
+		}
+		mainImported, mainDefined := mainSpace.Len(kind)
+		libImported, libDefined := l.libSpace.Len(kind)
+
+		libMap := make(IndexMap, 0, libImported+libDefined)
+		libMap = append(libMap, l.importMaps[kind]...)
+		for i := uint32(0); i < libDefined; i++ {
+			libMap = append(libMap, int(imported+mainDefined+i))
+		}
+
+		mainMap := make(IndexMap, 0, mainImported+mainDefined)
+		nameMap := make(IndexMap, 0, mainImported+mainDefined)
+		next := 0
+		for i := uint32(0); i < mainImported; i++ {
+			if target, exist := l.resolved[kind][i]; exist {
+				mainMap = append(mainMap, libMap[target])
+				nameMap = append(nameMap, -1)
+				continue
+			}
+			mainMap = append(mainMap, next)
+			nameMap = append(nameMap, next)
+			next++
+		}
+		for i := uint32(0); i < mainDefined; i++ {
+			mainMap = append(mainMap, int(imported+i))
+			nameMap = append(nameMap, int(imported+i))
+		}
+		l.mainMaps[kind], l.libMaps[kind], l.nameMaps[kind] = mainMap, libMap, nameMap
+	}
+	return nil
+}
+
+// checkConflicts checks that the module and the library don't both have their own memory or table.
+func (l *linker) checkConflicts() error {
+	for _, kind := range []string{"memory", "table"} {
+		mainOwn := -1
+		for i := range l.mainMaps[kind] {
+			if _, exist := l.resolved[kind][uint32(i)]; !exist {
+				mainOwn = i
+				break
+			}
+		}
+		if mainOwn < 0 {
+			continue
+		}
+
+		// the entities of the library the module imports or the library imports from the module's imports.
+		shared := map[int]struct{}{}
+		for _, target := range l.resolved[kind] {
+			shared[int(target)] = struct{}{}
+		}
+		mainImported, _ := NewIndexSpace(l.main).Len(kind)
+		for i, index := range l.importMaps[kind] {
+			for j := uint32(0); j < mainImported; j++ {
+				if l.mainMaps[kind][j] == index {
+					shared[i] = struct{}{}
+				}
+			}
+		}
+		for i := range l.libMaps[kind] {
+			if _, exist := shared[i]; !exist {
+				return fmt.Errorf("%s %d of the library clashes with %s %d of the module", kind, i, kind, mainOwn)
+			}
+		}
+	}
+	return nil
+}
+
+// renumber renumbers the references of the module and of the library to the entities of the linked module.
+func (l *linker) renumber() error {
+	mainSpace := NewIndexSpace(l.main)
+	for _, kind := range linkKinds {
+		mainSpace.walkRefs(kind, mapper(l.mainMaps[kind]), nil)
+		if err := mainSpace.remapNames(kind, l.nameMaps[kind]); err != nil {
+			return err
+		}
+		l.libSpace.walkRefs(kind, mapper(l.libMaps[kind]), nil)
+	}
+	l.libSpace.walkTypeRefs(mapper(l.typeMap), func(blockType string) string {
+		if index, exist := ParseTypeIndex(blockType); exist {
+			typ, _ := l.typeMap.Map(index)
+			return fmt.Sprintf("(type %d)", typ)
+		}
+		return blockType
+	})
+
+	// the segments of the library follow the ones of the module.
+	var datas, elems uint32
+	if section := mainSpace.section("data"); section != nil {
+		datas = uint32(entriesLen(section["entries"]))
+	}
+	if section := mainSpace.section("element"); section != nil {
+		elems = uint32(entriesLen(section["entries"]))
+	}
+	if section := l.libSpace.section("code"); section != nil {
+		entries, _ := section["entries"].([]CodeBody)
+		for _, entry := range entries {
+			for i := range entry.Code {
+				shiftSegments(&entry.Code[i], datas, elems)
+			}
+		}
+	}
+	return nil
+}
+
+func mapper(m IndexMap) func(index uint32) uint32 {
+	return func(index uint32) uint32 {
+		newIndex, _ := m.Map(index)
+		return newIndex
+	}
+}
+
+// shiftSegments shifts the references of an operator to the data and the element segments.
+func shiftSegments(op *OP, datas, elems uint32) {
+	imm, _ := op.Immediates.(JSON)
+	switch {
+	case op.ReturnType == "memory" && op.Name == "init":
+		imm["data"] = imm["data"].(uint32) + datas
+	case op.ReturnType == "data" && op.Name == "drop":
+		op.Immediates = op.Immediates.(uint32) + datas
+	case op.ReturnType == "table" && op.Name == "init":
+		imm["element"] = imm["element"].(uint32) + elems
+	case op.ReturnType == "elem" && op.Name == "drop":
+		op.Immediates = op.Immediates.(uint32) + elems
+	}
+}
+
+// merge appends the entities of the library to the ones of the module.
+func (l *linker) merge() ([]JSON, error) {
+	if len(l.imports) > 0 {
+		l.b.section("import")["entries"] = l.imports
+	} else if pos := findSectionByName(l.b.module, "import"); pos >= 0 {
+		l.b.module = append(l.b.module[:pos], l.b.module[pos+1:]...)
+	}
+
+	mainStart := findSectionByName(l.b.module, "start") >= 0
+	for _, section := range l.lib {
+		switch name := section["name"].(string); name {
+		case "function", "global", "table", "memory", "element", "data", "code":
+			newSection := l.b.section(name)
+			newSection["entries"] = appendEntries(newSection["entries"], section["entries"])
+		case "start":
+			if mainStart {
+				return nil, fmt.Errorf("link: both the module and the library have a start function")
+			}
+			l.b.section("start")["index"] = section["index"]
+		case "datacount":
+			l.b.section("datacount")
+		}
+	}
+	if pos := findSectionByName(l.b.module, "datacount"); pos >= 0 {
+		var count int
+		if pos := findSectionByName(l.b.module, "data"); pos >= 0 {
+			count = entriesLen(l.b.module[pos]["entries"])
+		}
+		l.b.module[pos]["count"] = uint32(count)
+	}
+	return l.b.Module(), nil
+}
+
+// appendEntries appends the entries of a section of the library to the ones of the module.
+func appendEntries(entries, libEntries interface{}) interface{} {
+	if entries == nil {
+		return libEntries
+	}
+	if libEntries == nil {
+		return entries
+	}
+	return reflect.AppendSlice(reflect.ValueOf(entries), reflect.ValueOf(libEntries)).Interface()
+}
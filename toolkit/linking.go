@@ -0,0 +1,403 @@
+package toolkit
+
+// https://github.com/WebAssembly/tool-conventions/blob/main/Linking.md
+// The relocatable modules (object files) have a "linking" custom section holding the symbol table and
+// "reloc.*" custom sections holding the relocations of the section they target.
+
+// Subsections of the "linking" section.
+const (
+	LINKING_SEGMENT_INFO = 5
+	LINKING_INIT_FUNCS   = 6
+	LINKING_COMDAT_INFO  = 7
+	LINKING_SYMBOL_TABLE = 8
+)
+
+// Flags of the symbols.
+const (
+	SYMBOL_BINDING_WEAK      = 0x01
+	SYMBOL_BINDING_LOCAL     = 0x02
+	SYMBOL_VISIBILITY_HIDDEN = 0x04
+	SYMBOL_UNDEFINED         = 0x10
+	SYMBOL_EXPORTED          = 0x20
+	SYMBOL_EXPLICIT_NAME     = 0x40
+	SYMBOL_NO_STRIP          = 0x80
+	SYMBOL_TLS               = 0x100
+	SYMBOL_ABSOLUTE          = 0x200
+)
+
+var (
+	// The kinds of the symbols, the kinds of the comdat entries use the same names.
+	W2J_SYMBOL_KINDS = map[byte]string{
+		0: "function",
+		1: "data",
+		2: "global",
+		3: "section",
+		4: "tag",
+		5: "table",
+	}
+
+	J2W_SYMBOL_KINDS = map[string]byte{
+		"function": 0,
+		"data":     1,
+		"global":   2,
+		"section":  3,
+		"tag":      4,
+		"table":    5,
+	}
+
+	W2J_COMDAT_KINDS = map[byte]string{
+		0: "data",
+		1: "function",
+		2: "global",
+		3: "tag",
+		4: "table",
+		5: "section",
+	}
+
+	J2W_COMDAT_KINDS = map[string]byte{
+		"data":     0,
+		"function": 1,
+		"global":   2,
+		"tag":      3,
+		"table":    4,
+		"section":  5,
+	}
+
+	W2J_RELOC_TYPES = map[byte]string{
+		0:  "R_WASM_FUNCTION_INDEX_LEB",
+		1:  "R_WASM_TABLE_INDEX_SLEB",
+		2:  "R_WASM_TABLE_INDEX_I32",
+		3:  "R_WASM_MEMORY_ADDR_LEB",
+		4:  "R_WASM_MEMORY_ADDR_SLEB",
+		5:  "R_WASM_MEMORY_ADDR_I32",
+		6:  "R_WASM_TYPE_INDEX_LEB",
+		7:  "R_WASM_GLOBAL_INDEX_LEB",
+		8:  "R_WASM_FUNCTION_OFFSET_I32",
+		9:  "R_WASM_SECTION_OFFSET_I32",
+		10: "R_WASM_TAG_INDEX_LEB",
+		11: "R_WASM_MEMORY_ADDR_REL_SLEB",
+		12: "R_WASM_TABLE_INDEX_REL_SLEB",
+		13: "R_WASM_GLOBAL_INDEX_I32",
+		14: "R_WASM_MEMORY_ADDR_LEB64",
+		15: "R_WASM_MEMORY_ADDR_SLEB64",
+		16: "R_WASM_MEMORY_ADDR_I64",
+		17: "R_WASM_MEMORY_ADDR_REL_SLEB64",
+		18: "R_WASM_TABLE_INDEX_SLEB64",
+		19: "R_WASM_TABLE_INDEX_I64",
+		20: "R_WASM_TABLE_NUMBER_LEB",
+		21: "R_WASM_MEMORY_ADDR_TLS_SLEB",
+		22: "R_WASM_FUNCTION_OFFSET_I64",
+		23: "R_WASM_MEMORY_ADDR_LOCREL_I32",
+		24: "R_WASM_TABLE_INDEX_REL_SLEB64",
+		25: "R_WASM_MEMORY_ADDR_TLS_SLEB64",
+		26: "R_WASM_FUNCTION_INDEX_I32",
+	}
+
+	J2W_RELOC_TYPES = map[string]byte{
+		"R_WASM_FUNCTION_INDEX_LEB":     0,
+		"R_WASM_TABLE_INDEX_SLEB":       1,
+		"R_WASM_TABLE_INDEX_I32":        2,
+		"R_WASM_MEMORY_ADDR_LEB":        3,
+		"R_WASM_MEMORY_ADDR_SLEB":       4,
+		"R_WASM_MEMORY_ADDR_I32":        5,
+		"R_WASM_TYPE_INDEX_LEB":         6,
+		"R_WASM_GLOBAL_INDEX_LEB":       7,
+		"R_WASM_FUNCTION_OFFSET_I32":    8,
+		"R_WASM_SECTION_OFFSET_I32":     9,
+		"R_WASM_TAG_INDEX_LEB":          10,
+		"R_WASM_MEMORY_ADDR_REL_SLEB":   11,
+		"R_WASM_TABLE_INDEX_REL_SLEB":   12,
+		"R_WASM_GLOBAL_INDEX_I32":       13,
+		"R_WASM_MEMORY_ADDR_LEB64":      14,
+		"R_WASM_MEMORY_ADDR_SLEB64":     15,
+		"R_WASM_MEMORY_ADDR_I64":        16,
+		"R_WASM_MEMORY_ADDR_REL_SLEB64": 17,
+		"R_WASM_TABLE_INDEX_SLEB64":     18,
+		"R_WASM_TABLE_INDEX_I64":        19,
+		"R_WASM_TABLE_NUMBER_LEB":       20,
+		"R_WASM_MEMORY_ADDR_TLS_SLEB":   21,
+		"R_WASM_FUNCTION_OFFSET_I64":    22,
+		"R_WASM_MEMORY_ADDR_LOCREL_I32": 23,
+		"R_WASM_TABLE_INDEX_REL_SLEB64": 24,
+		"R_WASM_MEMORY_ADDR_TLS_SLEB64": 25,
+		"R_WASM_FUNCTION_INDEX_I32":     26,
+	}
+
+	// the relocations which have an addend.
+	relocAddends = map[string]struct{}{
+		"R_WASM_MEMORY_ADDR_LEB":        {},
+		"R_WASM_MEMORY_ADDR_SLEB":       {},
+		"R_WASM_MEMORY_ADDR_I32":        {},
+		"R_WASM_FUNCTION_OFFSET_I32":    {},
+		"R_WASM_SECTION_OFFSET_I32":     {},
+		"R_WASM_MEMORY_ADDR_REL_SLEB":   {},
+		"R_WASM_MEMORY_ADDR_LEB64":      {},
+		"R_WASM_MEMORY_ADDR_SLEB64":     {},
+		"R_WASM_MEMORY_ADDR_I64":        {},
+		"R_WASM_MEMORY_ADDR_REL_SLEB64": {},
+		"R_WASM_MEMORY_ADDR_TLS_SLEB":   {},
+		"R_WASM_FUNCTION_OFFSET_I64":    {},
+		"R_WASM_MEMORY_ADDR_LOCREL_I32": {},
+		"R_WASM_MEMORY_ADDR_TLS_SLEB64": {},
+	}
+)
+
+// Symbol is an entry of the symbol table. The index is the one of the function, global, tag, table,
+// data segment or section it refers to, the offset and the size locate the data symbols in their segment.
+type Symbol struct {
+	Kind   string `json:"kind,omitempty"`
+	Flags  uint32 `json:"flags,omitempty"`
+	Index  uint32 `json:"index,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Offset uint64 `json:"offset,omitempty"`
+	Size   uint64 `json:"size,omitempty"`
+}
+
+// IsUndefined reports whether the symbol is imported.
+func (s Symbol) IsUndefined() bool {
+	return s.Flags&SYMBOL_UNDEFINED != 0
+}
+
+// hasName reports whether a function, global, tag or table symbol is encoded with its name,
+// the undefined ones take the name of their import otherwise.
+func (s Symbol) hasName() bool {
+	return !s.IsUndefined() || s.Flags&SYMBOL_EXPLICIT_NAME != 0
+}
+
+type ComdatEntry struct {
+	Kind  string `json:"kind,omitempty"`
+	Index uint32 `json:"index,omitempty"`
+}
+
+type Comdat struct {
+	Name    string        `json:"name,omitempty"`
+	Flags   uint32        `json:"flags,omitempty"`
+	Entries []ComdatEntry `json:"entries"`
+}
+
+// LinkingSubsection is a subsection of the "linking" section as it's encoded.
+type LinkingSubsection struct {
+	Type    byte   `json:"type,omitempty"`
+	Payload []byte `json:"payload"`
+}
+
+// Linking is the payload of the "linking" section. The subsections are kept in order, the symbol table
+// and the comdats are generated back from Symbols and Comdats.
+type Linking struct {
+	Version     uint32              `json:"version,omitempty"`
+	Subsections []LinkingSubsection `json:"subsections"`
+	Symbols     []Symbol            `json:"symbols"`
+	Comdats     []Comdat            `json:"comdats"`
+}
+
+// Relocation patches the number at an offset of the target section with the value of an index,
+// a symbol index for all the types but R_WASM_TYPE_INDEX_LEB.
+type Relocation struct {
+	Type   string `json:"type,omitempty"`
+	Offset uint32 `json:"offset,omitempty"`
+	Index  uint32 `json:"index,omitempty"`
+	Addend int64  `json:"addend,omitempty"`
+}
+
+// RelocSec is the payload of a "reloc.*" section, the section is the index of the target section.
+type RelocSec struct {
+	Section uint32       `json:"section,omitempty"`
+	Entries []Relocation `json:"entries"`
+}
+
+// RelocHasAddend reports whether a relocation type is encoded with an addend.
+func RelocHasAddend(typ string) bool {
+	_, exist := relocAddends[typ]
+	return exist
+}
+
+func readName(stream *Stream) string {
+	nameLen := DecodeULEB128(stream)
+	return string(stream.Read(int(nameLen)))
+}
+
+func writeName(name string, stream *Stream) {
+	EncodeULEB128(uint64(len(name)), stream)
+	stream.Write([]byte(name))
+}
+
+// ParseLinking parses the payload of a "linking" section.
+func ParseLinking(payload string) Linking {
+	stream := NewStream([]byte(payload))
+	linking := Linking{
+		Version:     uint32(DecodeULEB128(stream)),
+		Subsections: []LinkingSubsection{},
+		Symbols:     []Symbol{},
+		Comdats:     []Comdat{},
+	}
+
+	for stream.Len() > 0 {
+		typ := stream.ReadByte()
+		size := DecodeULEB128(stream)
+		sub := LinkingSubsection{
+			Type:    typ,
+			Payload: append([]byte{}, stream.Read(int(size))...),
+		}
+		linking.Subsections = append(linking.Subsections, sub)
+
+		switch typ {
+		case LINKING_SYMBOL_TABLE:
+			linking.Symbols = parseSymbols(NewStream(sub.Payload))
+		case LINKING_COMDAT_INFO:
+			linking.Comdats = parseComdats(NewStream(sub.Payload))
+		}
+	}
+
+	return linking
+}
+
+func parseSymbols(stream *Stream) []Symbol {
+	symbols := []Symbol{}
+	numberOfEntries := DecodeULEB128(stream)
+	for i := uint64(0); i < numberOfEntries; i++ {
+		sym := Symbol{
+			Kind:  W2J_SYMBOL_KINDS[stream.ReadByte()],
+			Flags: uint32(DecodeULEB128(stream)),
+		}
+		switch sym.Kind {
+		case "data":
+			sym.Name = readName(stream)
+			if !sym.IsUndefined() {
+				sym.Index = uint32(DecodeULEB128(stream))
+				sym.Offset = DecodeULEB128(stream)
+				sym.Size = DecodeULEB128(stream)
+			}
+		case "section":
+			sym.Index = uint32(DecodeULEB128(stream))
+		default:
+			sym.Index = uint32(DecodeULEB128(stream))
+			if sym.hasName() {
+				sym.Name = readName(stream)
+			}
+		}
+		symbols = append(symbols, sym)
+	}
+	return symbols
+}
+
+func parseComdats(stream *Stream) []Comdat {
+	comdats := []Comdat{}
+	numberOfEntries := DecodeULEB128(stream)
+	for i := uint64(0); i < numberOfEntries; i++ {
+		comdat := Comdat{
+			Name:    readName(stream),
+			Flags:   uint32(DecodeULEB128(stream)),
+			Entries: []ComdatEntry{},
+		}
+		numberOfSyms := DecodeULEB128(stream)
+		for j := uint64(0); j < numberOfSyms; j++ {
+			kind := W2J_COMDAT_KINDS[stream.ReadByte()]
+			comdat.Entries = append(comdat.Entries, ComdatEntry{
+				Kind:  kind,
+				Index: uint32(DecodeULEB128(stream)),
+			})
+		}
+		comdats = append(comdats, comdat)
+	}
+	return comdats
+}
+
+// GenerateLinking generates the payload of a "linking" section.
+func GenerateLinking(linking Linking) string {
+	stream := NewStream(nil)
+	EncodeULEB128(uint64(linking.Version), stream)
+
+	for _, sub := range linking.Subsections {
+		payload := NewStream(nil)
+		switch sub.Type {
+		case LINKING_SYMBOL_TABLE:
+			generateSymbols(linking.Symbols, payload)
+		case LINKING_COMDAT_INFO:
+			generateComdats(linking.Comdats, payload)
+		default:
+			payload.Write(sub.Payload)
+		}
+		stream.WriteByte(sub.Type)
+		EncodeULEB128(uint64(payload.bytesWrote), stream)
+		stream.Write(payload.Bytes())
+	}
+
+	return stream.String()
+}
+
+func generateSymbols(symbols []Symbol, stream *Stream) {
+	EncodeULEB128(uint64(len(symbols)), stream)
+	for _, sym := range symbols {
+		stream.WriteByte(J2W_SYMBOL_KINDS[sym.Kind])
+		EncodeULEB128(uint64(sym.Flags), stream)
+		switch sym.Kind {
+		case "data":
+			writeName(sym.Name, stream)
+			if !sym.IsUndefined() {
+				EncodeULEB128(uint64(sym.Index), stream)
+				EncodeULEB128(sym.Offset, stream)
+				EncodeULEB128(sym.Size, stream)
+			}
+		case "section":
+			EncodeULEB128(uint64(sym.Index), stream)
+		default:
+			EncodeULEB128(uint64(sym.Index), stream)
+			if sym.hasName() {
+				writeName(sym.Name, stream)
+			}
+		}
+	}
+}
+
+func generateComdats(comdats []Comdat, stream *Stream) {
+	EncodeULEB128(uint64(len(comdats)), stream)
+	for _, comdat := range comdats {
+		writeName(comdat.Name, stream)
+		EncodeULEB128(uint64(comdat.Flags), stream)
+		EncodeULEB128(uint64(len(comdat.Entries)), stream)
+		for _, entry := range comdat.Entries {
+			stream.WriteByte(J2W_COMDAT_KINDS[entry.Kind])
+			EncodeULEB128(uint64(entry.Index), stream)
+		}
+	}
+}
+
+// ParseReloc parses the payload of a "reloc.*" section.
+func ParseReloc(payload string) RelocSec {
+	stream := NewStream([]byte(payload))
+	sec := RelocSec{
+		Section: uint32(DecodeULEB128(stream)),
+		Entries: []Relocation{},
+	}
+
+	numberOfEntries := DecodeULEB128(stream)
+	for i := uint64(0); i < numberOfEntries; i++ {
+		entry := Relocation{
+			Type:   W2J_RELOC_TYPES[stream.ReadByte()],
+			Offset: uint32(DecodeULEB128(stream)),
+			Index:  uint32(DecodeULEB128(stream)),
+		}
+		if RelocHasAddend(entry.Type) {
+			entry.Addend = DecodeSLEB128(stream)
+		}
+		sec.Entries = append(sec.Entries, entry)
+	}
+
+	return sec
+}
+
+// GenerateReloc generates the payload of a "reloc.*" section.
+func GenerateReloc(sec RelocSec) string {
+	stream := NewStream(nil)
+	EncodeULEB128(uint64(sec.Section), stream)
+	EncodeULEB128(uint64(len(sec.Entries)), stream)
+	for _, entry := range sec.Entries {
+		stream.WriteByte(J2W_RELOC_TYPES[entry.Type])
+		EncodeULEB128(uint64(entry.Offset), stream)
+		EncodeULEB128(uint64(entry.Index), stream)
+		if RelocHasAddend(entry.Type) {
+			EncodeSLEB128(entry.Addend, stream)
+		}
+	}
+	return stream.String()
+}
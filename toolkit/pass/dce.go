@@ -0,0 +1,161 @@
+package pass
+
+import (
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+	"github.com/yyh1102/go-wasm-metering/toolkit/callgraph"
+)
+
+// DeadCode is the pass that removes the functions, the globals and the types a module doesn't use, imported or
+// defined. The functions are live if they are reachable from the exports, the start function or the element
+// segments, the globals if they are exported or used by the live code and the segments. The types are kept
+// if they aren't only function signatures (see toolkit.IndexSpace.RemapTypes). The object files are left as
+// they are, their linking sections would have to be rewritten.
+type DeadCode struct {
+	// the indices of the removed entities, before their removal.
+	Functions []uint32
+	Globals   []uint32
+	Types     []uint32
+}
+
+func (d *DeadCode) Name() string {
+	return "dead-code"
+}
+
+func (d *DeadCode) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	d.Functions, d.Globals, d.Types = nil, nil, nil
+	if toolkit.IsRelocatable(module) {
+		return module, nil
+	}
+
+	// the live functions.
+	g, err := callgraph.New(module)
+	if err != nil {
+		return nil, err
+	}
+	var roots []uint32
+	for _, f := range g.Funcs {
+		if len(f.Exports) > 0 || f.Escaping {
+			roots = append(roots, f.Index)
+		}
+	}
+	if g.Start != nil {
+		roots = append(roots, *g.Start)
+	}
+	live := map[uint32]struct{}{}
+	for _, f := range g.Reachable(roots...) {
+		live[f] = struct{}{}
+	}
+	for _, f := range g.Funcs {
+		if _, exist := live[f.Index]; !exist {
+			d.Functions = append(d.Functions, f.Index)
+		}
+	}
+
+	space := toolkit.NewIndexSpace(module)
+	if len(d.Functions) > 0 {
+		if _, err := space.Remove("function", d.Functions...); err != nil {
+			return nil, err
+		}
+	}
+
+	d.Globals = deadGlobals(space.Module())
+	if len(d.Globals) > 0 {
+		if _, err := space.Remove("global", d.Globals...); err != nil {
+			return nil, err
+		}
+	}
+
+	if space.CanRemapTypes() {
+		m, err := space.RemoveUnusedTypes()
+		if err != nil {
+			return nil, err
+		}
+		for i, index := range m {
+			if index < 0 {
+				d.Types = append(d.Types, uint32(i))
+			}
+		}
+	}
+	return space.Module(), nil
+}
+
+// deadGlobals returns the globals that aren't exported nor used by the code, the segments and the live globals.
+func deadGlobals(module []toolkit.JSON) []uint32 {
+	var (
+		live    = map[uint32]struct{}{}
+		inits   [][]toolkit.OP
+		globals uint32
+		queue   []uint32
+	)
+	use := func(ops []toolkit.OP) {
+		for _, op := range ops {
+			if op.Name == "get_global" || op.Name == "set_global" {
+				index := op.Immediates.(uint32)
+				if _, exist := live[index]; !exist {
+					live[index] = struct{}{}
+					queue = append(queue, index)
+				}
+			}
+		}
+	}
+	for _, section := range module {
+		switch section["name"] {
+		case "import":
+			entries, _ := section["entries"].([]toolkit.ImportEntry)
+			for _, entry := range entries {
+				if entry.Kind == "global" {
+					inits = append(inits, nil)
+					globals++
+				}
+			}
+		case "global":
+			entries, _ := section["entries"].([]toolkit.GlobalEntry)
+			for _, entry := range entries {
+				inits = append(inits, entry.Init)
+				globals++
+			}
+		case "export":
+			entries, _ := section["entries"].([]toolkit.ExportEntry)
+			for _, entry := range entries {
+				if entry.Kind == "global" {
+					use([]toolkit.OP{{Name: "get_global", Immediates: entry.Index}})
+				}
+			}
+		case "element":
+			entries, _ := section["entries"].([]toolkit.ElementEntry)
+			for _, entry := range entries {
+				use(entry.Offset)
+				for _, expr := range entry.Exprs {
+					use(expr)
+				}
+			}
+		case "data":
+			entries, _ := section["entries"].([]toolkit.DataSegment)
+			for _, entry := range entries {
+				use(entry.Offset)
+			}
+		case "code":
+			entries, _ := section["entries"].([]toolkit.CodeBody)
+			for _, entry := range entries {
+				use(entry.Code)
+			}
+		}
+	}
+
+	// the initializers of the live globals use other globals.
+	for len(queue) > 0 {
+		index := queue[0]
+		queue = queue[1:]
+		if index < uint32(len(inits)) {
+			use(inits[index])
+		}
+	}
+
+	var dead []uint32
+	for i := uint32(0); i < globals; i++ {
+		if _, exist := live[i]; !exist {
+			dead = append(dead, i)
+		}
+	}
+	return dead
+}
@@ -0,0 +1,39 @@
+package pass
+
+import "github.com/yyh1102/go-wasm-metering/toolkit"
+
+// DedupTypes is the pass that merges the identical function types of a module, the functions, the imports,
+// the block types and the indirect calls refer to the first one of them. The modules whose types aren't only
+// function signatures are left as they are (see toolkit.IndexSpace.RemapTypes), like the object files.
+type DedupTypes struct {
+	// the indices of the merged types, before their removal.
+	Merged []uint32
+}
+
+func (d *DedupTypes) Name() string {
+	return "dedup-types"
+}
+
+func (d *DedupTypes) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	d.Merged = nil
+	if toolkit.IsRelocatable(module) {
+		return module, nil
+	}
+	space := toolkit.NewIndexSpace(module)
+	if !space.CanRemapTypes() {
+		return module, nil
+	}
+	m, err := space.DedupTypes()
+	if err != nil {
+		return nil, err
+	}
+	next := 0
+	for i, index := range m {
+		if index < next {
+			d.Merged = append(d.Merged, uint32(i))
+		} else {
+			next++
+		}
+	}
+	return space.Module(), nil
+}
@@ -0,0 +1,260 @@
+// Package pass transforms the modules decoded by toolkit.Wasm2Json with chains of passes, the passes that
+// rewrite the function bodies are visitors of their operators.
+package pass
+
+import (
+	"fmt"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// Pass transforms a decoded module, the sections may be modified in place.
+type Pass interface {
+	Name() string
+	Run(module []toolkit.JSON) ([]toolkit.JSON, error)
+}
+
+// Manager runs a chain of passes over a module, each pass transforms the output of the previous one.
+type Manager struct {
+	passes []Pass
+}
+
+func NewManager(passes ...Pass) *Manager {
+	return &Manager{passes: passes}
+}
+
+// Add appends a pass to the chain.
+func (m *Manager) Add(p Pass) *Manager {
+	m.passes = append(m.passes, p)
+	return m
+}
+
+// Passes returns the chain of passes.
+func (m *Manager) Passes() []Pass {
+	return m.passes
+}
+
+// Run runs the passes in order, it stops at the first one that fails and returns its error as is.
+func (m *Manager) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	for _, p := range m.passes {
+		var err error
+		module, err = p.Run(module)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return module, nil
+}
+
+// Func is the function whose body is visited.
+type Func struct {
+	Module []toolkit.JSON
+	Pos    int                // the position of the function in the code section.
+	Index  uint32             // the index of the function, after the imported ones.
+	Type   *toolkit.TypeEntry // nil if the module has no type for the function.
+	Body   toolkit.CodeBody   // the body before it's rewritten.
+}
+
+// Visitor rewrites the operators of the function bodies.
+type Visitor interface {
+	// EnterFunc is called before the operators of a function are visited.
+	EnterFunc(f *Func) error
+	// Visit is called on every operator of the body, in order, the cursor edits the body around it.
+	Visit(c *Cursor) error
+}
+
+// Cursor is the position of the visit in a function body. The edits are applied once the operator is
+// visited, the operators that are inserted or replace it aren't visited.
+type Cursor struct {
+	Func   *Func
+	Index  int      // the index of the operator in the body before it's rewritten.
+	Blocks []string // the kinds of the blocks open before the operator (i.e. "loop"), the innermost last.
+
+	op       toolkit.OP
+	before   []toolkit.OP
+	after    []toolkit.OP
+	replaced bool
+	replace  []toolkit.OP
+}
+
+// Op returns the visited operator.
+func (c *Cursor) Op() toolkit.OP {
+	return c.op
+}
+
+// Depth returns the number of blocks the operator is in, the body excepted.
+func (c *Cursor) Depth() int {
+	return len(c.Blocks)
+}
+
+// InsertBefore inserts operators before the visited one, after the ones inserted before.
+func (c *Cursor) InsertBefore(ops ...toolkit.OP) {
+	c.before = append(c.before, ops...)
+}
+
+// InsertAfter inserts operators after the visited one, after the ones inserted before.
+func (c *Cursor) InsertAfter(ops ...toolkit.OP) {
+	c.after = append(c.after, ops...)
+}
+
+// Replace replaces the visited operator, the first operator of the replacement takes its place in the
+// operator map (see Rewrite).
+func (c *Cursor) Replace(ops ...toolkit.OP) {
+	c.replaced = true
+	c.replace = append([]toolkit.OP{}, ops...)
+}
+
+// Remove removes the visited operator.
+func (c *Cursor) Remove() {
+	c.Replace()
+}
+
+func countImportedFuncs(module []toolkit.JSON) uint32 {
+	count := uint32(0)
+	for _, section := range module {
+		if section["name"] != "import" {
+			continue
+		}
+		entries, _ := section["entries"].([]toolkit.ImportEntry)
+		for _, entry := range entries {
+			if entry.Kind == "function" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func findSection(module []toolkit.JSON, name string) toolkit.JSON {
+	for _, section := range module {
+		if section["name"] == name {
+			return section
+		}
+	}
+	return nil
+}
+
+// Rewrite visits the function bodies of a module and rewrites them in place. It returns the index of every
+// operator of every body in the rewritten one, -1 if it's removed (see toolkit.CodeMap).
+func Rewrite(module []toolkit.JSON, v Visitor) ([][]int, error) {
+	code := findSection(module, "code")
+	if code == nil {
+		return nil, nil
+	}
+	var (
+		entries, _  = code["entries"].([]toolkit.CodeBody)
+		funcs       []uint64
+		types       []toolkit.TypeEntry
+		importFuncs = countImportedFuncs(module)
+		opMaps      = make([][]int, 0, len(entries))
+	)
+	if section := findSection(module, "function"); section != nil {
+		funcs, _ = section["entries"].([]uint64)
+	}
+	if section := findSection(module, "type"); section != nil {
+		types, _ = section["entries"].([]toolkit.TypeEntry)
+	}
+
+	for i, entry := range entries {
+		f := &Func{
+			Module: module,
+			Pos:    i,
+			Index:  importFuncs + uint32(i),
+			Body:   entry,
+		}
+		if i < len(funcs) && funcs[i] < uint64(len(types)) {
+			f.Type = &types[funcs[i]]
+		}
+		body, opMap, err := rewriteFunc(f, v)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %v", f.Index, err)
+		}
+		entries[i] = body
+		opMaps = append(opMaps, opMap)
+	}
+	return opMaps, nil
+}
+
+func rewriteFunc(f *Func, v Visitor) (toolkit.CodeBody, []int, error) {
+	if err := v.EnterFunc(f); err != nil {
+		return f.Body, nil, err
+	}
+	var (
+		body   = f.Body
+		code   = make([]toolkit.OP, 0, len(body.Code))
+		opMap  = make([]int, 0, len(body.Code))
+		blocks []string
+	)
+	for i, op := range body.Code {
+		c := &Cursor{
+			Func:   f,
+			Index:  i,
+			Blocks: append([]string{}, blocks...),
+			op:     op,
+		}
+		if err := v.Visit(c); err != nil {
+			return body, nil, fmt.Errorf("op %d (%s): %v", i, op.Name, err)
+		}
+
+		code = append(code, c.before...)
+		if c.replaced {
+			if len(c.replace) > 0 {
+				opMap = append(opMap, len(code))
+			} else {
+				opMap = append(opMap, -1)
+			}
+			code = append(code, c.replace...)
+		} else {
+			opMap = append(opMap, len(code))
+			code = append(code, op)
+		}
+		code = append(code, c.after...)
+
+		blocks = nestBlocks(blocks, op)
+	}
+	body.Code = code
+	return body, opMap, nil
+}
+
+// nestBlocks returns the blocks open after an operator.
+func nestBlocks(blocks []string, op toolkit.OP) []string {
+	switch op.Name {
+	case "block", "loop", "if", "try", "try_table":
+		return append(blocks, op.Name)
+	case "else":
+		if len(blocks) > 0 {
+			blocks[len(blocks)-1] = "else"
+		}
+	case "catch", "catch_all":
+		if len(blocks) > 0 {
+			blocks[len(blocks)-1] = op.Name
+		}
+	case "end", "delegate":
+		if len(blocks) > 0 {
+			return blocks[:len(blocks)-1]
+		}
+	}
+	return blocks
+}
+
+// funcPass is a pass that rewrites the function bodies with a visitor.
+type funcPass struct {
+	name    string
+	visitor Visitor
+}
+
+// Functions returns a pass that rewrites the function bodies of a module with a visitor.
+func Functions(name string, v Visitor) Pass {
+	return &funcPass{name: name, visitor: v}
+}
+
+func (p *funcPass) Name() string {
+	return p.name
+}
+
+func (p *funcPass) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	if _, err := Rewrite(module, p.visitor); err != nil {
+		return nil, err
+	}
+	return module, nil
+}
@@ -0,0 +1,270 @@
+package pass
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// tracer counts the calls of every function and drops the nops.
+type tracer struct {
+	counter uint32
+	depths  []int
+}
+
+func (t *tracer) EnterFunc(f *Func) error {
+	return nil
+}
+
+func (t *tracer) Visit(c *Cursor) error {
+	t.depths = append(t.depths, c.Depth())
+	switch c.Op().Name {
+	case "nop":
+		c.Remove()
+	case "call":
+		e := toolkit.NewEmitter().GetGlobal(t.counter).I32Const(1).Instr("i32", "add").SetGlobal(t.counter)
+		c.InsertBefore(e.Ops()...)
+	case "drop":
+		c.Replace(toolkit.OP{Name: "drop"}, toolkit.OP{Name: "nop"})
+	}
+	return nil
+}
+
+func TestRewrite(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	typ := b.FuncType(nil, "")
+	callee, err := b.ImportFunction("env", "callee", typ)
+	assert.Nil(t, err)
+	counter := b.AddGlobal(toolkit.Global{ContentType: "i32", Mutability: 1}, toolkit.NewEmitter().I32Const(0).Ops())
+	code, err := toolkit.NewEmitter().
+		Nop().
+		Loop(toolkit.BLOCK_TYPE_EMPTY, func(e *toolkit.Emitter) {
+			e.Call(callee).I32Const(0).Drop()
+		}).
+		Code()
+	assert.Nil(t, err)
+	b.AddFunction(typ, nil, code)
+
+	v := &tracer{counter: counter}
+	module, err := NewManager(Functions("trace", v)).Run(b.Module())
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 0, 1, 1, 1, 1, 0}, v.depths)
+
+	// nop (removed) loop [global.get i32.const i32.add global.set] call i32.const drop nop end end
+	entries := module[len(module)-1]["entries"].([]toolkit.CodeBody)
+	assert.Len(t, entries[0].Code, 11)
+	assert.Equal(t, "call", entries[0].Code[5].Name)
+	assert.Nil(t, toolkit.ValidateModule(module))
+
+	// the operators keep their order, the removed nop has no index.
+	opMaps, err := Rewrite(module, &tracer{counter: counter})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 9, 10, 11, -1, 13, 14}, opMaps[0])
+}
+
+func TestDeadCode(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	unary := b.FuncType([]string{"i32"}, "")
+	wide := b.FuncType([]string{"i64"}, "")
+	used, err := b.ImportFunction("env", "used", void)
+	assert.Nil(t, err)
+	_, err = b.ImportFunction("env", "unused", unary)
+	assert.Nil(t, err)
+	imported, err := b.ImportGlobal("env", "g", toolkit.Global{ContentType: "i32"})
+	assert.Nil(t, err)
+	live := b.AddGlobal(toolkit.Global{ContentType: "i32", Mutability: 1}, toolkit.NewEmitter().GetGlobal(imported).Ops())
+	b.AddGlobal(toolkit.Global{ContentType: "i32"}, toolkit.NewEmitter().I32Const(0).Ops())
+
+	code, _ := toolkit.NewEmitter().Call(used).GetGlobal(live).Drop().Code()
+	main := b.AddFunction(void, nil, code)
+	// 3 and 4 call each other but nothing calls them.
+	code, _ = toolkit.NewEmitter().I32Const(0).Call(1).Call(4).Code()
+	b.AddFunction(wide, nil, code)
+	code, _ = toolkit.NewEmitter().I64Const(0).Call(3).Code()
+	b.AddFunction(void, nil, code)
+	assert.Nil(t, b.AddExport("main", "function", main))
+
+	d := &DeadCode{}
+	module, err := NewManager(d).Run(b.Module())
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{1, 3, 4}, d.Functions)
+	assert.Equal(t, []uint32{2}, d.Globals)
+	assert.Equal(t, []uint32{1, 2}, d.Types)
+	assert.Nil(t, toolkit.ValidateModule(module))
+
+	space := toolkit.NewIndexSpace(module)
+	imports, defined := space.Len("function")
+	assert.Equal(t, []uint32{1, 1}, []uint32{imports, defined})
+	imports, defined = space.Len("global")
+	assert.Equal(t, []uint32{1, 1}, []uint32{imports, defined})
+}
+
+func TestDedupTypes(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	unary := b.FuncType([]string{"i32"}, "")
+	void := b.FuncType(nil, "")
+	// the builder interns the types, the duplicates are added by hand.
+	section := b.Module()[1]
+	section["entries"] = append(section["entries"].([]toolkit.TypeEntry),
+		toolkit.TypeEntry{Form: "func", Params: []string{}},
+		toolkit.TypeEntry{Form: "func", Params: []string{"i32"}})
+	imported, err := b.ImportFunction("env", "f", 3)
+	assert.Nil(t, err)
+	code, _ := toolkit.NewEmitter().
+		Block("(type 2)", func(e *toolkit.Emitter) {
+			e.I32Const(0).Call(imported)
+		}).
+		I32Const(0).
+		I32Const(0).
+		CallIndirect(3, 0).
+		Code()
+	f := b.AddFunction(2, nil, code)
+	assert.Nil(t, b.AddExport("f", "function", f))
+	space := toolkit.NewIndexSpace(b.Module())
+	_, err = space.Insert("table", 0, toolkit.Table{ElementType: "anyFunc", Limits: toolkit.MemLimits{Intial: 1}})
+	assert.Nil(t, err)
+	assert.Nil(t, toolkit.ValidateModule(space.Module()))
+
+	d := &DedupTypes{}
+	module, err := NewManager(d).Run(space.Module())
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{2, 3}, d.Merged)
+	assert.Nil(t, toolkit.ValidateModule(module))
+
+	for _, section := range module {
+		switch section["name"] {
+		case "type":
+			assert.Len(t, section["entries"], 2)
+		case "import":
+			assert.Equal(t, uint64(unary), section["entries"].([]toolkit.ImportEntry)[0].Type)
+		case "function":
+			assert.Equal(t, []uint64{uint64(void)}, section["entries"])
+		case "code":
+			ops := section["entries"].([]toolkit.CodeBody)[0].Code
+			assert.Equal(t, "(type 1)", ops[0].Immediates)
+			assert.Equal(t, uint64(unary), ops[6].Immediates.(toolkit.JSON)["index"])
+		}
+	}
+
+	// the metering type is interned too.
+	module, index := toolkit.InternType(module, toolkit.TypeEntry{Form: "func", Params: []string{"i32"}})
+	assert.Equal(t, unary, index)
+	_, index = toolkit.InternType(module, toolkit.TypeEntry{Form: "func", Params: []string{"i64"}})
+	assert.Equal(t, uint32(2), index)
+}
+
+func TestStrip(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	code, _ := toolkit.NewEmitter().Code()
+	f := b.AddFunction(b.FuncType(nil, ""), nil, code)
+	assert.Nil(t, b.AddExport("f", "function", f))
+	module := append(b.Module(), toolkit.JSON{"name": "custom", "section_name": "name", "payload": ""})
+
+	s := &Strip{Sections: []string{"name"}, Exports: []string{}}
+	stripped, err := NewManager(s).Run(module)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name"}, s.StrippedSections)
+	assert.Equal(t, []string{"f"}, s.StrippedExports)
+	assert.Equal(t, len(toolkit.Json2Wasm(module))-len(toolkit.Json2Wasm(stripped)), s.Saved)
+	for _, section := range stripped {
+		assert.NotEqual(t, "export", section["name"])
+	}
+	assert.Nil(t, toolkit.ValidateModule(stripped))
+
+	// the exports are kept without an allowlist.
+	s = &Strip{Sections: []string{"[name"}}
+	_, err = s.Run(module)
+	assert.NotNil(t, err)
+	s.Sections = nil
+	stripped, err = s.Run(module)
+	assert.Nil(t, err)
+	assert.Equal(t, module, stripped)
+	assert.Zero(t, s.Saved)
+}
+
+func TestRename(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	unary := b.FuncType([]string{"i32"}, "")
+	wide := b.FuncType([]string{"i64"}, "")
+	_, err := b.ImportFunction("env", "useGas", wide)
+	assert.Nil(t, err)
+	_, err = b.ImportFunction("env", "print", unary)
+	assert.Nil(t, err)
+	_, err = b.ImportFunction("debug", "print", unary)
+	assert.Nil(t, err)
+	code, _ := toolkit.NewEmitter().Code()
+	main := b.AddFunction(b.FuncType(nil, ""), nil, code)
+	assert.Nil(t, b.AddExport("main", "function", main))
+	assert.Nil(t, b.AddExport("internal", "function", main))
+
+	rules := make([]RenameRule, 3)
+	for i, rule := range []string{"env.useGas=metering.usegas", "debug.*=ethereum.*", "env.*=ethereum.*"} {
+		rules[i], err = ParseRenameRule(rule)
+		assert.Nil(t, err)
+	}
+	r := &Rename{
+		Imports: rules,
+		Exports: []RenameRule{{From: "internal", To: "_internal"}},
+		Aliases: []RenameRule{{From: "main", To: "_start"}},
+	}
+	module, err := NewManager(r).Run(b.Module())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, r.Renamed)
+	assert.Nil(t, toolkit.ValidateModule(module))
+	var imports []string
+	for _, entry := range module[2]["entries"].([]toolkit.ImportEntry) {
+		imports = append(imports, entry.ModuleStr+"."+entry.FieldStr)
+	}
+	assert.Equal(t, []string{"metering.usegas", "ethereum.print", "ethereum.print"}, imports)
+	assert.Equal(t, []toolkit.ExportEntry{
+		{FieldStr: "main", Kind: "function", Index: main},
+		{FieldStr: "_internal", Kind: "function", Index: main},
+		{FieldStr: "_start", Kind: "function", Index: main},
+	}, module[4]["entries"])
+
+	// an import can't be renamed like another one of another type.
+	_, err = (&Rename{Imports: []RenameRule{{From: "metering.usegas", To: "ethereum.print"}}}).Run(module)
+	assert.EqualError(t, err, "import ethereum.print: conflicting signatures")
+	_, err = (&Rename{Aliases: []RenameRule{{From: "main", To: "_internal"}}}).Run(module)
+	assert.EqualError(t, err, `duplicate export "_internal"`)
+	_, err = ParseRenameRule("env.print")
+	assert.NotNil(t, err)
+
+	// the `*` of the new name are the text the ones of the old name matched.
+	b = toolkit.NewBuilder(nil)
+	unary = b.FuncType([]string{"i32"}, "")
+	_, err = b.ImportFunction("env", "get_balance", unary)
+	assert.Nil(t, err)
+	_, err = b.ImportFunction("env", "set_a_b", unary)
+	assert.Nil(t, err)
+	rule, err := ParseRenameRule("env.get_*=eth.eth_get_*")
+	assert.Nil(t, err)
+	module, err = (&Rename{
+		Imports: []RenameRule{rule, {From: "env.set_*_*", To: "eth.*_*_set"}},
+	}).Run(b.Module())
+	assert.Nil(t, err)
+	imports = nil
+	for _, entry := range module[2]["entries"].([]toolkit.ImportEntry) {
+		imports = append(imports, entry.ModuleStr+"."+entry.FieldStr)
+	}
+	assert.Equal(t, []string{"eth.eth_get_balance", "eth.a_b_set"}, imports)
+	assert.Equal(t, "_start", RenameRule{From: "[a-z]ain", To: "_start"}.mustRename(t, "main"))
+	assert.Equal(t, "x.b", RenameRule{From: "?.*", To: "x.*"}.mustRename(t, "a.b"))
+
+	_, err = ParseRenameRule("get_*=eth_get_?")
+	assert.NotNil(t, err)
+	_, err = ParseRenameRule("get=get_*")
+	assert.NotNil(t, err)
+	_, err = (&Rename{Imports: []RenameRule{{From: "env.get", To: "eth.get_*"}}}).Run(b.Module())
+	assert.NotNil(t, err)
+	_, err = (&Rename{Exports: []RenameRule{{From: "get", To: "[get]"}}}).Run(b.Module())
+	assert.NotNil(t, err)
+}
+
+func (r RenameRule) mustRename(t *testing.T, name string) string {
+	renamed, matched := r.rename(name)
+	assert.True(t, matched)
+	return renamed
+}
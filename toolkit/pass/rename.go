@@ -0,0 +1,261 @@
+package pass
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// RenameRule is a renaming rule, the names matching From (see path.Match) are replaced by To. The `*` of To
+// are replaced in order by the text the ones of From matched, To has no other wildcard, i.e. `get_*` to
+// `eth_get_*` renames get_balance to eth_get_balance. A To of `*` alone keeps the whole name. The names of
+// the imports are `module.field`, the module and the field are matched and replaced separately, i.e. `env.*`
+// to `ethereum.*` moves the imports of "env" to "ethereum".
+type RenameRule struct {
+	From string
+	To   string
+}
+
+// ParseRenameRule parses a rule written `from=to`, i.e. `env.*=ethereum.*`.
+func ParseRenameRule(rule string) (RenameRule, error) {
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return RenameRule{}, fmt.Errorf("rename %q: the rule isn't from=to", rule)
+	}
+	r := RenameRule{From: strings.TrimSpace(parts[0]), To: strings.TrimSpace(parts[1])}
+	if err := r.check(); err != nil {
+		return RenameRule{}, fmt.Errorf("rename %q: %v", rule, err)
+	}
+	return r, nil
+}
+
+// check checks the pattern of From and that To only has as many `*` as From.
+func (r RenameRule) check() error {
+	if _, err := path.Match(r.From, ""); err != nil {
+		return err
+	}
+	if r.To == "*" {
+		return nil
+	}
+	if strings.ContainsAny(r.To, "?[\\") {
+		return fmt.Errorf("%s: the wildcard of the new name is `*`", r.To)
+	}
+	if stars := strings.Count(r.To, "*"); stars > globRegexp(r.From).NumSubexp() {
+		return fmt.Errorf("%s: %d `*` but %s has less", r.To, stars, r.From)
+	}
+	return nil
+}
+
+// rename returns the new name of a name, it's false if the rule doesn't match it.
+func (r RenameRule) rename(name string) (string, bool) {
+	if matched, _ := path.Match(r.From, name); !matched {
+		return name, false
+	}
+	if r.To == "*" {
+		return name, true
+	}
+	parts := strings.Split(r.To, "*")
+	if len(parts) == 1 {
+		return r.To, true
+	}
+	matches := globRegexp(r.From).FindStringSubmatch(name)
+	renamed := parts[0]
+	for i, part := range parts[1:] {
+		renamed += matches[i+1] + part
+	}
+	return renamed, true
+}
+
+// globRegexp converts a pattern of path.Match to a regular expression, the text every `*` matches is a group.
+// The `*` match as little as path.Match does.
+func globRegexp(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString("([^/]*?)")
+		case '?':
+			expr.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '[':
+			expr.WriteString("[")
+			if i+1 < len(pattern) && pattern[i+1] == '^' {
+				expr.WriteString("^")
+				i++
+			}
+			for i++; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' && i+1 < len(pattern) {
+					i++
+				}
+				if pattern[i] == '-' {
+					expr.WriteString("-")
+				} else {
+					expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+				}
+			}
+			expr.WriteString("]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+// Rename is the pass that renames the imports and the exports of a module, i.e. to move its imports to the
+// namespace of another runtime. The first rule matching a name renames it. The imports renamed like another
+// import of the module must have its kind and its type, the exports must keep unique names.
+type Rename struct {
+	Imports []RenameRule
+	Exports []RenameRule
+	// the exports to export under another name too, From is the name of an export.
+	Aliases []RenameRule
+
+	// the number of renamed imports and exports and of added aliases.
+	Renamed int
+}
+
+func (r *Rename) Name() string {
+	return "rename"
+}
+
+func (r *Rename) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	r.Renamed = 0
+	for _, rule := range r.Imports {
+		if !strings.Contains(rule.From, ".") || !strings.Contains(rule.To, ".") {
+			return nil, fmt.Errorf("rename %s=%s: the imports are renamed module.field", rule.From, rule.To)
+		}
+		from, to := splitImportName(rule.From), splitImportName(rule.To)
+		for i := range from {
+			if err := (RenameRule{From: from[i], To: to[i]}).check(); err != nil {
+				return nil, fmt.Errorf("rename %s=%s: %v", rule.From, rule.To, err)
+			}
+		}
+	}
+	for _, rules := range [][]RenameRule{r.Exports, r.Aliases} {
+		for _, rule := range rules {
+			if err := rule.check(); err != nil {
+				return nil, fmt.Errorf("rename %s=%s: %v", rule.From, rule.To, err)
+			}
+		}
+	}
+
+	var types []toolkit.TypeEntry
+	for _, section := range module {
+		if section["name"] == "type" {
+			types, _ = section["entries"].([]toolkit.TypeEntry)
+		}
+	}
+	for _, section := range module {
+		var err error
+		switch section["name"] {
+		case "import":
+			err = r.renameImports(section, types)
+		case "export":
+			err = r.renameExports(section)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return module, nil
+}
+
+func (r *Rename) renameImports(section toolkit.JSON, types []toolkit.TypeEntry) error {
+	entries, _ := section["entries"].([]toolkit.ImportEntry)
+	renamed := make([]bool, len(entries))
+	for i, entry := range entries {
+		for _, rule := range r.Imports {
+			from, to := splitImportName(rule.From), splitImportName(rule.To)
+			module, moduleMatched := RenameRule{From: from[0], To: to[0]}.rename(entry.ModuleStr)
+			field, fieldMatched := RenameRule{From: from[1], To: to[1]}.rename(entry.FieldStr)
+			if moduleMatched && fieldMatched {
+				entries[i].ModuleStr, entries[i].FieldStr = module, field
+				renamed[i] = module != entry.ModuleStr || field != entry.FieldStr
+				if renamed[i] {
+					r.Renamed++
+				}
+				break
+			}
+		}
+	}
+
+	// an import renamed like another one is the same entity for the host.
+	for i, a := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			b := entries[j]
+			if (!renamed[i] && !renamed[j]) || a.ModuleStr != b.ModuleStr || a.FieldStr != b.FieldStr {
+				continue
+			}
+			if a.Kind != b.Kind || !sameImportType(types, a, b) {
+				return fmt.Errorf("import %s.%s: conflicting signatures", a.ModuleStr, a.FieldStr)
+			}
+		}
+	}
+	return nil
+}
+
+// splitImportName splits a name at its first dot, the module names of the runtimes have no dots.
+func splitImportName(name string) [2]string {
+	parts := strings.SplitN(name, ".", 2)
+	return [2]string{parts[0], parts[1]}
+}
+
+func sameImportType(types []toolkit.TypeEntry, a, b toolkit.ImportEntry) bool {
+	if a.Kind != "function" {
+		return reflect.DeepEqual(a.Type, b.Type)
+	}
+	ta, tb := a.Type.(uint64), b.Type.(uint64)
+	if ta == tb {
+		return true
+	}
+	if ta >= uint64(len(types)) || tb >= uint64(len(types)) {
+		return false
+	}
+	return types[ta].Form == types[tb].Form && types[ta].ReturnType == types[tb].ReturnType &&
+		reflect.DeepEqual(types[ta].Params, types[tb].Params)
+}
+
+func (r *Rename) renameExports(section toolkit.JSON) error {
+	entries, _ := section["entries"].([]toolkit.ExportEntry)
+	for i, entry := range entries {
+		for _, rule := range r.Exports {
+			if name, matched := rule.rename(entry.FieldStr); matched {
+				entries[i].FieldStr = name
+				if name != entry.FieldStr {
+					r.Renamed++
+				}
+				break
+			}
+		}
+	}
+	for _, entry := range entries {
+		for _, rule := range r.Aliases {
+			if name, matched := rule.rename(entry.FieldStr); matched && name != entry.FieldStr {
+				entry.FieldStr = name
+				entries = append(entries, entry)
+				r.Renamed++
+				break
+			}
+		}
+	}
+	section["entries"] = entries
+
+	names := map[string]struct{}{}
+	for _, entry := range entries {
+		if _, exist := names[entry.FieldStr]; exist {
+			return fmt.Errorf("duplicate export %q", entry.FieldStr)
+		}
+		names[entry.FieldStr] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package pass
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// Strip is the pass that removes the custom sections and the exports a module doesn't need once deployed,
+// i.e. the debug info, the producers and the names.
+type Strip struct {
+	// the names of the custom sections to remove or their patterns (see path.Match), i.e. `.debug_*`.
+	Sections []string
+	// the names of the exports to keep, the others are removed. All of them are kept if it's nil.
+	Exports []string
+
+	// the names of the removed custom sections and exports, and the bytes it saved.
+	StrippedSections []string
+	StrippedExports  []string
+	Saved            int
+}
+
+func (s *Strip) Name() string {
+	return "strip"
+}
+
+func (s *Strip) Run(module []toolkit.JSON) ([]toolkit.JSON, error) {
+	s.StrippedSections, s.StrippedExports, s.Saved = nil, nil, 0
+	for _, pattern := range s.Sections {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("section pattern %q: %v", pattern, err)
+		}
+	}
+	size := len(toolkit.Json2Wasm(module))
+
+	var newModule []toolkit.JSON
+	for _, section := range module {
+		switch section["name"] {
+		case "custom":
+			name, _ := section["section_name"].(string)
+			if s.stripSection(name) {
+				s.StrippedSections = append(s.StrippedSections, name)
+				continue
+			}
+		case "export":
+			if s.Exports == nil {
+				break
+			}
+			entries, _ := section["entries"].([]toolkit.ExportEntry)
+			var kept []toolkit.ExportEntry
+			for _, entry := range entries {
+				if s.keepExport(entry.FieldStr) {
+					kept = append(kept, entry)
+				} else {
+					s.StrippedExports = append(s.StrippedExports, entry.FieldStr)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			section = toolkit.JSON{"name": "export", "entries": kept}
+		}
+		newModule = append(newModule, section)
+	}
+
+	s.Saved = size - len(toolkit.Json2Wasm(newModule))
+	return newModule, nil
+}
+
+func (s *Strip) stripSection(name string) bool {
+	for _, pattern := range s.Sections {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Strip) keepExport(name string) bool {
+	for _, export := range s.Exports {
+		if export == name {
+			return true
+		}
+	}
+	return false
+}
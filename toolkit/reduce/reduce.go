@@ -0,0 +1,366 @@
+// Package reduce shrinks a module while it still shows a bug, i.e. to attach a reproducer to an issue
+// without sharing the module. It removes the custom sections, the exports, the functions, the instructions
+// and the data bytes with delta debugging, the module is kept valid as far as toolkit.ValidateModule checks it.
+package reduce
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+	"github.com/yyh1102/go-wasm-metering/toolkit/pass"
+)
+
+// Predicate reports whether a module still shows the bug, it mustn't modify the module. It's only called
+// with modules toolkit.ValidateModule accepts. The code following the reference and garbage collection
+// operators isn't type-checked by it though: for the modules using them, the predicate should also check
+// that the engine accepts the module.
+type Predicate func(module []toolkit.JSON) bool
+
+// the smallest part of a data segment removed at once, the segments may be megabytes long.
+const dataChunks = 256
+
+// Reduce removes the parts of a module the predicate doesn't need until none can be removed. The module must
+// be valid and the predicate must hold for it.
+func Reduce(module []toolkit.JSON, interesting Predicate) ([]toolkit.JSON, error) {
+	if err := toolkit.ValidateModule(module); err != nil {
+		return nil, fmt.Errorf("reduce: invalid module: %v", err)
+	}
+	if !interesting(module) {
+		return nil, fmt.Errorf("reduce: the predicate doesn't hold for the module")
+	}
+	r := &reducer{module: clone(module), interesting: interesting}
+	steps := []func() bool{r.customs, r.exports, r.deadCode, r.functions, r.stubs, r.instructions, r.data}
+	for progress := true; progress; {
+		progress = false
+		for _, step := range steps {
+			if step() {
+				progress = true
+			}
+		}
+	}
+	return r.module, nil
+}
+
+// clone copies a module deeply, the candidates are modified copies of the module.
+func clone(module []toolkit.JSON) []toolkit.JSON {
+	return toolkit.Wasm2Json(toolkit.Json2Wasm(module))
+}
+
+type reducer struct {
+	module      []toolkit.JSON
+	interesting Predicate
+}
+
+// try keeps a candidate if it's valid and interesting, the candidate is nil if it couldn't be built. The
+// operators toolkit.ValidateModule doesn't model are left to the predicate (see Predicate).
+func (r *reducer) try(candidate []toolkit.JSON) bool {
+	if candidate == nil || toolkit.ValidateModule(candidate) != nil || !r.interesting(candidate) {
+		return false
+	}
+	r.module = candidate
+	return true
+}
+
+// ddmin removes the items of a list while the candidate built with the ones left is kept, by chunks that are
+// halved down to minChunk. It reports whether some items were removed.
+func ddmin(n, minChunk int, try func(keep []int) bool) bool {
+	keep := make([]int, n)
+	for i := range keep {
+		keep[i] = i
+	}
+	if n == 0 {
+		return false
+	}
+	if try(nil) {
+		return true
+	}
+	if minChunk < 1 {
+		minChunk = 1
+	}
+
+	removed := false
+	chunk := n / 2
+	if chunk < minChunk {
+		chunk = minChunk
+	}
+	for chunk >= minChunk && len(keep) > 0 {
+		progress := false
+		for start := 0; start < len(keep); {
+			end := start + chunk
+			if end > len(keep) {
+				end = len(keep)
+			}
+			candidate := append(append([]int{}, keep[:start]...), keep[end:]...)
+			if try(candidate) {
+				keep = candidate
+				progress, removed = true, true
+				continue
+			}
+			start = end
+		}
+		if !progress {
+			if chunk == minChunk {
+				break
+			}
+			chunk /= 2
+			if chunk < minChunk {
+				chunk = minChunk
+			}
+		}
+	}
+	return removed
+}
+
+// sectionPos returns the position of a section, -1 if there's none.
+func sectionPos(module []toolkit.JSON, name string) int {
+	for i, section := range module {
+		if section["name"] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *reducer) customs() bool {
+	base := r.module
+	var customs []int
+	for i, section := range base {
+		if section["name"] == "custom" {
+			customs = append(customs, i)
+		}
+	}
+	return ddmin(len(customs), 1, func(keep []int) bool {
+		removed := map[int]struct{}{}
+		for _, i := range customs {
+			removed[i] = struct{}{}
+		}
+		for _, k := range keep {
+			delete(removed, customs[k])
+		}
+		var candidate []toolkit.JSON
+		for i, section := range clone(base) {
+			if _, exist := removed[i]; !exist {
+				candidate = append(candidate, section)
+			}
+		}
+		return r.try(candidate)
+	})
+}
+
+func (r *reducer) exports() bool {
+	base := r.module
+	pos := sectionPos(base, "export")
+	if pos < 0 {
+		return false
+	}
+	entries := base[pos]["entries"].([]toolkit.ExportEntry)
+	return ddmin(len(entries), 1, func(keep []int) bool {
+		candidate := clone(base)
+		kept := []toolkit.ExportEntry{}
+		for _, k := range keep {
+			kept = append(kept, entries[k])
+		}
+		candidate[pos]["entries"] = kept
+		if len(kept) == 0 {
+			candidate = append(candidate[:pos], candidate[pos+1:]...)
+		}
+		return r.try(candidate)
+	})
+}
+
+// deadCode removes what the exports and the start function don't use once the exports are removed.
+func (r *reducer) deadCode() bool {
+	dce := &pass.DeadCode{}
+	candidate, err := pass.NewManager(dce).Run(clone(r.module))
+	if err != nil || len(dce.Functions)+len(dce.Globals)+len(dce.Types) == 0 {
+		return false
+	}
+	return r.try(candidate)
+}
+
+// functions removes the functions, imported or defined, the module doesn't refer to.
+func (r *reducer) functions() bool {
+	base := r.module
+	imported, defined := toolkit.NewIndexSpace(base).Len("function")
+	n := int(imported + defined)
+	return ddmin(n, 1, func(keep []int) bool {
+		kept := map[int]struct{}{}
+		for _, k := range keep {
+			kept[k] = struct{}{}
+		}
+		var removed []uint32
+		for i := 0; i < n; i++ {
+			if _, exist := kept[i]; !exist {
+				removed = append(removed, uint32(i))
+			}
+		}
+		space := toolkit.NewIndexSpace(clone(base))
+		if _, err := space.Remove("function", removed...); err != nil {
+			return false
+		}
+		return r.try(space.Module())
+	})
+}
+
+// withBody returns a copy of the module where the function at a position of the code section has another
+// body, the other sections and bodies are shared with the module.
+func withBody(module []toolkit.JSON, pos, i int, body toolkit.CodeBody) []toolkit.JSON {
+	candidate := append([]toolkit.JSON{}, module...)
+	section := toolkit.JSON{}
+	for key, value := range module[pos] {
+		section[key] = value
+	}
+	bodies := append([]toolkit.CodeBody{}, module[pos]["entries"].([]toolkit.CodeBody)...)
+	bodies[i] = body
+	section["entries"] = bodies
+	candidate[pos] = section
+	return candidate
+}
+
+// tryBody keeps a candidate body of a function if it's valid and the module is interesting with it, only the
+// body is validated, the rest of the module is the one of the last kept candidate.
+func (r *reducer) tryBody(validator *toolkit.CodeValidator, pos, i int, body toolkit.CodeBody) bool {
+	if validator.Validate(i, body) != nil {
+		return false
+	}
+	candidate := withBody(r.module, pos, i, body)
+	if !r.interesting(candidate) {
+		return false
+	}
+	r.module = candidate
+	return true
+}
+
+func (r *reducer) body(pos, i int) toolkit.CodeBody {
+	return r.module[pos]["entries"].([]toolkit.CodeBody)[i]
+}
+
+// stubs replaces the bodies of the functions by `unreachable`, it's valid whatever the type of the function.
+func (r *reducer) stubs() bool {
+	removed := false
+	pos := sectionPos(r.module, "code")
+	if pos < 0 {
+		return false
+	}
+	validator := toolkit.NewCodeValidator(r.module)
+	code, _ := toolkit.NewEmitter().Unreachable().Code()
+	stub := toolkit.CodeBody{Locals: []toolkit.LocalEntry{}, Code: code}
+	for i := range r.module[pos]["entries"].([]toolkit.CodeBody) {
+		body := r.body(pos, i)
+		if len(body.Locals) == 0 && len(body.Code) <= 2 {
+			continue
+		}
+		if r.tryBody(validator, pos, i, stub) {
+			removed = true
+		}
+	}
+	return removed
+}
+
+// instructions removes the instructions of every function, the final `end` aside, with delta debugging. The
+// chunks rarely leave the operand stack balanced, the blocks are then removed one by one too.
+func (r *reducer) instructions() bool {
+	removed := false
+	pos := sectionPos(r.module, "code")
+	if pos < 0 {
+		return false
+	}
+	validator := toolkit.NewCodeValidator(r.module)
+	for i := range r.module[pos]["entries"].([]toolkit.CodeBody) {
+		base := r.body(pos, i)
+		if len(base.Code) < 2 {
+			continue
+		}
+		if ddmin(len(base.Code)-1, 1, func(keep []int) bool {
+			body := base
+			body.Code = make([]toolkit.OP, 0, len(keep)+1)
+			for _, k := range keep {
+				body.Code = append(body.Code, base.Code[k])
+			}
+			body.Code = append(body.Code, base.Code[len(base.Code)-1])
+			return r.tryBody(validator, pos, i, body)
+		}) {
+			removed = true
+		}
+		if r.blocks(validator, pos, i) {
+			removed = true
+		}
+	}
+	return removed
+}
+
+// cut removes the instructions of a function from start to end.
+func (r *reducer) cut(validator *toolkit.CodeValidator, pos, i, start, end int) bool {
+	body := r.body(pos, i)
+	body.Code = append(append([]toolkit.OP{}, body.Code[:start]...), body.Code[end:]...)
+	return r.tryBody(validator, pos, i, body)
+}
+
+// blocks removes the blocks of a function from their start to their end, with the instruction before them
+// too, i.e. the condition of an `if`.
+func (r *reducer) blocks(validator *toolkit.CodeValidator, pos, i int) bool {
+	removed := false
+	for next := 0; ; {
+		ranges := blockRanges(r.body(pos, i).Code)
+		for len(ranges) > 0 && ranges[0][0] < next {
+			ranges = ranges[1:]
+		}
+		if len(ranges) == 0 {
+			return removed
+		}
+		start, end := ranges[0][0], ranges[0][1]+1
+		if r.cut(validator, pos, i, start, end) || (start > 0 && r.cut(validator, pos, i, start-1, end)) {
+			removed = true
+			continue
+		}
+		next = start + 1
+	}
+}
+
+// blockRanges returns the positions of the starts and of the ends of the blocks of a body, by start.
+func blockRanges(code []toolkit.OP) [][2]int {
+	var (
+		ranges [][2]int
+		starts []int
+	)
+	for i, op := range code {
+		switch op.Name {
+		case "block", "loop", "if", "try", "try_table":
+			starts = append(starts, i)
+		case "end", "delegate":
+			if len(starts) > 0 {
+				ranges = append(ranges, [2]int{starts[len(starts)-1], i})
+				starts = starts[:len(starts)-1]
+			}
+		}
+	}
+	sort.Slice(ranges, func(a, b int) bool { return ranges[a][0] < ranges[b][0] })
+	return ranges
+}
+
+// data removes the bytes of the data segments.
+func (r *reducer) data() bool {
+	removed := false
+	pos := sectionPos(r.module, "data")
+	if pos < 0 {
+		return false
+	}
+	for i := range r.module[pos]["entries"].([]toolkit.DataSegment) {
+		base := r.module
+		data := base[pos]["entries"].([]toolkit.DataSegment)[i].Data
+		if ddmin(len(data), len(data)/dataChunks, func(keep []int) bool {
+			candidate := clone(base)
+			segment := &candidate[pos]["entries"].([]toolkit.DataSegment)[i]
+			kept := make([]byte, 0, len(keep))
+			for _, k := range keep {
+				kept = append(kept, data[k])
+			}
+			segment.Data = kept
+			return r.try(candidate)
+		}) {
+			removed = true
+		}
+	}
+	return removed
+}
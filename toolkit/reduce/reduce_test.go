@@ -0,0 +1,87 @@
+package reduce
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yyh1102/go-wasm-metering/toolkit"
+)
+
+// hasDiv is the bug: the module divides.
+func hasDiv(module []toolkit.JSON) bool {
+	for _, section := range module {
+		if section["name"] != "code" {
+			continue
+		}
+		for _, body := range section["entries"].([]toolkit.CodeBody) {
+			for _, op := range body.Code {
+				if op.ReturnType == "i32" && op.Name == "div_s" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func TestReduce(t *testing.T) {
+	b := toolkit.NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	binary := b.FuncType([]string{"i32", "i32"}, "i32")
+	log, err := b.ImportFunction("env", "log", b.FuncType([]string{"i32"}, ""))
+	assert.Nil(t, err)
+	b.AddMemory(toolkit.MemLimits{Intial: 1})
+	code, _ := toolkit.NewEmitter().
+		GetLocal(0).Call(log).
+		Block(toolkit.BLOCK_TYPE_EMPTY, func(e *toolkit.Emitter) {
+			e.GetLocal(1).I32Const(0).Instr("i32", "eq").BrIf(0)
+			e.I32Const(1).Call(log)
+		}).
+		GetLocal(0).GetLocal(1).Instr("i32", "div_s").
+		Code()
+	div := b.AddFunction(binary, nil, code)
+	code, _ = toolkit.NewEmitter().I32Const(6).I32Const(3).Call(div).Call(log).Code()
+	main := b.AddFunction(void, []toolkit.LocalEntry{{Count: 3, Type: "i64"}}, code)
+	code, _ = toolkit.NewEmitter().I32Const(7).Call(log).Code()
+	other := b.AddFunction(void, nil, code)
+	assert.Nil(t, b.AddExport("main", "function", main))
+	assert.Nil(t, b.AddExport("other", "function", other))
+	module := append(b.Module(),
+		toolkit.JSON{"name": "data", "entries": []toolkit.DataSegment{{Offset: toolkit.NewEmitter().I32Const(0).Ops(), Data: []byte("secret")}}},
+		toolkit.JSON{"name": "custom", "section_name": "name", "payload": "secret"})
+	assert.Nil(t, toolkit.ValidateModule(module))
+
+	reduced, err := Reduce(module, hasDiv)
+	assert.Nil(t, err)
+	assert.Nil(t, toolkit.ValidateModule(reduced))
+	assert.True(t, hasDiv(reduced))
+	assert.True(t, len(toolkit.Json2Wasm(reduced)) < len(toolkit.Json2Wasm(module))/2)
+
+	space := toolkit.NewIndexSpace(reduced)
+	imported, defined := space.Len("function")
+	assert.Equal(t, []uint32{0, 1}, []uint32{imported, defined})
+	for _, section := range reduced {
+		switch section["name"] {
+		case "custom":
+			t.Errorf("the custom section %v is kept", section["section_name"])
+		case "export":
+			t.Errorf("the exports %v are kept", section["entries"])
+		case "code":
+			assert.True(t, len(section["entries"].([]toolkit.CodeBody)[0].Code) <= 4)
+		case "data":
+			assert.Empty(t, section["entries"].([]toolkit.DataSegment)[0].Data)
+		}
+	}
+
+	// the module is left as it is.
+	assert.Len(t, module[len(module)-1]["payload"], len("secret"))
+	_, err = Reduce(reduced, func([]toolkit.JSON) bool { return false })
+	assert.NotNil(t, err)
+
+	// an invalid module isn't reported as already reduced.
+	b = toolkit.NewBuilder(nil)
+	code, _ = toolkit.NewEmitter().Code()
+	b.AddFunction(b.FuncType(nil, "i32"), nil, code)
+	_, err = Reduce(b.Module(), func([]toolkit.JSON) bool { return true })
+	assert.Contains(t, err.Error(), "reduce: invalid module")
+}
@@ -0,0 +1,258 @@
+package toolkit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// OpRelocation relocates the first immediate of an operator a transformation added, i.e. the
+// function index of a `call`.
+type OpRelocation struct {
+	Func   int    // the position of the function in the code section.
+	Op     int    // the index of the operator in the new code.
+	Type   string // the type of the relocation.
+	Symbol int    // the index of the symbol in Relocator.Symbols.
+}
+
+// Relocator rewrites the "linking" and "reloc.*" sections of a relocatable module after a
+// transformation moved its code or renumbered its functions, so that the linker still accepts it.
+// The functions have to keep their position in the code section.
+type Relocator struct {
+	Sections []JSON     // the module before the transformation.
+	Code     []CodeBody // the code section before the transformation.
+	OpMap    [][]int    // the new index of each operator of each function, -1 if it's removed. nil keeps them.
+
+	RemapFunction func(index uint32) uint32 // renumbers the functions, nil if they keep their indices.
+	RemapType     func(index uint32) uint32 // renumbers the types, nil if they keep their indices.
+
+	Symbols     []Symbol       // the symbols to add to the symbol table.
+	Relocations []OpRelocation // the relocations of the operators the transformation added.
+}
+
+// IsRelocatable reports whether a module is a relocatable object file, that is it has a "linking" section.
+func IsRelocatable(module []JSON) bool {
+	return findCustomSection(module, "linking") >= 0
+}
+
+// findCustomSection returns the position of the custom section with the given name, -1 if there isn't one.
+func findCustomSection(module []JSON, name string) int {
+	for i, section := range module {
+		if section["name"] == "custom" && section["section_name"] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func findSectionByName(module []JSON, name string) int {
+	for i, section := range module {
+		if section["name"] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Relocate rewrites the sections of the transformed module, it returns the module with
+// a new "reloc.CODE" section if the added relocations need one.
+func (r *Relocator) Relocate(module []JSON) []JSON {
+	linkingPos := findCustomSection(module, "linking")
+	if linkingPos < 0 {
+		return module
+	}
+
+	oldCodePos := findSectionByName(r.Sections, "code")
+	codePos := findSectionByName(module, "code")
+	if codePos < 0 || oldCodePos < 0 {
+		return module
+	}
+
+	// the section indices don't count the preamble.
+	oldCodeIndex := uint32(oldCodePos - 1)
+	codeRelocPos := -1
+	for i, section := range module {
+		name, _ := section["section_name"].(string)
+		if section["name"] == "custom" && strings.HasPrefix(name, "reloc.") && ParseReloc(section["payload"].(string)).Section == oldCodeIndex {
+			codeRelocPos = i
+		}
+	}
+	if codeRelocPos < 0 && len(r.Relocations) > 0 {
+		codeRelocPos = linkingPos + 1
+		relocSec := JSON{
+			"name":         "custom",
+			"section_name": "reloc.CODE",
+			"payload":      GenerateReloc(RelocSec{Section: oldCodeIndex}),
+		}
+		module = append(module[:codeRelocPos], append([]JSON{relocSec}, module[codeRelocPos:]...)...)
+		r.Sections = append(r.Sections, relocSec)
+	}
+
+	sectionMap := r.sectionMap(module)
+	codeMap := NewCodeMap(r.Code, module[codePos]["entries"].([]CodeBody), r.OpMap)
+
+	// the old function index of every function symbol, for the function offsets.
+	linking := ParseLinking(module[linkingPos]["payload"].(string))
+	symbolFuncs := map[uint32]uint32{}
+	for i, sym := range linking.Symbols {
+		if sym.Kind == "function" {
+			symbolFuncs[uint32(i)] = sym.Index
+		}
+	}
+	r.relocateLinking(&linking, sectionMap)
+	module[linkingPos]["payload"] = GenerateLinking(linking)
+	symbolBase := len(linking.Symbols) - len(r.Symbols)
+
+	importedFuncs := countImportedFuncs(r.Sections)
+	for i, section := range module {
+		name, _ := section["section_name"].(string)
+		if section["name"] != "custom" || !strings.HasPrefix(name, "reloc.") {
+			continue
+		}
+
+		relocSec := ParseReloc(section["payload"].(string))
+		target := relocSec.Section
+		entries := make([]Relocation, 0, len(relocSec.Entries))
+		for _, entry := range relocSec.Entries {
+			if target == oldCodeIndex {
+				offset, exist := codeMap.Map(entry.Offset)
+				if !exist {
+					continue
+				}
+				entry.Offset = offset
+			}
+			if entry.Type == "R_WASM_TYPE_INDEX_LEB" && r.RemapType != nil {
+				entry.Index = r.RemapType(entry.Index)
+			}
+			if entry.Type == "R_WASM_FUNCTION_OFFSET_I32" || entry.Type == "R_WASM_FUNCTION_OFFSET_I64" {
+				if funcIndex, exist := symbolFuncs[entry.Index]; exist && funcIndex >= importedFuncs {
+					f := int(funcIndex - importedFuncs)
+					entry.Addend = mapFuncAddend(codeMap, f, entry.Addend)
+				}
+			}
+			entries = append(entries, entry)
+		}
+
+		if i == codeRelocPos {
+			code := module[codePos]["entries"].([]CodeBody)
+			for _, reloc := range r.Relocations {
+				op := code[reloc.Func].Code[reloc.Op]
+				entries = append(entries, Relocation{
+					Type:   reloc.Type,
+					Offset: codeMap.New[reloc.Func].Ops[reloc.Op] + opcodeLen(op),
+					Index:  uint32(symbolBase + reloc.Symbol),
+				})
+			}
+		}
+
+		// the linker requires the relocations in the order of their offsets.
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Offset < entries[j].Offset
+		})
+		if newTarget, exist := sectionMap[target]; exist {
+			relocSec.Section = newTarget
+		}
+		relocSec.Entries = entries
+		section["payload"] = GenerateReloc(relocSec)
+	}
+
+	return module
+}
+
+// relocateLinking renumbers the symbols and the comdats and adds the new symbols.
+func (r *Relocator) relocateLinking(linking *Linking, sectionMap map[uint32]uint32) {
+	for i, sym := range linking.Symbols {
+		switch sym.Kind {
+		case "function":
+			if r.RemapFunction != nil {
+				linking.Symbols[i].Index = r.RemapFunction(sym.Index)
+			}
+		case "section":
+			if index, exist := sectionMap[sym.Index]; exist {
+				linking.Symbols[i].Index = index
+			}
+		}
+	}
+
+	for _, comdat := range linking.Comdats {
+		for i, entry := range comdat.Entries {
+			switch entry.Kind {
+			case "function":
+				if r.RemapFunction != nil {
+					comdat.Entries[i].Index = r.RemapFunction(entry.Index)
+				}
+			case "section":
+				if index, exist := sectionMap[entry.Index]; exist {
+					comdat.Entries[i].Index = index
+				}
+			}
+		}
+	}
+
+	if len(r.Symbols) == 0 {
+		return
+	}
+	linking.Symbols = append(linking.Symbols, r.Symbols...)
+	for _, sub := range linking.Subsections {
+		if sub.Type == LINKING_SYMBOL_TABLE {
+			return
+		}
+	}
+	linking.Subsections = append(linking.Subsections, LinkingSubsection{Type: LINKING_SYMBOL_TABLE})
+}
+
+// sectionMap maps the index of every section before the transformation to its index after it.
+func (r *Relocator) sectionMap(module []JSON) map[uint32]uint32 {
+	sectionMap := map[uint32]uint32{}
+	for i, old := range r.Sections {
+		for j, section := range module {
+			if i > 0 && j > 0 && reflect.ValueOf(old).Pointer() == reflect.ValueOf(section).Pointer() {
+				sectionMap[uint32(i-1)] = uint32(j - 1)
+			}
+		}
+	}
+	return sectionMap
+}
+
+// mapFuncAddend maps an offset relative to the start of the body of the function at position f.
+func mapFuncAddend(codeMap *CodeMap, f int, addend int64) int64 {
+	if f >= len(codeMap.Old) || f >= len(codeMap.New) {
+		return addend
+	}
+	offset, exist := codeMap.MapFunc(f, codeMap.Old[f].Start+uint32(addend))
+	if !exist {
+		return addend
+	}
+	return int64(offset) - int64(codeMap.New[f].Start)
+}
+
+func countImportedFuncs(module []JSON) uint32 {
+	count := uint32(0)
+	for _, section := range module {
+		if section["name"] != "import" {
+			continue
+		}
+		entries, _ := section["entries"].([]ImportEntry)
+		for _, entry := range entries {
+			if entry.Kind == "function" {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// opcodeLen returns the size of the opcode of an operator, its immediates follow it.
+func opcodeLen(op OP) uint32 {
+	name := op.Name
+	if op.ReturnType != "" {
+		name = op.ReturnType + "." + name
+	}
+	if _, code, exist := lookupPrefixedOpcode(name); exist {
+		if len(op.Widths) > 0 {
+			return 1 + uint32(op.Widths[0])
+		}
+		return 1 + uint32(uleb128Len(uint64(code)))
+	}
+	return 1
+}
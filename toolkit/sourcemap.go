@@ -0,0 +1,251 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The source maps of WebAssembly modules have a single line, the columns of the mappings are offsets
+// in the module binary.
+
+const SOURCE_MAPPING_URL_SECTION = "sourceMappingURL"
+
+const base64VLQChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// SourceMappingURL returns the URL of the source map of a module, from its "sourceMappingURL" section.
+func SourceMappingURL(module []JSON) (string, bool) {
+	pos := findCustomSection(module, SOURCE_MAPPING_URL_SECTION)
+	if pos < 0 {
+		return "", false
+	}
+	return readName(NewStream([]byte(module[pos]["payload"].(string)))), true
+}
+
+// SetSourceMappingURL points the "sourceMappingURL" section of a module to a URL, the section is appended
+// if the module has none.
+func SetSourceMappingURL(module []JSON, url string) []JSON {
+	payload := NewStream(nil)
+	writeName(url, payload)
+	if pos := findCustomSection(module, SOURCE_MAPPING_URL_SECTION); pos >= 0 {
+		module[pos]["payload"] = payload.String()
+		return module
+	}
+	return append(module, JSON{
+		"name":         "custom",
+		"section_name": SOURCE_MAPPING_URL_SECTION,
+		"payload":      payload.String(),
+	})
+}
+
+// CodeSectionOffset returns the offset of the payload of the code section in a module binary.
+func CodeSectionOffset(wasm []byte) (uint32, bool) {
+	stream := NewStream(wasm)
+	stream.Read(8) // the preamble.
+	for stream.Len() > 0 {
+		id := stream.ReadByte()
+		size := DecodeULEB128(stream)
+		if id == J2W_SECTION_IDS["code"] {
+			return uint32(stream.bytesRead), true
+		}
+		stream.Read(int(size))
+	}
+	return 0, false
+}
+
+// BinaryMap maps the offsets of a module binary to the ones of the binary a transformation generated from it.
+// The offsets out of the code section aren't mapped.
+type BinaryMap struct {
+	Code    *CodeMap
+	OldCode uint32 // the offset of the payload of the code section in the old binary.
+	NewCode uint32 // the offset of the payload of the code section in the new binary.
+}
+
+// NewBinaryMap locates the code sections of the binaries of the code map.
+func NewBinaryMap(oldWasm, newWasm []byte, codeMap *CodeMap) *BinaryMap {
+	oldCode, _ := CodeSectionOffset(oldWasm)
+	newCode, _ := CodeSectionOffset(newWasm)
+	return &BinaryMap{
+		Code:    codeMap,
+		OldCode: oldCode,
+		NewCode: newCode,
+	}
+}
+
+// Map maps an offset of the old binary, it's false if the offset isn't in the code or its operator is removed.
+func (m *BinaryMap) Map(offset uint32) (uint32, bool) {
+	if offset < m.OldCode {
+		return offset, false
+	}
+	newOffset, exist := m.Code.Map(offset - m.OldCode)
+	if !exist {
+		return offset, false
+	}
+	return newOffset + m.NewCode, true
+}
+
+// Reverse maps an offset of the new binary back to the old one, i.e. the offset of a trap. It's false if the
+// offset isn't in the code or it's in the code the transformation added.
+func (m *BinaryMap) Reverse(offset uint32) (uint32, bool) {
+	if offset < m.NewCode {
+		return offset, false
+	}
+	oldOffset, exist := m.Code.Reverse(offset - m.NewCode)
+	if !exist {
+		return offset, false
+	}
+	return oldOffset + m.OldCode, true
+}
+
+// mapping is a segment of the mappings of a source map, its fields are absolute.
+type mapping struct {
+	column int64
+	fields []int64 // the source, the line and the column in the source, the name.
+}
+
+// RewriteSourceMap maps the columns of a source map of revision 3, the mappings whose offset can't be mapped
+// are removed. The other properties of the source map are kept.
+func RewriteSourceMap(sourceMap []byte, binaryMap *BinaryMap) ([]byte, error) {
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(sourceMap, &obj); err != nil {
+		return nil, err
+	}
+	mappings, ok := obj["mappings"].(string)
+	if !ok {
+		return nil, fmt.Errorf("source map: no mappings")
+	}
+
+	lines, err := decodeMappings(mappings)
+	if err != nil {
+		return nil, fmt.Errorf("source map: %v", err)
+	}
+	for i, line := range lines {
+		mapped := line[:0]
+		for _, seg := range line {
+			if seg.column < 0 || seg.column > int64(^uint32(0)) {
+				continue
+			}
+			column, exist := binaryMap.Map(uint32(seg.column))
+			if !exist {
+				continue
+			}
+			seg.column = int64(column)
+			mapped = append(mapped, seg)
+		}
+		sort.SliceStable(mapped, func(i, j int) bool {
+			return mapped[i].column < mapped[j].column
+		})
+		lines[i] = mapped
+	}
+
+	obj["mappings"] = encodeMappings(lines)
+	return json.Marshal(obj)
+}
+
+func decodeMappings(mappings string) ([][]mapping, error) {
+	var (
+		lines  [][]mapping
+		fields [4]int64
+	)
+	for _, line := range strings.Split(mappings, ";") {
+		var (
+			segs   []mapping
+			column int64
+		)
+		for _, segment := range strings.Split(line, ",") {
+			if segment == "" {
+				continue
+			}
+			values, err := decodeVLQ(segment)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) != 1 && len(values) != 4 && len(values) != 5 {
+				return nil, fmt.Errorf("invalid segment %q", segment)
+			}
+			column += values[0]
+			seg := mapping{column: column}
+			for i, v := range values[1:] {
+				fields[i] += v
+				seg.fields = append(seg.fields, fields[i])
+			}
+			segs = append(segs, seg)
+		}
+		lines = append(lines, segs)
+	}
+	return lines, nil
+}
+
+func encodeMappings(lines [][]mapping) string {
+	var (
+		out    strings.Builder
+		fields [4]int64
+	)
+	for i, line := range lines {
+		if i > 0 {
+			out.WriteByte(';')
+		}
+		column := int64(0)
+		for j, seg := range line {
+			if j > 0 {
+				out.WriteByte(',')
+			}
+			encodeVLQ(&out, seg.column-column)
+			column = seg.column
+			for k, v := range seg.fields {
+				encodeVLQ(&out, v-fields[k])
+				fields[k] = v
+			}
+		}
+	}
+	return out.String()
+}
+
+func decodeVLQ(segment string) ([]int64, error) {
+	var (
+		values []int64
+		value  int64
+		shift  uint
+	)
+	for i := 0; i < len(segment); i++ {
+		digit := strings.IndexByte(base64VLQChars, segment[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid character %q", segment[i])
+		}
+		value |= int64(digit&0x1f) << shift
+		if digit&0x20 != 0 {
+			shift += 5
+			continue
+		}
+		// the lowest bit is the sign.
+		if value&1 != 0 {
+			values = append(values, -(value >> 1))
+		} else {
+			values = append(values, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, fmt.Errorf("truncated segment %q", segment)
+	}
+	return values, nil
+}
+
+func encodeVLQ(out *strings.Builder, v int64) {
+	vlq := v << 1
+	if v < 0 {
+		vlq = -v<<1 | 1
+	}
+	for {
+		digit := vlq & 0x1f
+		vlq >>= 5
+		if vlq != 0 {
+			digit |= 0x20
+		}
+		out.WriteByte(base64VLQChars[digit])
+		if vlq == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Stats describes what modules contain, i.e. to tune a cost table. The stats of a corpus are the sum of the
+// stats of its modules (see Add).
+type Stats struct {
+	Modules int `json:"modules"`
+	Size    int `json:"size"`
+	// the bytes of the sections by name, headers included. The custom sections are named `custom:<name>`.
+	Sections map[string]int `json:"sections"`
+	// the number of operators by full name, i.e. `i32.add`.
+	Opcodes   map[string]int `json:"opcodes"`
+	Functions []FuncStats    `json:"functions"`
+	MaxDepth  int            `json:"max_depth"`
+	Memories  []LimitsStats  `json:"memories"`
+	Tables    []LimitsStats  `json:"tables"`
+}
+
+// FuncStats describes a defined function, its size includes the size of its body.
+type FuncStats struct {
+	Index  uint32 `json:"index"`
+	Size   int    `json:"size"`
+	Ops    int    `json:"ops"`
+	Locals int    `json:"locals"` // the declared locals, the params aside.
+	Depth  int    `json:"depth"`  // the deepest nesting of blocks, 0 if the body has none.
+}
+
+// LimitsStats are the limits of a memory or a table, imported or defined.
+type LimitsStats struct {
+	Imported bool    `json:"imported"`
+	Initial  uint64  `json:"initial"`
+	Maximum  *uint64 `json:"maximum,omitempty"`
+}
+
+// Analyze decodes a module and describes it.
+func Analyze(wasm []byte) (*Stats, error) {
+	if len(wasm) < 8 || !bytes.Equal(wasm[:4], []byte{0x00, 0x61, 0x73, 0x6d}) {
+		return nil, fmt.Errorf("not a WebAssembly module")
+	}
+	s := &Stats{
+		Modules:   1,
+		Size:      len(wasm),
+		Sections:  map[string]int{},
+		Opcodes:   map[string]int{},
+		Functions: []FuncStats{},
+		Memories:  []LimitsStats{},
+		Tables:    []LimitsStats{},
+	}
+
+	stream := NewStream(wasm[8:])
+	for stream.Len() > 0 {
+		size := stream.Len()
+		header := ParseSectionHeader(stream)
+		if header.Size > uint64(stream.Len()) {
+			return nil, fmt.Errorf("section %s: %d bytes out of %d", header.Name, header.Size, stream.Len())
+		}
+		payload := stream.Read(int(header.Size))
+		name := header.Name
+		if name == "custom" {
+			sub := NewStream(payload)
+			name = "custom:" + string(sub.Read(int(DecodeULEB128(sub))))
+		}
+		s.Sections[name] += size - stream.Len()
+	}
+
+	module, offsets := Wasm2JsonOffsets(wasm)
+	var importedFuncs uint32
+	for _, section := range module {
+		switch section["name"] {
+		case "import":
+			entries, _ := section["entries"].([]ImportEntry)
+			for _, entry := range entries {
+				switch entry.Kind {
+				case "function":
+					importedFuncs++
+				case "memory":
+					s.Memories = append(s.Memories, limitsStats(entry.Type.(MemLimits), true))
+				case "table":
+					s.Tables = append(s.Tables, limitsStats(entry.Type.(Table).Limits, true))
+				}
+			}
+		case "memory":
+			entries, _ := section["entries"].([]MemLimits)
+			for _, entry := range entries {
+				s.Memories = append(s.Memories, limitsStats(entry, false))
+			}
+		case "table":
+			entries, _ := section["entries"].([]Table)
+			for _, entry := range entries {
+				s.Tables = append(s.Tables, limitsStats(entry.Limits, false))
+			}
+		case "code":
+			entries, _ := section["entries"].([]CodeBody)
+			for i, entry := range entries {
+				f := s.funcStats(entry)
+				f.Index = importedFuncs + uint32(i)
+				if i < len(offsets) {
+					f.Size = int(offsets[i].End - offsets[i].Start)
+				}
+				s.Functions = append(s.Functions, f)
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *Stats) funcStats(body CodeBody) FuncStats {
+	f := FuncStats{Ops: len(body.Code)}
+	for _, local := range body.Locals {
+		f.Locals += int(local.Count)
+	}
+	depth := 0
+	for _, op := range body.Code {
+		s.Opcodes[fullOpName(op)]++
+		switch op.Name {
+		case "block", "loop", "if", "try", "try_table":
+			depth++
+			if depth > f.Depth {
+				f.Depth = depth
+			}
+		case "end", "delegate":
+			depth--
+		}
+	}
+	if f.Depth > s.MaxDepth {
+		s.MaxDepth = f.Depth
+	}
+	return f
+}
+
+func limitsStats(limits MemLimits, imported bool) LimitsStats {
+	l := LimitsStats{Imported: imported, Initial: limits.Intial}
+	if maximum, exist := limits.Maximum.(uint64); exist {
+		l.Maximum = &maximum
+	}
+	return l
+}
+
+// Add adds the stats of other modules, i.e. to describe a corpus.
+func (s *Stats) Add(other *Stats) {
+	s.Modules += other.Modules
+	s.Size += other.Size
+	if s.Sections == nil {
+		s.Sections = map[string]int{}
+	}
+	for name, size := range other.Sections {
+		s.Sections[name] += size
+	}
+	if s.Opcodes == nil {
+		s.Opcodes = map[string]int{}
+	}
+	for name, count := range other.Opcodes {
+		s.Opcodes[name] += count
+	}
+	s.Functions = append(s.Functions, other.Functions...)
+	if other.MaxDepth > s.MaxDepth {
+		s.MaxDepth = other.MaxDepth
+	}
+	s.Memories = append(s.Memories, other.Memories...)
+	s.Tables = append(s.Tables, other.Tables...)
+}
+
+// JSON returns the stats as indented JSON.
+func (s *Stats) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
@@ -38,21 +38,18 @@ func Text2Json(text string) (res []JSON) {
 		textOp := textArr.shift()
 		jsonOp := make(JSON)
 
-		opArr := strings.Split(textOp, ".") // [type, name]
-		typ := opArr[0]
-		name := typ
+		opArr := strings.SplitN(textOp, ".", 2) // [type, name]
+		var typ string
+		name := opArr[0]
 		if len(opArr) > 1 {
+			typ = opArr[0]
 			name = opArr[1]
 			jsonOp["return_type"] = typ
 		}
 
 		jsonOp["name"] = name
 
-		key := name
-		if name == "const" {
-			key = jsonOp["return_type"].(string)
-		}
-		immediate, exist := OP_IMMEDIATES[key]
+		immediate, exist := LookupImmediates(typ, name)
 		if exist {
 			jsonOp["immediates"] = immediataryParser(immediate, textArr)
 		}
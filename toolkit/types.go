@@ -25,16 +25,156 @@ var OP_IMMEDIATES = map[string]string{
 	"store8":         "memory_immediate",
 	"store16":        "memory_immediate",
 	"store32":        "memory_immediate",
-	"current_memory": "varuint1",
-	"grow_memory":    "varuint1",
+	"current_memory": "varuint32",
+	"grow_memory":    "varuint32",
 	"i32":            "varint32",
 	"i64":            "varint64",
 	"f32":            "uint32",
 	"f64":            "uint64",
+
+	// tail calls.
+	"return_call":          "varuint32",
+	"return_call_indirect": "call_indirect",
+
+	// exception handling.
+	"try":       "block_type",
+	"catch":     "varuint32",
+	"throw":     "varuint32",
+	"rethrow":   "varuint32",
+	"delegate":  "varuint32",
+	"try_table": "try_table",
+
+	// bulk memory operators, looked up by their full name as `drop` is ambiguous.
+	"memory.init": "memory_init",
+	"data.drop":   "varuint32",
+	"memory.copy": "copy",
+	"memory.fill": "varuint32",
+	"table.init":  "table_init",
+	"elem.drop":   "varuint32",
+	"table.copy":  "copy",
+
+	// atomic memory accesses.
+	"atomic.notify":          "memory_immediate",
+	"atomic.wait32":          "memory_immediate",
+	"atomic.wait64":          "memory_immediate",
+	"fence":                  "varuint1",
+	"atomic.load":            "memory_immediate",
+	"atomic.load8_u":         "memory_immediate",
+	"atomic.load16_u":        "memory_immediate",
+	"atomic.load32_u":        "memory_immediate",
+	"atomic.store":           "memory_immediate",
+	"atomic.store8":          "memory_immediate",
+	"atomic.store16":         "memory_immediate",
+	"atomic.store32":         "memory_immediate",
+	"atomic.rmw.add":         "memory_immediate",
+	"atomic.rmw8.add_u":      "memory_immediate",
+	"atomic.rmw16.add_u":     "memory_immediate",
+	"atomic.rmw32.add_u":     "memory_immediate",
+	"atomic.rmw.sub":         "memory_immediate",
+	"atomic.rmw8.sub_u":      "memory_immediate",
+	"atomic.rmw16.sub_u":     "memory_immediate",
+	"atomic.rmw32.sub_u":     "memory_immediate",
+	"atomic.rmw.and":         "memory_immediate",
+	"atomic.rmw8.and_u":      "memory_immediate",
+	"atomic.rmw16.and_u":     "memory_immediate",
+	"atomic.rmw32.and_u":     "memory_immediate",
+	"atomic.rmw.or":          "memory_immediate",
+	"atomic.rmw8.or_u":       "memory_immediate",
+	"atomic.rmw16.or_u":      "memory_immediate",
+	"atomic.rmw32.or_u":      "memory_immediate",
+	"atomic.rmw.xor":         "memory_immediate",
+	"atomic.rmw8.xor_u":      "memory_immediate",
+	"atomic.rmw16.xor_u":     "memory_immediate",
+	"atomic.rmw32.xor_u":     "memory_immediate",
+	"atomic.rmw.xchg":        "memory_immediate",
+	"atomic.rmw8.xchg_u":     "memory_immediate",
+	"atomic.rmw16.xchg_u":    "memory_immediate",
+	"atomic.rmw32.xchg_u":    "memory_immediate",
+	"atomic.rmw.cmpxchg":     "memory_immediate",
+	"atomic.rmw8.cmpxchg_u":  "memory_immediate",
+	"atomic.rmw16.cmpxchg_u": "memory_immediate",
+	"atomic.rmw32.cmpxchg_u": "memory_immediate",
+
+	// reference types and typed function references.
+	"select_t":        "select_t",
+	"table.get":       "varuint32",
+	"table.set":       "varuint32",
+	"table.grow":      "varuint32",
+	"table.size":      "varuint32",
+	"table.fill":      "varuint32",
+	"ref.null":        "heap_type",
+	"ref.func":        "varuint32",
+	"call_ref":        "varuint32",
+	"return_call_ref": "varuint32",
+	"br_on_null":      "varuint32",
+	"br_on_non_null":  "varuint32",
+
+	// garbage collection, looked up by their full name as `get` and `set` are ambiguous.
+	"struct.new":         "varuint32",
+	"struct.new_default": "varuint32",
+	"struct.get":         "field",
+	"struct.get_s":       "field",
+	"struct.get_u":       "field",
+	"struct.set":         "field",
+	"array.new":          "varuint32",
+	"array.new_default":  "varuint32",
+	"array.new_fixed":    "array_fixed",
+	"array.new_data":     "array_data",
+	"array.new_elem":     "array_elem",
+	"array.get":          "varuint32",
+	"array.get_s":        "varuint32",
+	"array.get_u":        "varuint32",
+	"array.set":          "varuint32",
+	"array.fill":         "varuint32",
+	"array.copy":         "copy",
+	"array.init_data":    "array_data",
+	"array.init_elem":    "array_elem",
+	"ref.test":           "heap_type",
+	"ref.test_null":      "heap_type",
+	"ref.cast":           "heap_type",
+	"ref.cast_null":      "heap_type",
+	"br_on_cast":         "br_on_cast",
+	"br_on_cast_fail":    "br_on_cast",
 }
 
 type JSON = map[string]interface{}
 
+// LookupImmediates returns the kind of the immediates of an operator. The operators are looked up by
+// their full name first (i.e. `data.drop`), then by their name and the constants by their type.
+func LookupImmediates(returnType, name string) (string, bool) {
+	if returnType != "" {
+		if immediates, exist := OP_IMMEDIATES[returnType+"."+name]; exist {
+			return immediates, true
+		}
+	}
+	key := name
+	if name == "const" {
+		key = returnType
+	}
+	immediates, exist := OP_IMMEDIATES[key]
+	return immediates, exist
+}
+
+// Prefix bytes of the operators that are encoded with more than one byte.
+const (
+	MISC_PREFIX   = 0xfc // saturating truncations and bulk memory operators.
+	ATOMIC_PREFIX = 0xfe // threads proposal.
+	GC_PREFIX     = 0xfb // garbage collection proposal.
+)
+
+// Type constructors of the reference types that aren't abbreviated, they are followed by a heap type.
+const (
+	REF_TYPE      = 0x64 // (ref ht)
+	REF_NULL_TYPE = 0x63 // (ref null ht)
+)
+
+// Flags of resizable_limits.
+const (
+	LIMITS_FLAG_MAXIMUM = 0x01 // the limits have a maximum.
+	LIMITS_FLAG_SHARED  = 0x02 // the memory is shared between threads (threads proposal).
+	LIMITS_FLAG_INDEX64 = 0x04 // the memory is indexed with i64 addresses (memory64 proposal).
+)
+
 type SectionHeader struct {
 	Id   byte   `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
@@ -46,6 +186,10 @@ type OP struct {
 	ReturnType string      `json:"return_type,omitempty"`
 	Type       string      `json:"type,omitempty"`
 	Immediates interface{} `json:"immediates,omitempty"`
+
+	// Widths are the encoded sizes of the LEB128 numbers of the operator, kept only when some are
+	// padded (i.e. the relocatable indices of object files) so that the operator is encoded back as is.
+	Widths []byte `json:"widths,omitempty"`
 }
 
 type Table struct {
@@ -59,6 +203,40 @@ type MemLimits struct {
 	Maximum interface{} `json:"maximum,omitempty"` // to distinguish the field is nil or uint64(0)
 }
 
+// Flags of the memory immediates.
+const (
+	MEMORY_FLAG_INDEX = 0x40 // the memory immediate has an explicit memory index (multi-memory proposal).
+)
+
+// Flags of the data segments.
+const (
+	DATA_FLAG_PASSIVE = 0x01 // the segment is only copied by `memory.init`.
+	DATA_FLAG_INDEX   = 0x02 // the segment has an explicit memory index.
+)
+
+// Flags of the element segments.
+const (
+	ELEM_FLAG_PASSIVE = 0x01 // the segment isn't copied to a table at instantiation.
+	ELEM_FLAG_INDEX   = 0x02 // the active segment has an explicit table index, the passive one is declarative.
+	ELEM_FLAG_EXPRS   = 0x04 // the elements are constant expressions instead of function indices.
+)
+
+// IsShared reports whether the limits describe a shared memory.
+func (m MemLimits) IsShared() bool {
+	return m.Flags&LIMITS_FLAG_SHARED != 0
+}
+
+// Is64 reports whether the limits describe a 64-bit memory, whose addresses and page counts are i64.
+func (m MemLimits) Is64() bool {
+	return m.Flags&LIMITS_FLAG_INDEX64 != 0
+}
+
+// Tag is the type of an exception tag, the attribute is always 0 (exception).
+type Tag struct {
+	Attribute byte   `json:"attribute,omitempty"`
+	Type      uint32 `json:"type,omitempty"`
+}
+
 type Global struct {
 	ContentType string `json:"content_type,omitempty"`
 	Mutability  byte   `json:"mutability,omitempty"`
@@ -71,10 +249,24 @@ type CustomSec struct {
 	Payload     string `json:"payload,omitempty"`
 }
 
+// TypeEntry is a function, struct or array type. The value types are named as in the text format
+// when they aren't abbreviated, i.e. `(ref null 3)` or `(ref func)`.
 type TypeEntry struct {
 	Form       string   `json:"form,omitempty"`
 	Params     []string `json:"params"`
 	ReturnType string   `json:"return_type,omitempty"`
+
+	// garbage collection proposal.
+	Fields     []FieldType `json:"fields,omitempty"`     // the fields of a struct, the element of an array.
+	Sub        string      `json:"sub,omitempty"`        // `sub` or `sub_final` when the supertypes are declared.
+	Supertypes []uint32    `json:"supertypes,omitempty"` // indices of the supertypes.
+	RecGroup   uint32      `json:"rec_group,omitempty"`  // the number of types of the rec group starting at this entry.
+}
+
+// FieldType is a field of a struct or the element of an array, the packed types are `i8` and `i16`.
+type FieldType struct {
+	Type       string `json:"type,omitempty"`
+	Mutability byte   `json:"mutability,omitempty"`
 }
 
 type TypeSec struct {
@@ -111,7 +303,7 @@ type MemSec struct {
 
 type GlobalEntry struct {
 	Type Global `json:"type,omitempty"`
-	Init OP     `json:"init,omitempty"`
+	Init []OP   `json:"init,omitempty"`
 }
 
 type GlobalSec struct {
@@ -136,9 +328,12 @@ type StartSec struct {
 }
 
 type ElementEntry struct {
+	Flags    uint32   `json:"flags,omitempty"`
 	Index    uint32   `json:"index,omitempty"`
-	Offset   OP       `json:"offset,omitempty"`
+	Offset   []OP     `json:"offset,omitempty"`
+	Type     string   `json:"type,omitempty"` // the reference type of the expressions.
 	Elements []uint64 `json:"elements"`
+	Exprs    [][]OP   `json:"exprs,omitempty"`
 }
 
 type ElementSec struct {
@@ -157,16 +352,28 @@ type CodeBody struct {
 }
 
 type CodeSec struct {
-	Name    string     `json:"name,omitempty"`
-	Entries []CodeBody `json:"entries"`
+	Name    string        `json:"name,omitempty"`
+	Entries []CodeBody    `json:"entries"`
+	Offsets []FuncOffsets `json:"-"` // the offsets of the entries in the payload of the section, as decoded.
+}
+
+type TagSec struct {
+	Name    string `json:"name,omitempty"`
+	Entries []Tag  `json:"entries"`
 }
 
 type DataSegment struct {
+	Flags  uint32 `json:"flags,omitempty"`
 	Index  uint32 `json:"index,omitempty"`
-	Offset OP     `json:"offset,omitempty"`
+	Offset []OP   `json:"offset,omitempty"`
 	Data   []byte `json:"data"`
 }
 
+type DataCountSec struct {
+	Name  string `json:"name,omitempty"`
+	Count uint32 `json:"count,omitempty"`
+}
+
 type DataSec struct {
 	Name    string        `json:"name,omitempty"`
 	Entries []DataSegment `json:"entries"`
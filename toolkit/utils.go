@@ -12,6 +12,11 @@ type Stream struct {
 	bytesRead  int
 	bytesWrote int
 	buffer     *bytes.Buffer
+
+	// the widths of the LEB128 numbers of the operator being parsed or generated (see OP.Widths).
+	recording bool
+	padded    bool
+	widths    []byte
 }
 
 func NewStream(buf []byte) *Stream {
@@ -28,6 +33,14 @@ func (s *Stream) ReadByte() byte {
 	return b
 }
 
+// PeekByte returns the next byte from the buffer without consuming it.
+func (s *Stream) PeekByte() byte {
+	if s.buffer.Len() == 0 {
+		return 0
+	}
+	return s.buffer.Bytes()[0]
+}
+
 // Read returns a slice containing the next n bytes from the buffer.
 func (s *Stream) Read(n int) []byte {
 	s.bytesRead += n
@@ -62,6 +75,77 @@ func (s *Stream) WriteByte(c byte) error {
 	return nil
 }
 
+// startWidths starts recording the widths of the LEB128 numbers read from the stream.
+func (s *Stream) startWidths() {
+	s.recording = true
+	s.padded = false
+	s.widths = nil
+}
+
+// stopWidths stops the recording and returns the widths if one of the numbers is padded.
+func (s *Stream) stopWidths() []byte {
+	widths := s.widths
+	if !s.padded {
+		widths = nil
+	}
+	s.recording = false
+	s.padded = false
+	s.widths = nil
+	return widths
+}
+
+func (s *Stream) recordWidth(width, minimal int) {
+	s.widths = append(s.widths, byte(width))
+	if width > minimal {
+		s.padded = true
+	}
+}
+
+// nextWidth returns the width of the next LEB128 number to write, 0 if it's written as is.
+func (s *Stream) nextWidth() int {
+	if len(s.widths) == 0 {
+		return 0
+	}
+	width := int(s.widths[0])
+	s.widths = s.widths[1:]
+	return width
+}
+
+// uleb128Len returns the number of bytes of the shortest unsigned LEB128 encoding of v.
+func uleb128Len(v uint64) int {
+	n := 1
+	for v >>= 7; v != 0; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// sleb128Len returns the number of bytes of the shortest signed LEB128 encoding of v.
+func sleb128Len(v int64) int {
+	n := 1
+	for v < -64 || v > 63 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// padLEB128 pads a LEB128 number to the given width with the continuation bit set on its bytes.
+func padLEB128(out []byte, width int, negative bool) []byte {
+	fill, last := byte(0x80), byte(0x00)
+	if negative {
+		fill, last = 0xff, 0x7f
+	}
+	if len(out) >= width {
+		return out
+	}
+	out[len(out)-1] |= 0x80
+	for len(out) < width-1 {
+		out = append(out, fill)
+	}
+	return append(out, last)
+}
+
 // EncodeULEB128 appends v to b using unsigned LEB128 encoding.
 func EncodeULEB128(v uint64, stream *Stream) (out []byte) {
 	for {
@@ -75,6 +159,7 @@ func EncodeULEB128(v uint64, stream *Stream) (out []byte) {
 			break
 		}
 	}
+	out = padLEB128(out, stream.nextWidth(), false)
 	stream.Write(out)
 	return
 }
@@ -97,6 +182,7 @@ func EncodeSLEB128(v int64, stream *Stream) (out []byte) {
 		}
 	}
 
+	out = padLEB128(out, stream.nextWidth(), out[len(out)-1]&0x40 != 0)
 	stream.Write(out)
 	return out
 }
@@ -104,6 +190,7 @@ func EncodeSLEB128(v int64, stream *Stream) (out []byte) {
 // DecodeULEB128 decodes bytes from stream with unsigned LEB128 encoding.
 func DecodeULEB128(stream *Stream) (u uint64) {
 	var shift uint
+	start := stream.bytesRead
 	for {
 		b := stream.ReadByte()
 		u |= uint64(b&0x7f) << shift
@@ -113,12 +200,21 @@ func DecodeULEB128(stream *Stream) (u uint64) {
 		shift += 7
 	}
 
+	if stream.recording {
+		stream.recordWidth(stream.bytesRead-start, uleb128Len(u))
+	}
 	return
 }
 
 // DecodeSLEB128 decodes bytes from stream with signed LEB128 encoding.
 func DecodeSLEB128(stream *Stream) (s int64) {
 	var shift uint
+	start := stream.bytesRead
+	defer func() {
+		if stream.recording {
+			stream.recordWidth(stream.bytesRead-start, sleb128Len(s))
+		}
+	}()
 	for {
 		b := stream.ReadByte()
 		s |= int64(b&0x7f) << shift
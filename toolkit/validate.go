@@ -0,0 +1,1126 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxPages32 = uint64(1) << 16 // 4GiB of 64KiB pages.
+	maxPages64 = uint64(1) << 48 // 2^64 bytes of 64KiB pages.
+)
+
+// moduleContext collects the index spaces of a module that its code is validated against.
+type moduleContext struct {
+	types    []TypeEntry
+	funcs    []uint64 // the type index of every function, imports first.
+	tables   []Table
+	memories []MemLimits
+	globals  []Global
+	tags     []Tag
+	elements int
+	data     int
+
+	importedGlobals int
+	hasDataCount    bool
+	strict          bool // the operators the validator doesn't model are errors (see ValidateModuleStrict).
+}
+
+func newModuleContext(module []JSON) *moduleContext {
+	ctx := &moduleContext{}
+	for _, section := range module {
+		switch section["name"] {
+		case "type":
+			ctx.types, _ = section["entries"].([]TypeEntry)
+		case "import":
+			entries, _ := section["entries"].([]ImportEntry)
+			for _, entry := range entries {
+				switch entry.Kind {
+				case "function":
+					ctx.funcs = append(ctx.funcs, entry.Type.(uint64))
+				case "table":
+					ctx.tables = append(ctx.tables, entry.Type.(Table))
+				case "memory":
+					ctx.memories = append(ctx.memories, entry.Type.(MemLimits))
+				case "global":
+					ctx.globals = append(ctx.globals, entry.Type.(Global))
+					ctx.importedGlobals += 1
+				case "tag":
+					ctx.tags = append(ctx.tags, entry.Type.(Tag))
+				}
+			}
+		case "function":
+			entries, _ := section["entries"].([]uint64)
+			ctx.funcs = append(ctx.funcs, entries...)
+		case "table":
+			entries, _ := section["entries"].([]Table)
+			ctx.tables = append(ctx.tables, entries...)
+		case "memory":
+			entries, _ := section["entries"].([]MemLimits)
+			ctx.memories = append(ctx.memories, entries...)
+		case "global":
+			entries, _ := section["entries"].([]GlobalEntry)
+			for _, entry := range entries {
+				ctx.globals = append(ctx.globals, entry.Type)
+			}
+		case "tag":
+			entries, _ := section["entries"].([]Tag)
+			ctx.tags = append(ctx.tags, entries...)
+		case "element":
+			entries, _ := section["entries"].([]ElementEntry)
+			ctx.elements = len(entries)
+		case "datacount":
+			ctx.data = int(section["count"].(uint32))
+			ctx.hasDataCount = true
+		}
+	}
+	return ctx
+}
+
+func (ctx *moduleContext) funcType(index uint64) (TypeEntry, error) {
+	if index >= uint64(len(ctx.funcs)) {
+		return TypeEntry{}, fmt.Errorf("unknown function %d", index)
+	}
+	return ctx.typeEntry(ctx.funcs[index])
+}
+
+func (ctx *moduleContext) typeEntry(index uint64) (TypeEntry, error) {
+	if index >= uint64(len(ctx.types)) {
+		return TypeEntry{}, fmt.Errorf("unknown type %d", index)
+	}
+	return ctx.types[index], nil
+}
+
+func (ctx *moduleContext) tagParams(index uint64) ([]string, error) {
+	if index >= uint64(len(ctx.tags)) {
+		return nil, fmt.Errorf("unknown tag %d", index)
+	}
+	typ, err := ctx.typeEntry(uint64(ctx.tags[index].Type))
+	if err != nil {
+		return nil, err
+	}
+	return typ.Params, nil
+}
+
+func (ctx *moduleContext) memory(index uint64) (MemLimits, error) {
+	if index >= uint64(len(ctx.memories)) {
+		return MemLimits{}, fmt.Errorf("unknown memory %d", index)
+	}
+	return ctx.memories[index], nil
+}
+
+// addressType returns the type of the addresses of a memory, `i64` for memory64 and `i32` otherwise.
+func (ctx *moduleContext) addressType(index uint64) (string, error) {
+	mem, err := ctx.memory(index)
+	if err != nil {
+		return "", err
+	}
+	if mem.Is64() {
+		return "i64", nil
+	}
+	return "i32", nil
+}
+
+// ValidateModule checks that a module decoded by Wasm2Json is valid: the index spaces are in
+// bounds, the limits are consistent and the code of every function is well-typed. The reference and
+// garbage collection operators aren't type-checked though, the code following them in their block is
+// only checked like the code following a branch: a module using them may be invalid even if it passes.
+// ValidateModuleStrict tells whether the whole module was checked.
+func ValidateModule(module []JSON) error {
+	return validateModule(module, false)
+}
+
+// ValidateModuleStrict checks a module like ValidateModule, the operators it doesn't type-check are
+// errors, i.e. to prove that a generated or transformed module is valid.
+func ValidateModuleStrict(module []JSON) error {
+	return validateModule(module, true)
+}
+
+func validateModule(module []JSON, strict bool) error {
+	ctx := newModuleContext(module)
+	ctx.strict = strict
+
+	for i, entry := range ctx.funcs {
+		if _, err := ctx.typeEntry(entry); err != nil {
+			return fmt.Errorf("function %d: %v", i, err)
+		}
+	}
+	for i, tag := range ctx.tags {
+		typ, err := ctx.typeEntry(uint64(tag.Type))
+		if err != nil {
+			return fmt.Errorf("tag %d: %v", i, err)
+		}
+		if typ.ReturnType != "" {
+			return fmt.Errorf("tag %d: the type of a tag can't have results", i)
+		}
+	}
+	for i, table := range ctx.tables {
+		if err := validateLimits(table.Limits, ^uint64(0)); err != nil {
+			return fmt.Errorf("table %d: %v", i, err)
+		}
+	}
+	for i, mem := range ctx.memories {
+		max := maxPages32
+		if mem.Is64() {
+			max = maxPages64
+		}
+		if err := validateLimits(mem, max); err != nil {
+			return fmt.Errorf("memory %d: %v", i, err)
+		}
+		if mem.IsShared() && mem.Maximum == nil {
+			return fmt.Errorf("memory %d: a shared memory must have a maximum", i)
+		}
+	}
+
+	globals := 0
+	for _, section := range module {
+		switch section["name"] {
+		case "global":
+			entries, _ := section["entries"].([]GlobalEntry)
+			for _, entry := range entries {
+				// only the imported globals can be referred to by the initializers.
+				if err := validateInitExpr(ctx, entry.Init, entry.Type.ContentType); err != nil {
+					return fmt.Errorf("global %d: %v", ctx.importedGlobals+globals, err)
+				}
+				globals += 1
+			}
+		case "export":
+			if err := validateExports(ctx, section); err != nil {
+				return err
+			}
+		case "start":
+			typ, err := ctx.funcType(uint64(section["index"].(uint32)))
+			if err != nil {
+				return fmt.Errorf("start: %v", err)
+			}
+			if len(typ.Params) != 0 || typ.ReturnType != "" {
+				return fmt.Errorf("start: the start function can't have params or results")
+			}
+		case "element":
+			entries, _ := section["entries"].([]ElementEntry)
+			for i, entry := range entries {
+				if entry.Flags&ELEM_FLAG_PASSIVE == 0 {
+					if uint64(entry.Index) >= uint64(len(ctx.tables)) {
+						return fmt.Errorf("element %d: unknown table %d", i, entry.Index)
+					}
+					if err := validateInitExpr(ctx, entry.Offset, "i32"); err != nil {
+						return fmt.Errorf("element %d: %v", i, err)
+					}
+				}
+				for _, expr := range entry.Exprs {
+					if err := validateElemExpr(ctx, expr); err != nil {
+						return fmt.Errorf("element %d: %v", i, err)
+					}
+				}
+				for _, elem := range entry.Elements {
+					if elem >= uint64(len(ctx.funcs)) {
+						return fmt.Errorf("element %d: unknown function %d", i, elem)
+					}
+				}
+			}
+		case "data":
+			entries, _ := section["entries"].([]DataSegment)
+			if ctx.hasDataCount && ctx.data != len(entries) {
+				return fmt.Errorf("data: %d segments, the data count is %d", len(entries), ctx.data)
+			}
+			for i, entry := range entries {
+				if entry.Flags&DATA_FLAG_PASSIVE != 0 {
+					continue
+				}
+				addrType, err := ctx.addressType(uint64(entry.Index))
+				if err != nil {
+					return fmt.Errorf("data %d: %v", i, err)
+				}
+				if err := validateInitExpr(ctx, entry.Offset, addrType); err != nil {
+					return fmt.Errorf("data %d: %v", i, err)
+				}
+			}
+		case "code":
+			entries, _ := section["entries"].([]CodeBody)
+			imported := len(ctx.funcs) - len(entries)
+			if imported < 0 {
+				return fmt.Errorf("code: %d bodies for %d functions", len(entries), len(ctx.funcs))
+			}
+			for i, entry := range entries {
+				if err := validateCode(ctx, uint64(imported+i), entry); err != nil {
+					return fmt.Errorf("function %d: %v", imported+i, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// CodeValidator type-checks the bodies of the functions of a module like ValidateModule, i.e. to check the
+// edits of a body without validating the whole module again. The module mustn't change but for its bodies.
+type CodeValidator struct {
+	ctx      *moduleContext
+	imported int
+}
+
+func NewCodeValidator(module []JSON) *CodeValidator {
+	ctx := newModuleContext(module)
+	defined := 0
+	for _, section := range module {
+		if section["name"] == "code" {
+			entries, _ := section["entries"].([]CodeBody)
+			defined = len(entries)
+		}
+	}
+	return &CodeValidator{ctx: ctx, imported: len(ctx.funcs) - defined}
+}
+
+// Validate type-checks the body of the function at a position of the code section.
+func (c *CodeValidator) Validate(i int, body CodeBody) error {
+	index := c.imported + i
+	if i < 0 || index < 0 || index >= len(c.ctx.funcs) {
+		return fmt.Errorf("code: no function %d", index)
+	}
+	if err := validateCode(c.ctx, uint64(index), body); err != nil {
+		return fmt.Errorf("function %d: %v", index, err)
+	}
+	return nil
+}
+
+func validateLimits(limits MemLimits, max uint64) error {
+	if limits.Intial > max {
+		return fmt.Errorf("initial size %d is larger than %d", limits.Intial, max)
+	}
+	if limits.Maximum != nil {
+		maximum := limits.Maximum.(uint64)
+		if maximum > max {
+			return fmt.Errorf("maximum size %d is larger than %d", maximum, max)
+		}
+		if limits.Intial > maximum {
+			return fmt.Errorf("initial size %d is larger than the maximum %d", limits.Intial, maximum)
+		}
+	}
+	return nil
+}
+
+func validateExports(ctx *moduleContext, section JSON) error {
+	entries, _ := section["entries"].([]ExportEntry)
+	names := make(map[string]struct{})
+	for _, entry := range entries {
+		if _, exist := names[entry.FieldStr]; exist {
+			return fmt.Errorf("export %q: duplicated export name", entry.FieldStr)
+		}
+		names[entry.FieldStr] = struct{}{}
+
+		var count int
+		switch entry.Kind {
+		case "function":
+			count = len(ctx.funcs)
+		case "table":
+			count = len(ctx.tables)
+		case "memory":
+			count = len(ctx.memories)
+		case "global":
+			count = len(ctx.globals)
+		case "tag":
+			count = len(ctx.tags)
+		}
+		if uint64(entry.Index) >= uint64(count) {
+			return fmt.Errorf("export %q: unknown %s %d", entry.FieldStr, entry.Kind, entry.Index)
+		}
+	}
+	return nil
+}
+
+var constOps = map[string]struct{}{
+	"i32.const":  {},
+	"i64.const":  {},
+	"f32.const":  {},
+	"f64.const":  {},
+	"get_global": {},
+
+	// extended-const.
+	"i32.add": {},
+	"i32.sub": {},
+	"i32.mul": {},
+	"i64.add": {},
+	"i64.sub": {},
+	"i64.mul": {},
+}
+
+// validateInitExpr checks that an initializer is a constant expression of the expected type.
+func validateInitExpr(ctx *moduleContext, expr []OP, expected string) error {
+	v := &funcValidator{ctx: ctx}
+	v.pushCtrl("init", nil, []string{expected})
+	for _, op := range expr {
+		if _, exist := constOps[fullOpName(op)]; !exist {
+			return fmt.Errorf("operator %s is not constant", fullOpName(op))
+		}
+		if op.Name == "get_global" {
+			index := op.Immediates.(uint32)
+			if int(index) >= ctx.importedGlobals {
+				return fmt.Errorf("initializer refers to the non-imported global %d", index)
+			}
+			if ctx.globals[index].Mutability != 0 {
+				return fmt.Errorf("initializer refers to the mutable global %d", index)
+			}
+		}
+		if err := v.validateOp(op); err == errUnmodeled && !ctx.strict {
+			v.setUnreachable()
+		} else if err != nil {
+			return fmt.Errorf("initializer: %v", err)
+		}
+	}
+	if _, err := v.popCtrl(); err != nil {
+		return fmt.Errorf("initializer: %v", err)
+	}
+	return nil
+}
+
+// validateElemExpr checks that the expression of an element is constant and refers to known functions,
+// the reference types aren't type-checked.
+func validateElemExpr(ctx *moduleContext, expr []OP) error {
+	for _, op := range expr {
+		switch fullOpName(op) {
+		case "ref.null":
+		case "ref.func":
+			if int(op.Immediates.(uint32)) >= len(ctx.funcs) {
+				return fmt.Errorf("unknown function %d", op.Immediates.(uint32))
+			}
+		case "get_global":
+			if int(op.Immediates.(uint32)) >= ctx.importedGlobals {
+				return fmt.Errorf("initializer refers to the non-imported global %d", op.Immediates.(uint32))
+			}
+		default:
+			return fmt.Errorf("operator %s is not constant", fullOpName(op))
+		}
+	}
+	return nil
+}
+
+// ctrlFrame is an entry of the control stack of a function being validated.
+type ctrlFrame struct {
+	opcode      string
+	params      []string
+	results     []string
+	height      int
+	unreachable bool
+}
+
+// funcValidator type-checks the code of a function with the algorithm of the validation appendix of the spec.
+// An empty type on the operand stack is unknown, it is pushed by the code following an unconditional branch.
+type funcValidator struct {
+	ctx     *moduleContext
+	locals  []string
+	results []string
+	vals    []string
+	ctrls   []ctrlFrame
+}
+
+func (v *funcValidator) pushVal(typ string) {
+	v.vals = append(v.vals, typ)
+}
+
+func (v *funcValidator) popVal() (string, error) {
+	frame := v.ctrls[len(v.ctrls)-1]
+	if len(v.vals) == frame.height {
+		if frame.unreachable {
+			return "", nil
+		}
+		return "", fmt.Errorf("operand stack underflow")
+	}
+	typ := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+	return typ, nil
+}
+
+func (v *funcValidator) popExpect(expected string) (string, error) {
+	actual, err := v.popVal()
+	if err != nil {
+		return "", err
+	}
+	if actual == "" {
+		return expected, nil
+	}
+	if expected != "" && actual != expected {
+		return "", fmt.Errorf("type mismatch: expected %s, got %s", expected, actual)
+	}
+	return actual, nil
+}
+
+func (v *funcValidator) pushVals(types []string) {
+	v.vals = append(v.vals, types...)
+}
+
+func (v *funcValidator) popVals(types []string) error {
+	for i := len(types) - 1; i >= 0; i-- {
+		if _, err := v.popExpect(types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *funcValidator) pushCtrl(opcode string, params, results []string) {
+	v.ctrls = append(v.ctrls, ctrlFrame{
+		opcode:  opcode,
+		params:  params,
+		results: results,
+		height:  len(v.vals),
+	})
+	v.pushVals(params)
+}
+
+func (v *funcValidator) popCtrl() (ctrlFrame, error) {
+	if len(v.ctrls) == 0 {
+		return ctrlFrame{}, fmt.Errorf("control stack underflow")
+	}
+	frame := v.ctrls[len(v.ctrls)-1]
+	if err := v.popVals(frame.results); err != nil {
+		return frame, err
+	}
+	if len(v.vals) != frame.height {
+		return frame, fmt.Errorf("%d values left on the operand stack", len(v.vals)-frame.height)
+	}
+	v.ctrls = v.ctrls[:len(v.ctrls)-1]
+	return frame, nil
+}
+
+func (v *funcValidator) label(depth uint64) (ctrlFrame, error) {
+	if depth >= uint64(len(v.ctrls)) {
+		return ctrlFrame{}, fmt.Errorf("unknown label %d", depth)
+	}
+	return v.ctrls[len(v.ctrls)-1-int(depth)], nil
+}
+
+func (v *funcValidator) labelTypes(depth uint64) ([]string, error) {
+	frame, err := v.label(depth)
+	if err != nil {
+		return nil, err
+	}
+	if frame.opcode == "loop" {
+		return frame.params, nil
+	}
+	return frame.results, nil
+}
+
+func (v *funcValidator) setUnreachable() {
+	frame := &v.ctrls[len(v.ctrls)-1]
+	v.vals = v.vals[:frame.height]
+	frame.unreachable = true
+}
+
+// validateCode type-checks the body of the function `index`.
+func validateCode(ctx *moduleContext, index uint64, body CodeBody) error {
+	typ, err := ctx.funcType(index)
+	if err != nil {
+		return err
+	}
+
+	v := &funcValidator{
+		ctx:    ctx,
+		locals: append([]string{}, typ.Params...),
+	}
+	for _, local := range body.Locals {
+		for i := uint32(0); i < local.Count; i++ {
+			v.locals = append(v.locals, local.Type)
+		}
+	}
+	if typ.ReturnType != "" {
+		v.results = []string{typ.ReturnType}
+	}
+	v.pushCtrl("function", nil, v.results)
+
+	for i, op := range body.Code {
+		if len(v.ctrls) == 0 {
+			return fmt.Errorf("op %d (%s): operator after the end of the function", i, op.Name)
+		}
+		if err := v.validateOp(op); err == errUnmodeled && !ctx.strict {
+			v.setUnreachable()
+		} else if err != nil {
+			return fmt.Errorf("op %d (%s): %v", i, fullOpName(op), err)
+		}
+	}
+	if len(v.ctrls) != 0 {
+		return fmt.Errorf("missing end of the function")
+	}
+	return nil
+}
+
+// isKnownOp reports whether an operator is in the opcode tables.
+func isKnownOp(name string) bool {
+	if _, exist := J2W_OPCODES[name]; exist {
+		return true
+	}
+	_, _, exist := lookupPrefixedOpcode(name)
+	return exist
+}
+
+// fullOpName returns the name of an operator as it's written in the opcode tables, i.e. `i32.add`.
+func fullOpName(op OP) string {
+	if op.ReturnType != "" {
+		return op.ReturnType + "." + op.Name
+	}
+	return op.Name
+}
+
+// enterBlock pushes the control frame of a block, whose type is either a single result or
+// the index of a function type (i.e. `(type 2)`) giving the params and the results.
+func (v *funcValidator) enterBlock(opcode, blockType string) error {
+	var params, results []string
+	switch {
+	case blockType == "block_type":
+	case strings.HasPrefix(blockType, "(type "):
+		index, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(blockType, "(type "), ")"), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid block type %s", blockType)
+		}
+		typ, err := v.ctx.typeEntry(index)
+		if err != nil {
+			return err
+		}
+		params = typ.Params
+		if typ.ReturnType != "" {
+			results = []string{typ.ReturnType}
+		}
+	default:
+		results = []string{blockType}
+	}
+
+	if err := v.popVals(params); err != nil {
+		return err
+	}
+	v.pushCtrl(opcode, params, results)
+	return nil
+}
+
+func (v *funcValidator) validateOp(op OP) error {
+	if handled, err := v.validateBulkOp(op); handled {
+		return err
+	}
+
+	switch op.Name {
+	case "unreachable":
+		v.setUnreachable()
+	case "nop":
+	case "block", "loop", "try":
+		return v.enterBlock(op.Name, op.Immediates.(string))
+	case "if":
+		if _, err := v.popExpect("i32"); err != nil {
+			return err
+		}
+		return v.enterBlock(op.Name, op.Immediates.(string))
+	case "else":
+		frame, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.opcode != "if" {
+			return fmt.Errorf("else without if")
+		}
+		v.pushCtrl(op.Name, frame.params, frame.results)
+	case "end":
+		frame, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.opcode == "if" && len(frame.results) != len(frame.params) {
+			return fmt.Errorf("if without else must not produce values")
+		}
+		// the end of the function leaves the results to the caller.
+		if len(v.ctrls) > 0 {
+			v.pushVals(frame.results)
+		}
+	case "br":
+		types, err := v.labelTypes(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return err
+		}
+		if err := v.popVals(types); err != nil {
+			return err
+		}
+		v.setUnreachable()
+	case "br_if":
+		if _, err := v.popExpect("i32"); err != nil {
+			return err
+		}
+		types, err := v.labelTypes(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return err
+		}
+		if err := v.popVals(types); err != nil {
+			return err
+		}
+		v.pushVals(types)
+	case "br_table":
+		return v.validateBrTable(op.Immediates.(JSON))
+	case "return":
+		if err := v.popVals(v.results); err != nil {
+			return err
+		}
+		v.setUnreachable()
+	case "call", "return_call":
+		typ, err := v.ctx.funcType(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return err
+		}
+		return v.validateCall(op.Name, typ)
+	case "call_indirect", "return_call_indirect":
+		imm := op.Immediates.(JSON)
+		if err := v.checkTable(imm["reserved"].(uint32)); err != nil {
+			return err
+		}
+		typ, err := v.ctx.typeEntry(imm["index"].(uint64))
+		if err != nil {
+			return err
+		}
+		if _, err := v.popExpect("i32"); err != nil {
+			return err
+		}
+		return v.validateCall(op.Name, typ)
+	case "drop":
+		if _, err := v.popVal(); err != nil {
+			return err
+		}
+	case "select":
+		if _, err := v.popExpect("i32"); err != nil {
+			return err
+		}
+		t1, err := v.popVal()
+		if err != nil {
+			return err
+		}
+		t2, err := v.popExpect(t1)
+		if err != nil {
+			return err
+		}
+		v.pushVal(t2)
+	case "get_local", "set_local", "tee_local":
+		index := op.Immediates.(uint32)
+		if uint64(index) >= uint64(len(v.locals)) {
+			return fmt.Errorf("unknown local %d", index)
+		}
+		typ := v.locals[index]
+		if op.Name != "get_local" {
+			if _, err := v.popExpect(typ); err != nil {
+				return err
+			}
+		}
+		if op.Name != "set_local" {
+			v.pushVal(typ)
+		}
+	case "get_global", "set_global":
+		index := op.Immediates.(uint32)
+		if uint64(index) >= uint64(len(v.ctx.globals)) {
+			return fmt.Errorf("unknown global %d", index)
+		}
+		global := v.ctx.globals[index]
+		if op.Name == "get_global" {
+			v.pushVal(global.ContentType)
+			return nil
+		}
+		if global.Mutability == 0 {
+			return fmt.Errorf("global %d is immutable", index)
+		}
+		if _, err := v.popExpect(global.ContentType); err != nil {
+			return err
+		}
+	case "current_memory", "grow_memory":
+		addrType, err := v.ctx.addressType(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return err
+		}
+		if op.Name == "grow_memory" {
+			if _, err := v.popExpect(addrType); err != nil {
+				return err
+			}
+		}
+		v.pushVal(addrType)
+	case "throw":
+		params, err := v.ctx.tagParams(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return err
+		}
+		if err := v.popVals(params); err != nil {
+			return err
+		}
+		v.setUnreachable()
+	case "throw_ref":
+		if _, err := v.popExpect("exnref"); err != nil {
+			return err
+		}
+		v.setUnreachable()
+	case "rethrow":
+		frame, err := v.label(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return err
+		}
+		if frame.opcode != "catch" && frame.opcode != "catch_all" {
+			return fmt.Errorf("rethrow must target a catch block")
+		}
+		v.setUnreachable()
+	case "catch", "catch_all":
+		frame, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.opcode != "try" && frame.opcode != "catch" {
+			return fmt.Errorf("%s without try", op.Name)
+		}
+		v.pushCtrl(op.Name, nil, frame.results)
+		if op.Name == "catch" {
+			params, err := v.ctx.tagParams(uint64(op.Immediates.(uint32)))
+			if err != nil {
+				return err
+			}
+			v.pushVals(params)
+		}
+	case "delegate":
+		frame, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.opcode != "try" {
+			return fmt.Errorf("delegate without try")
+		}
+		if _, err := v.label(uint64(op.Immediates.(uint32))); err != nil {
+			return err
+		}
+		v.pushVals(frame.results)
+	case "try_table":
+		return v.validateTryTable(op.Immediates.(JSON))
+	default:
+		return v.validateSimpleOp(op)
+	}
+	return nil
+}
+
+// validateBulkOp validates the bulk memory and table operators, they're told apart by their full name
+// as `data.drop` and `elem.drop` share their name with `drop`.
+func (v *funcValidator) validateBulkOp(op OP) (bool, error) {
+	var params []string
+	switch fullOpName(op) {
+	case "memory.init":
+		imm := op.Immediates.(JSON)
+		addrType, err := v.ctx.addressType(uint64(imm["memory"].(uint32)))
+		if err != nil {
+			return true, err
+		}
+		if err := v.checkData(imm["data"].(uint32)); err != nil {
+			return true, err
+		}
+		params = []string{addrType, "i32", "i32"}
+	case "data.drop":
+		if err := v.checkData(op.Immediates.(uint32)); err != nil {
+			return true, err
+		}
+	case "memory.copy":
+		imm := op.Immediates.(JSON)
+		dst, err := v.ctx.addressType(uint64(imm["dst"].(uint32)))
+		if err != nil {
+			return true, err
+		}
+		src, err := v.ctx.addressType(uint64(imm["src"].(uint32)))
+		if err != nil {
+			return true, err
+		}
+		// the length is an i64 only if both memories are 64-bit.
+		length := "i32"
+		if dst == "i64" && src == "i64" {
+			length = "i64"
+		}
+		params = []string{dst, src, length}
+	case "memory.fill":
+		addrType, err := v.ctx.addressType(uint64(op.Immediates.(uint32)))
+		if err != nil {
+			return true, err
+		}
+		params = []string{addrType, "i32", addrType}
+	case "table.init":
+		imm := op.Immediates.(JSON)
+		if err := v.checkTable(imm["table"].(uint32)); err != nil {
+			return true, err
+		}
+		if err := v.checkElement(imm["element"].(uint32)); err != nil {
+			return true, err
+		}
+		params = []string{"i32", "i32", "i32"}
+	case "elem.drop":
+		if err := v.checkElement(op.Immediates.(uint32)); err != nil {
+			return true, err
+		}
+	case "table.copy":
+		imm := op.Immediates.(JSON)
+		if err := v.checkTable(imm["dst"].(uint32)); err != nil {
+			return true, err
+		}
+		if err := v.checkTable(imm["src"].(uint32)); err != nil {
+			return true, err
+		}
+		params = []string{"i32", "i32", "i32"}
+	default:
+		return false, nil
+	}
+	return true, v.popVals(params)
+}
+
+func (v *funcValidator) checkData(index uint32) error {
+	if !v.ctx.hasDataCount {
+		return fmt.Errorf("the data count section is required by the bulk memory operators")
+	}
+	if int(index) >= v.ctx.data {
+		return fmt.Errorf("unknown data segment %d", index)
+	}
+	return nil
+}
+
+func (v *funcValidator) checkElement(index uint32) error {
+	if int(index) >= v.ctx.elements {
+		return fmt.Errorf("unknown element segment %d", index)
+	}
+	return nil
+}
+
+func (v *funcValidator) checkTable(index uint32) error {
+	if int(index) >= len(v.ctx.tables) {
+		return fmt.Errorf("unknown table %d", index)
+	}
+	return nil
+}
+
+func (v *funcValidator) validateCall(name string, typ TypeEntry) error {
+	if err := v.popVals(typ.Params); err != nil {
+		return err
+	}
+	var results []string
+	if typ.ReturnType != "" {
+		results = []string{typ.ReturnType}
+	}
+	if strings.HasPrefix(name, "return_") {
+		if !equalTypes(results, v.results) {
+			return fmt.Errorf("tail call results %v don't match the function results %v", results, v.results)
+		}
+		v.setUnreachable()
+		return nil
+	}
+	v.pushVals(results)
+	return nil
+}
+
+func (v *funcValidator) validateBrTable(imm JSON) error {
+	if _, err := v.popExpect("i32"); err != nil {
+		return err
+	}
+	defaults, err := v.labelTypes(imm["default_target"].(uint64))
+	if err != nil {
+		return err
+	}
+	for _, target := range imm["targets"].([]uint64) {
+		types, err := v.labelTypes(target)
+		if err != nil {
+			return err
+		}
+		if len(types) != len(defaults) {
+			return fmt.Errorf("br_table targets have different arities")
+		}
+		// check the operands against every target without consuming them.
+		popped := make([]string, len(types))
+		for i := len(types) - 1; i >= 0; i-- {
+			if popped[i], err = v.popExpect(types[i]); err != nil {
+				return err
+			}
+		}
+		v.pushVals(popped)
+	}
+	if err := v.popVals(defaults); err != nil {
+		return err
+	}
+	v.setUnreachable()
+	return nil
+}
+
+func (v *funcValidator) validateTryTable(imm JSON) error {
+	for _, catch := range imm["catches"].([]JSON) {
+		var types []string
+		kind := catch["kind"].(string)
+		if kind == "catch" || kind == "catch_ref" {
+			params, err := v.ctx.tagParams(uint64(catch["tag"].(uint32)))
+			if err != nil {
+				return err
+			}
+			types = append(types, params...)
+		}
+		if kind == "catch_ref" || kind == "catch_all_ref" {
+			types = append(types, "exnref")
+		}
+		labels, err := v.labelTypes(uint64(catch["label"].(uint32)))
+		if err != nil {
+			return err
+		}
+		if !equalTypes(types, labels) {
+			return fmt.Errorf("%s clause passes %v to a label of type %v", kind, types, labels)
+		}
+	}
+	return v.enterBlock("try_table", imm["block_type"].(string))
+}
+
+func equalTypes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	typeSizes = map[string]uint64{
+		"i32": 4,
+		"i64": 8,
+		"f32": 4,
+		"f64": 8,
+	}
+
+	// the numeric operators by their signature, the type of an operator is its return type.
+	testOps    = map[string]struct{}{"eqz": {}}
+	compareOps = map[string]struct{}{
+		"eq": {}, "ne": {}, "lt_s": {}, "lt_u": {}, "gt_s": {}, "gt_u": {}, "le_s": {}, "le_u": {}, "ge_s": {}, "ge_u": {},
+		"lt": {}, "gt": {}, "le": {}, "ge": {},
+	}
+	unaryOps = map[string]struct{}{
+		"clz": {}, "ctz": {}, "popcnt": {},
+		"abs": {}, "neg": {}, "ceil": {}, "floor": {}, "trunc": {}, "nearest": {}, "sqrt": {},
+	}
+	binaryOps = map[string]struct{}{
+		"add": {}, "sub": {}, "mul": {}, "div_s": {}, "div_u": {}, "rem_s": {}, "rem_u": {},
+		"and": {}, "or": {}, "xor": {}, "shl": {}, "shr_s": {}, "shr_u": {}, "rotl": {}, "rotr": {},
+		"div": {}, "min": {}, "max": {}, "copysign": {},
+	}
+)
+
+// accessWidth returns the number of bytes accessed by a memory operator, i.e. 2 for `load16_u`.
+func accessWidth(name, typ string) uint64 {
+	end := strings.IndexAny(name, "_.")
+	if end < 0 {
+		end = len(name)
+	}
+	digits := strings.TrimLeft(name[:end], "abcdefghijklmnopqrstuvwxyz")
+	if digits == "" {
+		return typeSizes[typ]
+	}
+	bits, _ := strconv.ParseUint(digits, 10, 64)
+	return bits / 8
+}
+
+// validateMemoryOp checks the memory immediate of an operator and returns the address type of the memory.
+func (v *funcValidator) validateMemoryOp(imm JSON, width uint64, atomic bool) (string, error) {
+	var memory uint32
+	if index, exist := imm["memory"]; exist {
+		memory = index.(uint32)
+	}
+	addrType, err := v.ctx.addressType(uint64(memory))
+	if err != nil {
+		return "", err
+	}
+	align := imm["flags"].(uint64) &^ MEMORY_FLAG_INDEX
+	if align >= 64 || uint64(1)<<align > width {
+		return "", fmt.Errorf("alignment 2^%d is larger than the natural alignment %d", align, width)
+	}
+	if atomic && uint64(1)<<align != width {
+		return "", fmt.Errorf("alignment of an atomic access must be %d", width)
+	}
+	if addrType == "i32" && imm["offset"].(uint64) > uint64(^uint32(0)) {
+		return "", fmt.Errorf("offset %d is out of the 32-bit address space", imm["offset"].(uint64))
+	}
+	return addrType, nil
+}
+
+// errUnmodeled is returned for the operators the validator doesn't type-check, i.e. the reference and garbage
+// collection ones. ValidateModule accepts them, the operand stack is then unknown until the end of their block
+// like after a branch.
+var errUnmodeled = errors.New("operator not modeled by the validator")
+
+// validateSimpleOp validates the operators that pop and push a fixed list of types.
+func (v *funcValidator) validateSimpleOp(op OP) error {
+	var (
+		name    = op.Name
+		typ     = op.ReturnType
+		params  []string
+		results []string
+	)
+	if _, exist := typeSizes[typ]; !exist && typ != "memory" && typ != "atomic" {
+		// i.e. the reference and garbage collection operators.
+		if isKnownOp(fullOpName(op)) {
+			return errUnmodeled
+		}
+		return fmt.Errorf("unknown operator")
+	}
+
+	_, isTest := testOps[name]
+	_, isCompare := compareOps[name]
+	_, isUnary := unaryOps[name]
+	_, isBinary := binaryOps[name]
+	switch {
+	case name == "const":
+		results = []string{typ}
+	case isTest:
+		params, results = []string{typ}, []string{"i32"}
+	case isCompare:
+		params, results = []string{typ, typ}, []string{"i32"}
+	case isUnary:
+		params, results = []string{typ}, []string{typ}
+	case isBinary:
+		params, results = []string{typ, typ}, []string{typ}
+	case strings.Contains(name, "/"):
+		// conversions are named after their source type, i.e. `i64.extend_s/i32`.
+		src := name[strings.Index(name, "/")+1:]
+		params, results = []string{src}, []string{typ}
+	case name == "fence":
+	case strings.HasPrefix(name, "load"), strings.HasPrefix(name, "atomic.load"):
+		addrType, err := v.validateMemoryOp(op.Immediates.(JSON), accessWidth(strings.TrimPrefix(name, "atomic."), typ), strings.HasPrefix(name, "atomic."))
+		if err != nil {
+			return err
+		}
+		params, results = []string{addrType}, []string{typ}
+	case strings.HasPrefix(name, "store"), strings.HasPrefix(name, "atomic.store"):
+		addrType, err := v.validateMemoryOp(op.Immediates.(JSON), accessWidth(strings.TrimPrefix(name, "atomic."), typ), strings.HasPrefix(name, "atomic."))
+		if err != nil {
+			return err
+		}
+		params = []string{addrType, typ}
+	case strings.HasPrefix(name, "atomic.rmw"):
+		addrType, err := v.validateMemoryOp(op.Immediates.(JSON), accessWidth(strings.TrimPrefix(name, "atomic."), typ), true)
+		if err != nil {
+			return err
+		}
+		params, results = []string{addrType, typ}, []string{typ}
+		if strings.Contains(name, "cmpxchg") {
+			params = append(params, typ)
+		}
+	case name == "atomic.notify":
+		addrType, err := v.validateMemoryOp(op.Immediates.(JSON), 4, true)
+		if err != nil {
+			return err
+		}
+		params, results = []string{addrType, "i32"}, []string{"i32"}
+	case name == "atomic.wait32", name == "atomic.wait64":
+		width, expected := uint64(4), "i32"
+		if name == "atomic.wait64" {
+			width, expected = 8, "i64"
+		}
+		addrType, err := v.validateMemoryOp(op.Immediates.(JSON), width, true)
+		if err != nil {
+			return err
+		}
+		params, results = []string{addrType, expected, "i64"}, []string{"i32"}
+	default:
+		return fmt.Errorf("unknown operator")
+	}
+
+	if err := v.popVals(params); err != nil {
+		return err
+	}
+	v.pushVals(results)
+	return nil
+}
@@ -0,0 +1,181 @@
+package toolkit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateModules(t *testing.T) {
+	dirName := path.Join("test", "wasm")
+	dir, err := ioutil.ReadDir(dirName)
+	assert.Nil(t, err)
+	for _, fi := range dir {
+		wasm, err := ioutil.ReadFile(path.Join(dirName, fi.Name()))
+		assert.Nil(t, err)
+		assert.Nil(t, ValidateModule(Wasm2Json(wasm)), fi.Name())
+	}
+}
+
+// memory64Module loads an i64 from a 64-bit memory.
+var memory64Module = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x05, 0x01, 0x60, 0x00, 0x01, 0x7e, // type () -> i64
+	0x03, 0x02, 0x01, 0x00, // function
+	0x05, 0x08, 0x01, 0x05, 0x01, 0x80, 0x80, 0x80, 0x80, 0x20, // memory i64 1 2^33
+	0x0a, 0x0d, 0x01, 0x0b, 0x00, // code
+	0x42, 0x00, // i64.const 0
+	0x29, 0x03, 0x80, 0x80, 0x80, 0x80, 0x20, // i64.load align=8 offset=2^33
+	0x0b,
+}
+
+func TestMemory64(t *testing.T) {
+	jsonObj := Wasm2Json(memory64Module)
+	mem := jsonObj[3]["entries"].([]MemLimits)[0]
+	assert.True(t, mem.Is64())
+	assert.Equal(t, uint64(1)<<33, mem.Maximum)
+
+	load := jsonObj[4]["entries"].([]CodeBody)[0].Code[1]
+	assert.Equal(t, JSON{"flags": uint64(3), "offset": uint64(1) << 33}, load.Immediates)
+	assert.Equal(t, 0, bytes.Compare(memory64Module, Json2Wasm(jsonObj)))
+	assert.Nil(t, ValidateModule(jsonObj))
+
+	// the address of a 64-bit memory must be an i64.
+	invalid := append([]byte{}, memory64Module...)
+	invalid[len(invalid)-10] = 0x41 // i32.const 0
+	assert.NotNil(t, ValidateModule(Wasm2Json(invalid)))
+
+	// a 32-bit memory can't exceed 4GiB.
+	invalid = append([]byte{}, memory64Module...)
+	invalid[len(invalid)-22] = 0x01
+	assert.NotNil(t, ValidateModule(Wasm2Json(invalid)))
+
+	// nor be addressed with an i64.
+	jsonObj[3]["entries"] = []MemLimits{{Intial: 1}}
+	assert.NotNil(t, ValidateModule(jsonObj))
+}
+
+func TestValidateCode(t *testing.T) {
+	jsonObj := Wasm2Json(exceptionModule)
+	assert.Nil(t, ValidateModule(jsonObj))
+
+	body := jsonObj[5]["entries"].([]CodeBody)[0]
+	body.Code = []OP{{Name: "const", ReturnType: "i64", Immediates: int64(1)}, {Name: "throw", Immediates: uint32(0)}, {Name: "end"}}
+	jsonObj[5]["entries"] = []CodeBody{body}
+	assert.NotNil(t, ValidateModule(jsonObj))
+}
+
+func TestMultiMemory(t *testing.T) {
+	i32 := func(v int32) OP { return OP{Name: "const", ReturnType: "i32", Immediates: v} }
+	i64 := func(v int64) OP { return OP{Name: "const", ReturnType: "i64", Immediates: v} }
+	module := []JSON{
+		{"name": "preramble", "magic": []byte{0x00, 0x61, 0x73, 0x6d}, "version": []byte{0x01, 0x00, 0x00, 0x00}},
+		{"name": "type", "entries": []TypeEntry{{Form: "func", Params: []string{}}}},
+		{"name": "function", "entries": []uint64{0}},
+		{"name": "memory", "entries": []MemLimits{{Intial: 1}, {Flags: LIMITS_FLAG_INDEX64, Intial: 1}}},
+		{"name": "datacount", "count": uint32(2)},
+		{"name": "code", "entries": []CodeBody{{
+			Locals: []LocalEntry{},
+			Code: []OP{
+				i64(0),
+				{Name: "load", ReturnType: "i32", Immediates: JSON{"flags": uint64(0x42), "memory": uint32(1), "offset": uint64(4)}},
+				{Name: "drop"},
+				i64(0), i32(0), i32(1),
+				{Name: "copy", ReturnType: "memory", Immediates: JSON{"dst": uint32(1), "src": uint32(0)}},
+				i64(0), i32(0), i64(8),
+				{Name: "fill", ReturnType: "memory", Immediates: uint32(1)},
+				i32(0), i32(0), i32(1),
+				{Name: "init", ReturnType: "memory", Immediates: JSON{"data": uint32(0), "memory": uint32(0)}},
+				{Name: "drop", ReturnType: "data", Immediates: uint32(0)},
+				{Name: "current_memory", Immediates: uint32(1)},
+				{Name: "drop"},
+				{Name: "end"},
+			},
+		}}},
+		{"name": "data", "entries": []DataSegment{
+			{Flags: DATA_FLAG_PASSIVE, Data: []byte("passive")},
+			{Flags: DATA_FLAG_INDEX, Index: 1, Offset: []OP{i64(16)}, Data: []byte("active")},
+		}},
+	}
+	assert.Nil(t, ValidateModule(module))
+
+	wasm := Json2Wasm(module)
+	assert.Equal(t, module, Wasm2Json(wasm))
+	assert.Equal(t, 0, bytes.Compare(wasm, Json2Wasm(Wasm2Json(wasm))))
+
+	// the memory index follows the flags of the memory immediate.
+	load := module[5]["entries"].([]CodeBody)[0].Code[1]
+	assert.Equal(t, []byte{0x28, 0x42, 0x01, 0x04}, GenerateOP(load, nil).Bytes())
+
+	// the address of the second memory is an i64.
+	module[3]["entries"] = []MemLimits{{Intial: 1}, {Intial: 1}}
+	assert.NotNil(t, ValidateModule(module))
+}
+
+// extendedConstModule computes the initializers of a global and a data segment from an imported global.
+var extendedConstModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x02, 0x0d, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x04, 0x62, 0x61, 0x73, 0x65, 0x03, 0x7f, 0x00, // import global env.base
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory
+	0x06, 0x09, 0x01, 0x7f, 0x00, 0x23, 0x00, 0x41, 0x10, 0x6a, 0x0b, // global (get_global 0 i32.const 16 i32.add)
+	0x0b, 0x0b, 0x01, 0x00, 0x23, 0x00, 0x41, 0x10, 0x6a, 0x0b, 0x02, 0x68, 0x69, // data (get_global 0 i32.const 16 i32.add) "hi"
+}
+
+func TestExtendedConst(t *testing.T) {
+	jsonObj := Wasm2Json(extendedConstModule)
+	expr := []OP{
+		{Name: "get_global", Immediates: uint32(0)},
+		{Name: "const", ReturnType: "i32", Immediates: int32(16)},
+		{Name: "add", ReturnType: "i32"},
+	}
+	assert.Equal(t, expr, jsonObj[3]["entries"].([]GlobalEntry)[0].Init)
+	assert.Equal(t, expr, jsonObj[4]["entries"].([]DataSegment)[0].Offset)
+	assert.Equal(t, []byte("hi"), jsonObj[4]["entries"].([]DataSegment)[0].Data)
+	assert.Equal(t, 0, bytes.Compare(extendedConstModule, Json2Wasm(jsonObj)))
+	assert.Nil(t, ValidateModule(jsonObj))
+
+	// only the constant operators are allowed.
+	jsonObj[3]["entries"].([]GlobalEntry)[0].Init[2] = OP{Name: "div_s", ReturnType: "i32"}
+	assert.NotNil(t, ValidateModule(jsonObj))
+
+	// and the expression must leave a single value of the expected type.
+	jsonObj[3]["entries"].([]GlobalEntry)[0].Init = expr[:2]
+	assert.NotNil(t, ValidateModule(jsonObj))
+}
+
+func TestValidateGCModule(t *testing.T) {
+	// the GC operators aren't type-checked, only the strict validation tells it.
+	assert.Nil(t, ValidateModule(Wasm2Json(gcModule)))
+	err := ValidateModuleStrict(Wasm2Json(gcModule))
+	assert.EqualError(t, err, "function 0: op 3 (struct.get): operator not modeled by the validator")
+
+	// the reference operators aren't type-checked, the code following them still is.
+	refNull := OP{Name: "null", ReturnType: "ref", Immediates: "func"}
+	b := NewBuilder(nil)
+	code, _ := NewEmitter().Op(refNull).Drop().I32Const(1).Code()
+	b.AddFunction(b.FuncType(nil, "i32"), nil, code)
+	assert.Nil(t, ValidateModule(b.Module()))
+	assert.NotNil(t, ValidateModuleStrict(b.Module()))
+	b = NewBuilder(nil)
+	code, _ = NewEmitter().Op(refNull).Drop().I64Const(1).Code()
+	b.AddFunction(b.FuncType(nil, "i32"), nil, code)
+	assert.EqualError(t, ValidateModule(b.Module()), "function 0: op 3 (end): type mismatch: expected i32, got i64")
+}
+
+func TestCodeValidator(t *testing.T) {
+	b := NewBuilder(nil)
+	unary := b.FuncType([]string{"i32"}, "")
+	_, err := b.ImportFunction("env", "print", unary)
+	assert.Nil(t, err)
+	code, _ := NewEmitter().GetLocal(0).Call(0).Code()
+	b.AddFunction(unary, nil, code)
+	validator := NewCodeValidator(b.Module())
+
+	assert.Nil(t, validator.Validate(0, CodeBody{Code: code}))
+	code, _ = NewEmitter().Call(0).Code()
+	assert.EqualError(t, validator.Validate(0, CodeBody{Code: code}), "function 1: op 0 (call): operand stack underflow")
+	assert.EqualError(t, validator.Validate(1, CodeBody{Code: code}), "code: no function 2")
+}
@@ -1,6 +1,8 @@
 package toolkit
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -15,7 +17,47 @@ var (
 		0x7c: "f64",
 		0x70: "anyFunc",
 		0x60: "func",
+		0x69: "exnref",
 		0x40: "block_type",
+
+		// reference types abbreviating `(ref null ht)`.
+		0x6f: "externref",
+		0x6e: "anyref",
+		0x6d: "eqref",
+		0x6c: "i31ref",
+		0x6b: "structref",
+		0x6a: "arrayref",
+		0x73: "nullfuncref",
+		0x72: "nullexternref",
+		0x71: "nullref",
+		0x74: "nullexnref",
+
+		// garbage collection types.
+		0x7b: "v128",
+		0x78: "i8",
+		0x77: "i16",
+		0x5f: "struct",
+		0x5e: "array",
+		0x50: "sub",
+		0x4f: "sub_final",
+		0x4e: "rec",
+	}
+
+	// https://github.com/WebAssembly/gc/blob/main/proposals/gc/MVP.md#heap-types
+	// The abstract heap types, the concrete ones are type indices.
+	W2J_HEAP_TYPES = map[byte]string{
+		0x70: "func",
+		0x6f: "extern",
+		0x6e: "any",
+		0x6d: "eq",
+		0x6c: "i31",
+		0x6b: "struct",
+		0x6a: "array",
+		0x73: "nofunc",
+		0x72: "noextern",
+		0x71: "none",
+		0x69: "exn",
+		0x74: "noexn",
 	}
 
 	// https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#external_kind
@@ -25,6 +67,7 @@ var (
 		0x01: "table",
 		0x02: "memory",
 		0x03: "global",
+		0x04: "tag",
 	}
 
 	W2J_OPCODES = map[byte]string{
@@ -41,9 +84,21 @@ var (
 		0xe: "br_table",
 		0xf: "return",
 
+		// exception handling
+		0x6:  "try",
+		0x7:  "catch",
+		0x8:  "throw",
+		0x9:  "rethrow",
+		0xa:  "throw_ref",
+		0x18: "delegate",
+		0x19: "catch_all",
+		0x1f: "try_table",
+
 		// calls
 		0x10: "call",
 		0x11: "call_indirect",
+		0x12: "return_call",
+		0x13: "return_call_indirect",
 
 		// Parametric operators
 		0x1a: "drop",
@@ -219,6 +274,172 @@ var (
 		0xbd: "i64.reinterpret/f64",
 		0xbe: "f32.reinterpret/i32",
 		0xbf: "f64.reinterpret/i64",
+
+		// reference types
+		0x1c: "select_t",
+		0x25: "table.get",
+		0x26: "table.set",
+		0xd0: "ref.null",
+		0xd1: "ref.is_null",
+		0xd2: "ref.func",
+
+		// typed function references
+		0x14: "call_ref",
+		0x15: "return_call_ref",
+		0xd3: "ref.as_non_null",
+		0xd4: "br_on_null",
+		0xd5: "ref.eq",
+		0xd6: "br_on_non_null",
+	}
+
+	// https://github.com/WebAssembly/bulk-memory-operations/blob/master/proposals/bulk-memory-operations/Overview.md
+	// miscellaneous operators are prefixed by the byte 0xfc followed by a varuint32 opcode.
+	W2J_MISC_OPCODES = map[uint32]string{
+		0x0: "i32.trunc_s:sat/f32",
+		0x1: "i32.trunc_u:sat/f32",
+		0x2: "i32.trunc_s:sat/f64",
+		0x3: "i32.trunc_u:sat/f64",
+		0x4: "i64.trunc_s:sat/f32",
+		0x5: "i64.trunc_u:sat/f32",
+		0x6: "i64.trunc_s:sat/f64",
+		0x7: "i64.trunc_u:sat/f64",
+		0x8: "memory.init",
+		0x9: "data.drop",
+		0xa: "memory.copy",
+		0xb: "memory.fill",
+		0xc: "table.init",
+		0xd: "elem.drop",
+		0xe: "table.copy",
+
+		// reference types
+		0xf:  "table.grow",
+		0x10: "table.size",
+		0x11: "table.fill",
+	}
+
+	// https://github.com/WebAssembly/threads/blob/master/proposals/threads/Overview.md
+	// atomic operators are prefixed by the byte 0xfe followed by a varuint32 opcode.
+	W2J_ATOMIC_OPCODES = map[uint32]string{
+		0x0:  "memory.atomic.notify",
+		0x1:  "memory.atomic.wait32",
+		0x2:  "memory.atomic.wait64",
+		0x3:  "atomic.fence",
+		0x10: "i32.atomic.load",
+		0x11: "i64.atomic.load",
+		0x12: "i32.atomic.load8_u",
+		0x13: "i32.atomic.load16_u",
+		0x14: "i64.atomic.load8_u",
+		0x15: "i64.atomic.load16_u",
+		0x16: "i64.atomic.load32_u",
+		0x17: "i32.atomic.store",
+		0x18: "i64.atomic.store",
+		0x19: "i32.atomic.store8",
+		0x1a: "i32.atomic.store16",
+		0x1b: "i64.atomic.store8",
+		0x1c: "i64.atomic.store16",
+		0x1d: "i64.atomic.store32",
+		0x1e: "i32.atomic.rmw.add",
+		0x1f: "i64.atomic.rmw.add",
+		0x20: "i32.atomic.rmw8.add_u",
+		0x21: "i32.atomic.rmw16.add_u",
+		0x22: "i64.atomic.rmw8.add_u",
+		0x23: "i64.atomic.rmw16.add_u",
+		0x24: "i64.atomic.rmw32.add_u",
+		0x25: "i32.atomic.rmw.sub",
+		0x26: "i64.atomic.rmw.sub",
+		0x27: "i32.atomic.rmw8.sub_u",
+		0x28: "i32.atomic.rmw16.sub_u",
+		0x29: "i64.atomic.rmw8.sub_u",
+		0x2a: "i64.atomic.rmw16.sub_u",
+		0x2b: "i64.atomic.rmw32.sub_u",
+		0x2c: "i32.atomic.rmw.and",
+		0x2d: "i64.atomic.rmw.and",
+		0x2e: "i32.atomic.rmw8.and_u",
+		0x2f: "i32.atomic.rmw16.and_u",
+		0x30: "i64.atomic.rmw8.and_u",
+		0x31: "i64.atomic.rmw16.and_u",
+		0x32: "i64.atomic.rmw32.and_u",
+		0x33: "i32.atomic.rmw.or",
+		0x34: "i64.atomic.rmw.or",
+		0x35: "i32.atomic.rmw8.or_u",
+		0x36: "i32.atomic.rmw16.or_u",
+		0x37: "i64.atomic.rmw8.or_u",
+		0x38: "i64.atomic.rmw16.or_u",
+		0x39: "i64.atomic.rmw32.or_u",
+		0x3a: "i32.atomic.rmw.xor",
+		0x3b: "i64.atomic.rmw.xor",
+		0x3c: "i32.atomic.rmw8.xor_u",
+		0x3d: "i32.atomic.rmw16.xor_u",
+		0x3e: "i64.atomic.rmw8.xor_u",
+		0x3f: "i64.atomic.rmw16.xor_u",
+		0x40: "i64.atomic.rmw32.xor_u",
+		0x41: "i32.atomic.rmw.xchg",
+		0x42: "i64.atomic.rmw.xchg",
+		0x43: "i32.atomic.rmw8.xchg_u",
+		0x44: "i32.atomic.rmw16.xchg_u",
+		0x45: "i64.atomic.rmw8.xchg_u",
+		0x46: "i64.atomic.rmw16.xchg_u",
+		0x47: "i64.atomic.rmw32.xchg_u",
+		0x48: "i32.atomic.rmw.cmpxchg",
+		0x49: "i64.atomic.rmw.cmpxchg",
+		0x4a: "i32.atomic.rmw8.cmpxchg_u",
+		0x4b: "i32.atomic.rmw16.cmpxchg_u",
+		0x4c: "i64.atomic.rmw8.cmpxchg_u",
+		0x4d: "i64.atomic.rmw16.cmpxchg_u",
+		0x4e: "i64.atomic.rmw32.cmpxchg_u",
+	}
+
+	// https://github.com/WebAssembly/gc/blob/main/proposals/gc/MVP.md#instructions
+	// garbage collection operators are prefixed by the byte 0xfb followed by a varuint32 opcode.
+	// The nullable variants of `ref.test` and `ref.cast` are suffixed by `_null`.
+	W2J_GC_OPCODES = map[uint32]string{
+		0x0:  "struct.new",
+		0x1:  "struct.new_default",
+		0x2:  "struct.get",
+		0x3:  "struct.get_s",
+		0x4:  "struct.get_u",
+		0x5:  "struct.set",
+		0x6:  "array.new",
+		0x7:  "array.new_default",
+		0x8:  "array.new_fixed",
+		0x9:  "array.new_data",
+		0xa:  "array.new_elem",
+		0xb:  "array.get",
+		0xc:  "array.get_s",
+		0xd:  "array.get_u",
+		0xe:  "array.set",
+		0xf:  "array.len",
+		0x10: "array.fill",
+		0x11: "array.copy",
+		0x12: "array.init_data",
+		0x13: "array.init_elem",
+		0x14: "ref.test",
+		0x15: "ref.test_null",
+		0x16: "ref.cast",
+		0x17: "ref.cast_null",
+		0x18: "br_on_cast",
+		0x19: "br_on_cast_fail",
+		0x1a: "any.convert_extern",
+		0x1b: "extern.convert_any",
+		0x1c: "ref.i31",
+		0x1d: "i31.get_s",
+		0x1e: "i31.get_u",
+	}
+
+	// W2J_OPCODE_PREFIXES maps a prefix byte to the table of the operators it introduces.
+	W2J_OPCODE_PREFIXES = map[byte]map[uint32]string{
+		MISC_PREFIX:   W2J_MISC_OPCODES,
+		ATOMIC_PREFIX: W2J_ATOMIC_OPCODES,
+		GC_PREFIX:     W2J_GC_OPCODES,
+	}
+
+	// https://github.com/WebAssembly/exception-handling/blob/main/proposals/exception-handling/Exceptions.md
+	// The kinds of the catch clauses of `try_table`.
+	W2J_CATCH_KINDS = map[byte]string{
+		0x00: "catch",
+		0x01: "catch_ref",
+		0x02: "catch_all",
+		0x03: "catch_all_ref",
 	}
 
 	W2J_SECTION_IDS = map[byte]string{
@@ -234,6 +455,8 @@ var (
 		9:  "element",
 		10: "code",
 		11: "data",
+		12: "datacount",
+		13: "tag",
 	}
 
 	immeParsers = immediataryParsers{}
@@ -267,8 +490,67 @@ func (immediataryParsers) Uint64(stream *Stream) []byte {
 	return stream.Read(8)
 }
 
+// BlockType parses the type of a block, either a value type (a single byte negative varint7) or
+// the index of a function type, which is named `(type N)`.
 func (immediataryParsers) BlockType(stream *Stream) string {
-	return W2J_LANGUAGE_TYPES[stream.ReadByte()]
+	if isTypeCode(stream.PeekByte()) {
+		return tParsers.ValueType(stream)
+	}
+	return fmt.Sprintf("(type %d)", DecodeSLEB128(stream))
+}
+
+func (immediataryParsers) HeapType(stream *Stream) string {
+	return tParsers.HeapType(stream)
+}
+
+// Field parses the type and the field indices of `struct.get` and `struct.set`.
+func (immediataryParsers) Field(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["type"] = uint32(DecodeULEB128(stream))
+	jsonObj["field"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+func (immediataryParsers) ArrayFixed(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["type"] = uint32(DecodeULEB128(stream))
+	jsonObj["size"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+func (immediataryParsers) ArrayData(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["type"] = uint32(DecodeULEB128(stream))
+	jsonObj["data"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+func (immediataryParsers) ArrayElem(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["type"] = uint32(DecodeULEB128(stream))
+	jsonObj["element"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+// BrOnCast parses the immediates of `br_on_cast` and `br_on_cast_fail`, the bits 0 and 1 of
+// the flags tell whether the source and the target heap types are nullable.
+func (immediataryParsers) BrOnCast(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["flags"] = stream.ReadByte()
+	jsonObj["label"] = uint32(DecodeULEB128(stream))
+	jsonObj["from"] = tParsers.HeapType(stream)
+	jsonObj["to"] = tParsers.HeapType(stream)
+	return jsonObj
+}
+
+// SelectT parses the value types of the typed `select`.
+func (immediataryParsers) SelectT(stream *Stream) []string {
+	types := []string{}
+	num := DecodeULEB128(stream)
+	for i := uint64(0); i < num; i++ {
+		types = append(types, tParsers.ValueType(stream))
+	}
+	return types
 }
 
 func (immediataryParsers) BrTable(stream *Stream) JSON {
@@ -289,17 +571,65 @@ func (immediataryParsers) BrTable(stream *Stream) JSON {
 func (immediataryParsers) CallIndirect(stream *Stream) JSON {
 	jsonObj := make(JSON)
 	jsonObj["index"] = DecodeULEB128(stream)
-	jsonObj["reserved"] = stream.ReadByte()
+	// the table index with reference types, relocatable object files pad it.
+	jsonObj["reserved"] = uint32(DecodeULEB128(stream))
 	return jsonObj
 }
 
 func (immediataryParsers) MemoryImmediate(stream *Stream) JSON {
 	jsonObj := make(JSON)
-	jsonObj["flags"] = DecodeULEB128(stream)
+	flags := DecodeULEB128(stream)
+	jsonObj["flags"] = flags
+	if flags&MEMORY_FLAG_INDEX != 0 {
+		jsonObj["memory"] = uint32(DecodeULEB128(stream))
+	}
 	jsonObj["offset"] = DecodeULEB128(stream)
 	return jsonObj
 }
 
+func (immediataryParsers) MemoryInit(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["data"] = uint32(DecodeULEB128(stream))
+	jsonObj["memory"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+func (immediataryParsers) TableInit(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["element"] = uint32(DecodeULEB128(stream))
+	jsonObj["table"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+// Copy parses the destination and the source of `memory.copy` and `table.copy`.
+func (immediataryParsers) Copy(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	jsonObj["dst"] = uint32(DecodeULEB128(stream))
+	jsonObj["src"] = uint32(DecodeULEB128(stream))
+	return jsonObj
+}
+
+func (immediataryParsers) TryTable(stream *Stream) JSON {
+	jsonObj := make(JSON)
+	catches := []JSON{}
+
+	jsonObj["block_type"] = immeParsers.BlockType(stream)
+	num := DecodeULEB128(stream)
+	for i := uint64(0); i < num; i++ {
+		catch := make(JSON)
+		kind := W2J_CATCH_KINDS[stream.ReadByte()]
+		catch["kind"] = kind
+		if kind == "catch" || kind == "catch_ref" {
+			catch["tag"] = uint32(DecodeULEB128(stream))
+		}
+		catch["label"] = uint32(DecodeULEB128(stream))
+		catches = append(catches, catch)
+	}
+
+	jsonObj["catches"] = catches
+	return jsonObj
+}
+
 type typeParsers struct{}
 
 func (typeParsers) Function(stream *Stream) uint64 {
@@ -307,22 +637,110 @@ func (typeParsers) Function(stream *Stream) uint64 {
 }
 
 func (t typeParsers) Table(stream *Stream) Table {
-	typ := stream.ReadByte()
+	typ := t.ValueType(stream)
 	return Table{
-		ElementType: W2J_LANGUAGE_TYPES[typ],
+		ElementType: typ,
 		Limits:      t.Memory(stream),
 	}
 }
 
-func (typeParsers) Global(stream *Stream) Global {
-	typ := stream.ReadByte()
+func (t typeParsers) Global(stream *Stream) Global {
+	typ := t.ValueType(stream)
 	mutability := stream.ReadByte()
 	return Global{
-		ContentType: W2J_LANGUAGE_TYPES[typ],
+		ContentType: typ,
 		Mutability:  mutability,
 	}
 }
 
+// isTypeCode reports whether a byte is a type constructor, a single byte negative varint7,
+// rather than the start of a type index.
+func isTypeCode(b byte) bool {
+	return b&0xc0 == 0x40
+}
+
+// ValueType parses a value type, the reference types that aren't abbreviated are named as in
+// the text format, i.e. `(ref null 3)` or `(ref func)`.
+func (t typeParsers) ValueType(stream *Stream) string {
+	typ := stream.ReadByte()
+	switch typ {
+	case REF_TYPE:
+		return "(ref " + t.HeapType(stream) + ")"
+	case REF_NULL_TYPE:
+		return "(ref null " + t.HeapType(stream) + ")"
+	}
+	return W2J_LANGUAGE_TYPES[typ]
+}
+
+// HeapType parses an abstract heap type by its name or a concrete one by its type index.
+func (typeParsers) HeapType(stream *Stream) string {
+	if isTypeCode(stream.PeekByte()) {
+		return W2J_HEAP_TYPES[stream.ReadByte()]
+	}
+	return strconv.FormatInt(DecodeSLEB128(stream), 10)
+}
+
+func (t typeParsers) Field(stream *Stream) FieldType {
+	typ := t.ValueType(stream)
+	return FieldType{
+		Type:       typ,
+		Mutability: stream.ReadByte(),
+	}
+}
+
+// SubType parses a type of the type section with its optional supertypes.
+func (t typeParsers) SubType(stream *Stream) TypeEntry {
+	sub := W2J_LANGUAGE_TYPES[stream.PeekByte()]
+	if sub != "sub" && sub != "sub_final" {
+		return t.CompType(stream)
+	}
+
+	stream.ReadByte()
+	var supertypes []uint32
+	num := DecodeULEB128(stream)
+	for i := uint64(0); i < num; i++ {
+		supertypes = append(supertypes, uint32(DecodeULEB128(stream)))
+	}
+
+	entry := t.CompType(stream)
+	entry.Sub = sub
+	entry.Supertypes = supertypes
+	return entry
+}
+
+// CompType parses a function, struct or array type.
+func (t typeParsers) CompType(stream *Stream) TypeEntry {
+	typ := stream.ReadByte()
+	entry := TypeEntry{
+		Form: W2J_LANGUAGE_TYPES[typ],
+	}
+
+	switch entry.Form {
+	case "struct":
+		fieldCount := DecodeULEB128(stream)
+		for i := uint64(0); i < fieldCount; i++ {
+			entry.Fields = append(entry.Fields, t.Field(stream))
+		}
+	case "array":
+		entry.Fields = []FieldType{t.Field(stream)}
+	default:
+		entry.Params = []string{}
+		paramCount := DecodeULEB128(stream)
+
+		// parse the entries.
+		for j := uint64(0); j < paramCount; j++ {
+			entry.Params = append(entry.Params, t.ValueType(stream))
+		}
+
+		numOfReturns := DecodeULEB128(stream)
+		if numOfReturns > 0 {
+			entry.ReturnType = t.ValueType(stream)
+		}
+	}
+
+	return entry
+}
+
 func (typeParsers) Memory(stream *Stream) MemLimits {
 	flags := DecodeULEB128(stream)
 	intial := DecodeULEB128(stream)
@@ -330,16 +748,32 @@ func (typeParsers) Memory(stream *Stream) MemLimits {
 		Flags:  flags,
 		Intial: intial,
 	}
-	if flags == 1 {
+	if flags&LIMITS_FLAG_MAXIMUM != 0 {
 		limits.Maximum = DecodeULEB128(stream)
 	}
 	return limits
 }
 
-func (typeParsers) InitExpr(stream *Stream) OP {
-	op := ParseOp(stream)
-	stream.ReadByte() // skip the `end`
-	return op
+func (typeParsers) Tag(stream *Stream) Tag {
+	attribute := stream.ReadByte()
+	return Tag{
+		Attribute: attribute,
+		Type:      uint32(DecodeULEB128(stream)),
+	}
+}
+
+// InitExpr parses a constant expression, the operators up to the `end` which isn't included.
+// With extended-const an expression can have several operators, i.e. `get_global 0 i32.const 16 i32.add`.
+func (typeParsers) InitExpr(stream *Stream) []OP {
+	ops := []OP{}
+	for stream.Len() > 0 {
+		op := ParseOp(stream)
+		if op.Name == "end" {
+			break
+		}
+		ops = append(ops, op)
+	}
+	return ops
 }
 
 type sectionParsers struct{}
@@ -364,28 +798,22 @@ func (sectionParsers) Type(stream *Stream) TypeSec {
 		Entries: []TypeEntry{},
 	}
 
+	// the types of a rec group are flattened so that the entries stay indexed by type index.
 	for i := uint64(0); i < numberOfEntries; i++ {
-		typ := stream.ReadByte()
-		entry := TypeEntry{
-			Form:   W2J_LANGUAGE_TYPES[typ],
-			Params: []string{},
+		if W2J_LANGUAGE_TYPES[stream.PeekByte()] != "rec" {
+			typSec.Entries = append(typSec.Entries, tParsers.SubType(stream))
+			continue
 		}
 
-		paramCount := DecodeULEB128(stream)
-
-		// parse the entries.
-		for j := uint64(0); j < paramCount; j++ {
-			typ := stream.ReadByte()
-			entry.Params = append(entry.Params, W2J_LANGUAGE_TYPES[typ])
-		}
-
-		numOfReturns := DecodeULEB128(stream)
-		if numOfReturns > 0 {
-			typ = stream.ReadByte()
-			entry.ReturnType = W2J_LANGUAGE_TYPES[typ]
+		stream.ReadByte()
+		numberOfTypes := DecodeULEB128(stream)
+		for j := uint64(0); j < numberOfTypes; j++ {
+			entry := tParsers.SubType(stream)
+			if j == 0 {
+				entry.RecGroup = uint32(numberOfTypes)
+			}
+			typSec.Entries = append(typSec.Entries, entry)
 		}
-
-		typSec.Entries = append(typSec.Entries, entry)
 	}
 
 	return typSec
@@ -417,6 +845,8 @@ func (s sectionParsers) Import(stream *Stream) ImportSec {
 			returned = tParsers.Memory(stream)
 		case "global":
 			returned = tParsers.Global(stream)
+		case "tag":
+			returned = tParsers.Tag(stream)
 		}
 
 		entry := ImportEntry{
@@ -541,11 +971,32 @@ func (sectionParsers) Element(stream *Stream) ElementSec {
 	tparser := typeParsers{}
 	for i := uint64(0); i < numberOfEntries; i++ {
 		entry := ElementEntry{}
-		entry.Index = uint32(DecodeULEB128(stream))
-		entry.Offset = tparser.InitExpr(stream)
+		entry.Flags = uint32(DecodeULEB128(stream))
+		passive := entry.Flags&ELEM_FLAG_PASSIVE != 0
+		if !passive && entry.Flags&ELEM_FLAG_INDEX != 0 {
+			entry.Index = uint32(DecodeULEB128(stream))
+		}
+		if !passive {
+			entry.Offset = tparser.InitExpr(stream)
+		}
+
+		// the segments without the flags 0 and 4 declare the kind of their elements.
+		exprs := entry.Flags&ELEM_FLAG_EXPRS != 0
+		if entry.Flags&(ELEM_FLAG_PASSIVE|ELEM_FLAG_INDEX) != 0 {
+			if exprs {
+				entry.Type = tparser.ValueType(stream)
+			} else {
+				// the element kind, always 0x00 (funcref).
+				stream.ReadByte()
+			}
+		}
 
 		numElem := DecodeULEB128(stream)
 		for j := uint64(0); j < numElem; j++ {
+			if exprs {
+				entry.Exprs = append(entry.Exprs, tparser.InitExpr(stream))
+				continue
+			}
 			elem := DecodeULEB128(stream)
 			entry.Elements = append(entry.Elements, elem)
 		}
@@ -557,6 +1008,7 @@ func (sectionParsers) Element(stream *Stream) ElementSec {
 }
 
 func (sectionParsers) Code(stream *Stream) CodeSec {
+	payloadStart := stream.bytesRead
 	numberOfEntries := DecodeULEB128(stream)
 	codeSec := CodeSec{
 		Name:    "code",
@@ -569,6 +1021,7 @@ func (sectionParsers) Code(stream *Stream) CodeSec {
 			Code:   []OP{},
 		}
 
+		funcOffsets := FuncOffsets{Start: uint32(stream.bytesRead - payloadStart)}
 		bodySize := DecodeULEB128(stream)
 		endBytes := stream.bytesRead + int(bodySize)
 
@@ -577,15 +1030,18 @@ func (sectionParsers) Code(stream *Stream) CodeSec {
 		for j := uint64(0); j < localCount; j++ {
 			local := LocalEntry{}
 			local.Count = uint32(DecodeULEB128(stream))
-			local.Type = W2J_LANGUAGE_TYPES[stream.ReadByte()]
+			local.Type = tParsers.ValueType(stream)
 			codeBody.Locals = append(codeBody.Locals, local)
 		}
 
 		// parse code
 		for stream.bytesRead < endBytes {
+			funcOffsets.Ops = append(funcOffsets.Ops, uint32(stream.bytesRead-payloadStart))
 			op := ParseOp(stream)
 			codeBody.Code = append(codeBody.Code, op)
 		}
+		funcOffsets.End = uint32(stream.bytesRead - payloadStart)
+		codeSec.Offsets = append(codeSec.Offsets, funcOffsets)
 
 		codeSec.Entries = append(codeSec.Entries, codeBody)
 	}
@@ -603,8 +1059,13 @@ func (sectionParsers) Data(stream *Stream) DataSec {
 	tparser := typeParsers{}
 	for i := uint64(0); i < numberOfEntries; i++ {
 		entry := DataSegment{}
-		entry.Index = uint32(DecodeULEB128(stream))
-		entry.Offset = tparser.InitExpr(stream)
+		entry.Flags = uint32(DecodeULEB128(stream))
+		if entry.Flags&DATA_FLAG_INDEX != 0 {
+			entry.Index = uint32(DecodeULEB128(stream))
+		}
+		if entry.Flags&DATA_FLAG_PASSIVE == 0 {
+			entry.Offset = tparser.InitExpr(stream)
+		}
 		segmentSize := DecodeULEB128(stream)
 		entry.Data = append([]byte{}, stream.Read(int(segmentSize))...)
 
@@ -614,8 +1075,37 @@ func (sectionParsers) Data(stream *Stream) DataSec {
 	return dataSec
 }
 
+func (sectionParsers) Tag(stream *Stream) TagSec {
+	numberOfEntries := DecodeULEB128(stream)
+	tagSec := TagSec{
+		Name:    "tag",
+		Entries: []Tag{},
+	}
+
+	for i := uint64(0); i < numberOfEntries; i++ {
+		entry := tParsers.Tag(stream)
+		tagSec.Entries = append(tagSec.Entries, entry)
+	}
+	return tagSec
+}
+
+func (sectionParsers) DataCount(stream *Stream) DataCountSec {
+	return DataCountSec{
+		Name:  "datacount",
+		Count: uint32(DecodeULEB128(stream)),
+	}
+}
+
 // Wasm2Json convert the wasm binary to a JSON array output.
 func Wasm2Json(buf []byte) []JSON {
+	module, _ := Wasm2JsonOffsets(buf)
+	return module
+}
+
+// Wasm2JsonOffsets converts the wasm binary like Wasm2Json, it also returns the offsets of the function
+// bodies and of their operators in the payload of the code section (see FuncOffsets).
+func Wasm2JsonOffsets(buf []byte) ([]JSON, []FuncOffsets) {
+	var offsets []FuncOffsets
 	stream := NewStream(buf)
 	preramble := ParsePreramble(stream)
 	resJson := []JSON{preramble}
@@ -670,16 +1160,25 @@ func Wasm2Json(buf []byte) []JSON {
 			rsec := secParsers.Code(stream)
 			jsonObj["name"] = rsec.Name
 			jsonObj["entries"] = rsec.Entries
+			offsets = rsec.Offsets
 		case "data":
 			rsec := secParsers.Data(stream)
 			jsonObj["name"] = rsec.Name
 			jsonObj["entries"] = rsec.Entries
+		case "datacount":
+			rsec := secParsers.DataCount(stream)
+			jsonObj["name"] = rsec.Name
+			jsonObj["count"] = rsec.Count
+		case "tag":
+			rsec := secParsers.Tag(stream)
+			jsonObj["name"] = rsec.Name
+			jsonObj["entries"] = rsec.Entries
 		}
 
 		resJson = append(resJson, jsonObj)
 	}
 
-	return resJson
+	return resJson, offsets
 }
 
 func ParsePreramble(stream *Stream) JSON {
@@ -705,12 +1204,18 @@ func ParseSectionHeader(stream *Stream) SectionHeader {
 
 func ParseOp(stream *Stream) OP {
 	finalOP := OP{}
+	stream.startWidths()
+
 	op := stream.ReadByte()
-	fullName := strings.Split(W2J_OPCODES[op], ".")
+	opName := W2J_OPCODES[op]
+	if table, exist := W2J_OPCODE_PREFIXES[op]; exist {
+		opName = table[uint32(DecodeULEB128(stream))]
+	}
+	// split the type from the name, the name itself may contain dots (i.e. `i32.atomic.rmw.add`).
+	fullName := strings.SplitN(opName, ".", 2)
 	var (
-		typ           = fullName[0]
-		name          string
-		immediatesKey string
+		typ  = fullName[0]
+		name string
 	)
 
 	if len(fullName) < 2 {
@@ -722,12 +1227,7 @@ func ParseOp(stream *Stream) OP {
 
 	finalOP.Name = name
 
-	if name == "const" {
-		immediatesKey = typ
-	} else {
-		immediatesKey = name
-	}
-	immediates, exist := OP_IMMEDIATES[immediatesKey]
+	immediates, exist := LookupImmediates(finalOP.ReturnType, name)
 	if exist {
 		var returned interface{}
 		switch immediates {
@@ -751,9 +1251,32 @@ func ParseOp(stream *Stream) OP {
 			returned = immeParsers.BrTable(stream)
 		case "memory_immediate":
 			returned = immeParsers.MemoryImmediate(stream)
+		case "try_table":
+			returned = immeParsers.TryTable(stream)
+		case "memory_init":
+			returned = immeParsers.MemoryInit(stream)
+		case "table_init":
+			returned = immeParsers.TableInit(stream)
+		case "copy":
+			returned = immeParsers.Copy(stream)
+		case "heap_type":
+			returned = immeParsers.HeapType(stream)
+		case "field":
+			returned = immeParsers.Field(stream)
+		case "array_fixed":
+			returned = immeParsers.ArrayFixed(stream)
+		case "array_data":
+			returned = immeParsers.ArrayData(stream)
+		case "array_elem":
+			returned = immeParsers.ArrayElem(stream)
+		case "br_on_cast":
+			returned = immeParsers.BrOnCast(stream)
+		case "select_t":
+			returned = immeParsers.SelectT(stream)
 		}
 		finalOP.Immediates = returned
 	}
 
+	finalOP.Widths = stream.stopWidths()
 	return finalOP
 }
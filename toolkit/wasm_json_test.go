@@ -176,3 +176,595 @@ func TestText2Json(t *testing.T) {
 
 	assert.Equal(t, true, assert.ObjectsAreEqual(expected, json))
 }
+
+func TestSharedMemory(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x05, 0x04, 0x01, 0x03, 0x01, 0x02, // memory 1 2 shared
+	}
+	jsonObj := Wasm2Json(wasm)
+	entries := jsonObj[1]["entries"].([]MemLimits)
+	assert.Equal(t, MemLimits{Flags: 3, Intial: 1, Maximum: uint64(2)}, entries[0])
+	assert.True(t, entries[0].IsShared())
+	assert.Equal(t, 0, bytes.Compare(wasm, Json2Wasm(jsonObj)))
+}
+
+func TestAtomicOps(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x03, 0x02, 0x01, 0x00, // function
+		0x05, 0x04, 0x01, 0x03, 0x01, 0x01, // memory 1 1 shared
+		0x0a, 0x10, 0x01, 0x0e, 0x00, // code
+		0x41, 0x00, // i32.const 0
+		0x41, 0x01, // i32.const 1
+		0xfe, 0x1e, 0x02, 0x00, // i32.atomic.rmw.add
+		0x1a,             // drop
+		0xfe, 0x03, 0x00, // atomic.fence
+		0x0b,
+	}
+	jsonObj := Wasm2Json(wasm)
+	code := jsonObj[4]["entries"].([]CodeBody)[0].Code
+	assert.Equal(t, OP{Name: "atomic.rmw.add", ReturnType: "i32", Immediates: JSON{"flags": uint64(2), "offset": uint64(0)}}, code[2])
+	assert.Equal(t, OP{Name: "fence", ReturnType: "atomic", Immediates: int8(0)}, code[4])
+	assert.Equal(t, 0, bytes.Compare(wasm, Json2Wasm(jsonObj)))
+}
+
+// exceptionModule imports a tag and throws it from a `try` block.
+var exceptionModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x08, 0x02, 0x60, 0x00, 0x00, 0x60, 0x01, 0x7f, 0x00, // type
+	0x02, 0x0a, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01, 0x65, 0x04, 0x00, 0x01, // import tag env.e
+	0x03, 0x02, 0x01, 0x00, // function
+	0x0d, 0x03, 0x01, 0x00, 0x01, // tag
+	0x0a, 0x15, 0x01, 0x13, 0x00, // code
+	0x06, 0x40, // try
+	0x41, 0x01, // i32.const 1
+	0x08, 0x00, // throw 0
+	0x07, 0x00, // catch 0
+	0x1a,       // drop
+	0x19,       // catch_all
+	0x01,       // nop
+	0x0b,       // end
+	0x06, 0x40, // try
+	0x01,       // nop
+	0x18, 0x00, // delegate 0
+	0x0b,
+}
+
+func TestExceptionHandling(t *testing.T) {
+	jsonObj := Wasm2Json(exceptionModule)
+	imports := jsonObj[2]["entries"].([]ImportEntry)
+	assert.Equal(t, ImportEntry{ModuleStr: "env", FieldStr: "e", Kind: "tag", Type: Tag{Type: 1}}, imports[0])
+	assert.Equal(t, "tag", jsonObj[4]["name"])
+	assert.Equal(t, []Tag{{Type: 1}}, jsonObj[4]["entries"])
+
+	code := jsonObj[5]["entries"].([]CodeBody)[0].Code
+	names := []string{}
+	for _, op := range code {
+		names = append(names, op.Name)
+	}
+	assert.Equal(t, []string{"try", "const", "throw", "catch", "drop", "catch_all", "nop", "end", "try", "nop", "delegate", "end"}, names)
+	assert.Equal(t, 0, bytes.Compare(exceptionModule, Json2Wasm(jsonObj)))
+}
+
+func TestTryTable(t *testing.T) {
+	op := OP{
+		Name: "try_table",
+		Immediates: JSON{
+			"block_type": "block_type",
+			"catches": []JSON{
+				{"kind": "catch", "tag": uint32(0), "label": uint32(0)},
+				{"kind": "catch_all_ref", "label": uint32(1)},
+			},
+		},
+	}
+	stream := GenerateOP(op, nil)
+	assert.Equal(t, []byte{0x1f, 0x40, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01}, stream.Bytes())
+	assert.Equal(t, op, ParseOp(NewStream(stream.Bytes())))
+}
+
+// gcModule declares a rec group, struct and array types with subtyping and reads a struct field.
+var gcModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x1d, 0x03, // type
+	0x4e, 0x02, // rec group of 2 types
+	0x50, 0x00, 0x5f, 0x01, 0x63, 0x01, 0x01, // sub struct (field (mut (ref null 1)))
+	0x5e, 0x78, 0x01, // array (mut i8)
+	0x4f, 0x01, 0x00, 0x5f, 0x02, 0x63, 0x01, 0x01, 0x7f, 0x00, // sub final 0 struct (field (mut (ref null 1))) (field i32)
+	0x60, 0x01, 0x64, 0x00, 0x01, 0x7f, // func (param (ref 0)) (result i32)
+	0x03, 0x02, 0x01, 0x03, // function
+	0x09, 0x05, 0x01, 0x03, 0x00, 0x01, 0x00, // declarative element segment
+	0x0a, 0x14, 0x01, 0x12, 0x00, // code
+	0x02, 0x7f, // block (result i32)
+	0x41, 0x00, // i32.const 0
+	0x20, 0x00, // get_local 0
+	0xfb, 0x02, 0x00, 0x00, // struct.get 0 0
+	0xd4, 0x00, // br_on_null 0
+	0xfb, 0x0f, // array.len
+	0x6a, // i32.add
+	0x0b, // end
+	0x0b,
+}
+
+func TestGCTypes(t *testing.T) {
+	jsonObj := Wasm2Json(gcModule)
+	refField := FieldType{Type: "(ref null 1)", Mutability: 1}
+	assert.Equal(t, []TypeEntry{
+		{Form: "struct", Fields: []FieldType{refField}, Sub: "sub", RecGroup: 2},
+		{Form: "array", Fields: []FieldType{{Type: "i8", Mutability: 1}}},
+		{Form: "struct", Fields: []FieldType{refField, {Type: "i32"}}, Sub: "sub_final", Supertypes: []uint32{0}},
+		{Form: "func", Params: []string{"(ref 0)"}, ReturnType: "i32"},
+	}, jsonObj[1]["entries"])
+	assert.Equal(t, []ElementEntry{{Flags: 3, Elements: []uint64{0}}}, jsonObj[3]["entries"])
+
+	code := jsonObj[4]["entries"].([]CodeBody)[0].Code
+	assert.Equal(t, OP{Name: "get", ReturnType: "struct", Immediates: JSON{"type": uint32(0), "field": uint32(0)}}, code[3])
+	assert.Equal(t, OP{Name: "br_on_null", Immediates: uint32(0)}, code[4])
+	assert.Equal(t, OP{Name: "len", ReturnType: "array"}, code[5])
+	assert.Equal(t, 0, bytes.Compare(gcModule, Json2Wasm(jsonObj)))
+}
+
+func TestReferenceOps(t *testing.T) {
+	tests := []struct {
+		op  OP
+		buf []byte
+	}{
+		{OP{Name: "null", ReturnType: "ref", Immediates: "2"}, []byte{0xd0, 0x02}},
+		{OP{Name: "cast_null", ReturnType: "ref", Immediates: "eq"}, []byte{0xfb, 0x17, 0x6d}},
+		{OP{Name: "br_on_cast", Immediates: JSON{"flags": byte(3), "label": uint32(0), "from": "any", "to": "1"}}, []byte{0xfb, 0x18, 0x03, 0x00, 0x6e, 0x01}},
+		{OP{Name: "select_t", Immediates: []string{"externref"}}, []byte{0x1c, 0x01, 0x6f}},
+		{OP{Name: "block", Immediates: "(type 3)"}, []byte{0x02, 0x03}},
+		{OP{Name: "block", Immediates: "(ref null func)"}, []byte{0x02, 0x63, 0x70}},
+		{OP{Name: "new_fixed", ReturnType: "array", Immediates: JSON{"type": uint32(1), "size": uint32(4)}}, []byte{0xfb, 0x08, 0x01, 0x04}},
+	}
+	for _, tt := range tests {
+		stream := GenerateOP(tt.op, nil)
+		assert.Equal(t, tt.buf, stream.Bytes())
+		assert.Equal(t, tt.op, ParseOp(NewStream(tt.buf)))
+	}
+}
+
+func TestElementSegmentFlags(t *testing.T) {
+	entries := []ElementEntry{
+		{Offset: []OP{{Name: "const", ReturnType: "i32", Immediates: int32(0)}}, Elements: []uint64{1}},
+		{Flags: 1, Elements: []uint64{0, 1}},
+		{Flags: 2, Index: 1, Offset: []OP{{Name: "const", ReturnType: "i32", Immediates: int32(0)}}, Elements: []uint64{0}},
+		{Flags: 5, Type: "anyFunc", Exprs: [][]OP{{{Name: "func", ReturnType: "ref", Immediates: uint32(0)}}, {{Name: "null", ReturnType: "ref", Immediates: "func"}}}},
+	}
+	payload := NewStream(nil)
+	for _, entry := range entries {
+		entryGen.Element(entry, payload)
+	}
+	stream := NewStream(nil)
+	EncodeULEB128(uint64(len(entries)), stream)
+	stream.Write(payload.Bytes())
+	assert.Equal(t, entries, secParsers.Element(stream).Entries)
+}
+
+func TestLinkingSections(t *testing.T) {
+	wasm := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // type
+		0x02, 0x09, 0x01, 0x03, 0x65, 0x6e, 0x76, 0x01, 0x66, 0x00, 0x00, // import env.f
+		0x03, 0x02, 0x01, 0x00, // function
+		0x0a, 0x0a, 0x01, 0x08, 0x00, // code
+		0x10, 0x80, 0x80, 0x80, 0x80, 0x00, // call 0, padded
+		0x0b,
+		0x00, 0x14, 0x07, 0x6c, 0x69, 0x6e, 0x6b, 0x69, 0x6e, 0x67, 0x02, // linking
+		0x08, 0x09, 0x02, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0x01, 0x67, // symbols: env.f, g
+		0x00, 0x10, 0x0a, 0x72, 0x65, 0x6c, 0x6f, 0x63, 0x2e, 0x43, 0x4f, 0x44, 0x45, // reloc.CODE
+		0x03, 0x01, 0x00, 0x04, 0x00, // the index of call 0
+	}
+
+	module := Wasm2Json(wasm)
+	assert.True(t, IsRelocatable(module))
+	// the padded index is kept so that the relocation still applies.
+	assert.Equal(t, wasm, Json2Wasm(module))
+
+	code := module[4]["entries"].([]CodeBody)
+	assert.Equal(t, OP{Name: "call", Immediates: uint32(0), Widths: []byte{5}}, code[0].Code[0])
+	assert.Equal(t, []FuncOffsets{{Start: 1, Ops: []uint32{3, 9}, End: 10}}, CodeOffsets(code))
+
+	payload := module[5]["payload"].(string)
+	linking := ParseLinking(payload)
+	assert.Equal(t, uint32(2), linking.Version)
+	assert.Equal(t, []Symbol{
+		{Kind: "function", Flags: SYMBOL_UNDEFINED},
+		{Kind: "function", Index: 1, Name: "g"},
+	}, linking.Symbols)
+	assert.Equal(t, payload, GenerateLinking(linking))
+
+	payload = module[6]["payload"].(string)
+	reloc := ParseReloc(payload)
+	assert.Equal(t, RelocSec{Section: 3, Entries: []Relocation{{Type: "R_WASM_FUNCTION_INDEX_LEB", Offset: 4}}}, reloc)
+	assert.Equal(t, payload, GenerateReloc(reloc))
+}
+
+func TestRewriteSourceMap(t *testing.T) {
+	binaryMap := &BinaryMap{
+		Code: &CodeMap{
+			Old:   []FuncOffsets{{Start: 1, Ops: []uint32{3, 5, 6}, End: 7}},
+			New:   []FuncOffsets{{Start: 1, Ops: []uint32{3, 6, 11, 13, 14}, End: 15}},
+			OpMap: [][]int{{2, 3, 4}},
+		},
+		OldCode: 20,
+		NewCode: 30,
+	}
+
+	// the mapping before the code is removed, the other ones follow their operators.
+	sourceMap, err := RewriteSourceMap([]byte(`{"version":3,"sources":["a.ts"],"names":[],"mappings":"KAAA,kBACA,EACA"}`), binaryMap)
+	assert.Nil(t, err)
+	var obj map[string]interface{}
+	assert.Nil(t, json.Unmarshal(sourceMap, &obj))
+	assert.Equal(t, "yCACA,EACA", obj["mappings"])
+	assert.Equal(t, []interface{}{"a.ts"}, obj["sources"])
+
+	module := []JSON{{"name": "preramble"}}
+	_, exist := SourceMappingURL(module)
+	assert.False(t, exist)
+	module = SetSourceMappingURL(module, "a.wasm.map")
+	module = SetSourceMappingURL(module, "b.wasm.map")
+	assert.Len(t, module, 2)
+	url, _ := SourceMappingURL(module)
+	assert.Equal(t, "b.wasm.map", url)
+}
+
+func TestCodeOffsets(t *testing.T) {
+	dirName := path.Join("test", "wasm")
+	dir, err := ioutil.ReadDir(dirName)
+	assert.Nil(t, err)
+	for _, fi := range dir {
+		if fi.IsDir() {
+			continue
+		}
+		wasm, err := ioutil.ReadFile(path.Join(dirName, fi.Name()))
+		assert.Nil(t, err)
+
+		// the offsets recorded while decoding are the ones of the encoding.
+		module, decoded := Wasm2JsonOffsets(wasm)
+		generated, encoded := Json2WasmOffsets(module)
+		assert.Equal(t, wasm, generated)
+		assert.Equal(t, decoded, encoded, fi.Name())
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder(nil)
+	typ := b.FuncType([]string{"i64"}, "")
+	assert.Equal(t, typ, b.FuncType([]string{"i64"}, ""))
+	use, err := b.ImportFunction("env", "use", typ)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), use)
+	mem := b.AddMemory(MemLimits{Flags: 1, Intial: 1, Maximum: uint64(1)})
+	assert.Equal(t, uint32(0), mem)
+
+	code, err := NewEmitter().
+		Block(BLOCK_TYPE_EMPTY, func(e *Emitter) {
+			e.GetLocal(0).I64Const(0).Instr("i64", "eq").BrIf(0)
+			e.GetLocal(0).Call(use)
+		}).
+		I32Const(0).I64Const(7).Store("i64", "store", 3, 0).
+		Code()
+	assert.Nil(t, err)
+	run := b.AddFunction(typ, nil, code)
+	assert.Equal(t, uint32(1), run)
+	assert.Nil(t, b.AddExport("run", "function", run))
+	assert.NotNil(t, b.AddExport("run", "function", run))
+
+	// the imports precede the defined functions.
+	_, err = b.ImportFunction("env", "late", typ)
+	assert.NotNil(t, err)
+
+	module := b.Module()
+	assert.Nil(t, ValidateModule(module))
+	wasm := Json2Wasm(module)
+	assert.Equal(t, wasm, Json2Wasm(Wasm2Json(wasm)))
+
+	// the control flow is structured.
+	_, err = NewEmitter().Op(OP{Name: "else"}).Code()
+	assert.NotNil(t, err)
+	_, err = NewEmitter().Op(OP{Name: "block", Immediates: BLOCK_TYPE_EMPTY}).Code()
+	assert.NotNil(t, err)
+	_, err = NewEmitter().Loop(BLOCK_TYPE_EMPTY, func(e *Emitter) { e.Br(2) }).Code()
+	assert.NotNil(t, err)
+	ops := NewEmitter().If(BLOCK_TYPE_EMPTY, func(e *Emitter) { e.Nop() }, func(e *Emitter) { e.Br(1) }).Ops()
+	assert.Len(t, ops, 5)
+
+	// delegate ends a try, the catch clauses are in a try.
+	try := OP{Name: "try", Immediates: BLOCK_TYPE_EMPTY}
+	code, err = NewEmitter().Op(try).Op(try).Nop().Op(OP{Name: "delegate", Immediates: uint32(1)}).
+		Op(OP{Name: "catch", Immediates: uint32(0)}).Op(OP{Name: "catch_all"}).Op(OP{Name: "end"}).Code()
+	assert.Nil(t, err)
+	assert.Len(t, code, 8)
+	_, err = NewEmitter().Op(try).Op(OP{Name: "delegate", Immediates: uint32(2)}).Code()
+	assert.EqualError(t, err, "op 1: branch to depth 2 out of 0 blocks")
+	_, err = NewEmitter().Block(BLOCK_TYPE_EMPTY, func(e *Emitter) { e.Op(OP{Name: "catch_all"}) }).Code()
+	assert.EqualError(t, err, "op 1: catch_all out of try")
+	_, err = NewEmitter().Op(try).Op(OP{Name: "catch_all"}).Op(OP{Name: "catch", Immediates: uint32(0)}).Code()
+	assert.EqualError(t, err, "op 2: catch out of try")
+	_, err = NewEmitter().Op(try).Op(OP{Name: "catch_all"}).Op(OP{Name: "delegate", Immediates: uint32(0)}).Code()
+	assert.EqualError(t, err, "op 2: delegate out of try")
+}
+
+func TestIndexSpace(t *testing.T) {
+	b := NewBuilder(nil)
+	typ := b.FuncType(nil, "")
+	_, err := b.ImportFunction("env", "f", typ)
+	assert.Nil(t, err)
+	g := b.AddGlobal(Global{ContentType: "i32", Mutability: 1}, NewEmitter().I32Const(0).Ops())
+	b.AddMemory(MemLimits{Intial: 1})
+	code, _ := NewEmitter().Call(0).Call(2).GetGlobal(g).I32Const(0).Load("i32", "load", 2, 0).Instr("i32", "add").SetGlobal(g).Code()
+	caller := b.AddFunction(typ, nil, code)
+	code, _ = NewEmitter().Code()
+	callee := b.AddFunction(typ, nil, code)
+	assert.Nil(t, b.AddExport("caller", "function", caller))
+	module := append(b.Module(), JSON{"name": "start", "index": callee})
+
+	names := NewStream(nil)
+	sub := NewStream(nil)
+	writeNameMap([]nameAssoc{{index: 0, name: "f"}, {index: 1, name: "caller"}, {index: 2, name: "callee"}}, false, sub)
+	names.WriteByte(1)
+	EncodeULEB128(uint64(sub.Len()), names)
+	names.Write(sub.Bytes())
+	module = append(module, JSON{"name": "custom", "section_name": "name", "payload": names.String()})
+
+	space := NewIndexSpace(module)
+	index, m, err := space.AddImport(ImportEntry{ModuleStr: "env", FieldStr: "g", Kind: "function", Type: uint64(typ)})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), index)
+	assert.Equal(t, IndexMap{0, 2, 3}, m)
+	module = space.Module()
+	assert.Nil(t, ValidateModule(module))
+
+	code = module[findSectionByName(module, "code")]["entries"].([]CodeBody)[0].Code
+	assert.Equal(t, uint32(0), code[0].Immediates)
+	assert.Equal(t, uint32(3), code[1].Immediates)
+	assert.Equal(t, uint32(2), module[findSectionByName(module, "export")]["entries"].([]ExportEntry)[0].Index)
+	assert.Equal(t, uint32(3), module[findSectionByName(module, "start")]["index"])
+	payload := NewStream([]byte(module[findCustomSection(module, "name")]["payload"].(string)))
+	payload.Read(2)
+	assert.Equal(t, []nameAssoc{{index: 0, name: "f"}, {index: 2, name: "caller"}, {index: 3, name: "callee"}}, readNameMap(payload, false))
+
+	// the globals and the memories are renumbered in the operators.
+	_, err = space.Insert("global", 0, GlobalEntry{Type: Global{ContentType: "i64"}, Init: NewEmitter().I64Const(1).Ops()})
+	assert.Nil(t, err)
+	_, err = space.Insert("memory", 0, MemLimits{Intial: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), code[2].Immediates)
+	assert.Equal(t, uint32(1), code[6].Immediates)
+	assert.Equal(t, JSON{"flags": uint64(2 | MEMORY_FLAG_INDEX), "offset": uint64(0), "memory": uint32(1)}, code[4].Immediates)
+
+	// the referenced functions can't be removed.
+	_, err = space.Remove("function", 3)
+	assert.NotNil(t, err)
+	_, err = space.Remove("function", 1)
+	assert.Nil(t, err)
+	module = space.Module()
+	assert.Equal(t, 1, len(space.imports()))
+	assert.Nil(t, ValidateModule(module))
+	wasm := Json2Wasm(module)
+	assert.Equal(t, wasm, Json2Wasm(Wasm2Json(wasm)))
+}
+
+func TestLink(t *testing.T) {
+	// the library exports its memory and add, add calls an internal helper and the imported print.
+	lib := NewBuilder(nil)
+	unary := lib.FuncType([]string{"i32"}, "")
+	binary := lib.FuncType([]string{"i32", "i32"}, "i32")
+	print, err := lib.ImportFunction("env", "print", unary)
+	assert.Nil(t, err)
+	memory := lib.AddMemory(MemLimits{Intial: 1})
+	code, _ := NewEmitter().GetLocal(0).Call(print).Op(OP{ReturnType: "data", Name: "drop", Immediates: uint32(0)}).Code()
+	helper := lib.AddFunction(unary, nil, code)
+	code, _ = NewEmitter().GetLocal(0).Call(helper).GetLocal(0).Load("i32", "load", 2, 0).GetLocal(1).Instr("i32", "add").Code()
+	add := lib.AddFunction(binary, nil, code)
+	assert.Nil(t, lib.AddExport("add", "function", add))
+	assert.Nil(t, lib.AddExport("mem", "memory", memory))
+	libModule := lib.Module()
+	libModule = append(libModule[:len(libModule)-1], JSON{"name": "datacount", "count": uint32(1)}, libModule[len(libModule)-1],
+		JSON{"name": "data", "entries": []DataSegment{{Flags: DATA_FLAG_PASSIVE, Data: []byte("lib")}}})
+	assert.Nil(t, ValidateModule(libModule))
+
+	main := NewBuilder(nil)
+	void := main.FuncType(nil, "")
+	binary = main.FuncType([]string{"i32", "i32"}, "i32")
+	unary = main.FuncType([]string{"i32"}, "")
+	print, err = main.ImportFunction("env", "print", unary)
+	assert.Nil(t, err)
+	add, err = main.ImportFunction("lib", "add", binary)
+	assert.Nil(t, err)
+	_, err = main.ImportMemory("lib", "mem", MemLimits{Intial: 1})
+	assert.Nil(t, err)
+	code, _ = NewEmitter().I32Const(1).I32Const(2).Call(add).Call(print).Code()
+	run := main.AddFunction(void, nil, code)
+	assert.Nil(t, main.AddExport("run", "function", run))
+	mainModule := append(main.Module(), JSON{"name": "data", "entries": []DataSegment{{Offset: NewEmitter().I32Const(0).Ops(), Data: []byte("main")}}})
+	assert.Nil(t, ValidateModule(mainModule))
+
+	module, err := Link(mainModule, libModule, "lib")
+	assert.Nil(t, err)
+	assert.Nil(t, ValidateModule(module))
+	space := NewIndexSpace(module)
+	assert.Equal(t, []ImportEntry{{ModuleStr: "env", FieldStr: "print", Kind: "function", Type: uint64(unary)}}, space.imports())
+	imported, defined := space.Len("function")
+	assert.Equal(t, []uint32{1, 3}, []uint32{imported, defined})
+	imported, defined = space.Len("memory")
+	assert.Equal(t, []uint32{0, 1}, []uint32{imported, defined})
+	assert.Len(t, space.section("type")["entries"], 3)
+	assert.Equal(t, []ExportEntry{{FieldStr: "run", Kind: "function", Index: 1}}, space.section("export")["entries"])
+
+	bodies := space.section("code")["entries"].([]CodeBody)
+	assert.Equal(t, uint32(3), bodies[0].Code[2].Immediates)
+	assert.Equal(t, uint32(0), bodies[1].Code[1].Immediates)
+	assert.Equal(t, uint32(1), bodies[1].Code[2].Immediates)
+	assert.Equal(t, uint32(2), bodies[2].Code[1].Immediates)
+	assert.Equal(t, uint32(2), space.section("datacount")["count"])
+
+	// the modules are left as they are.
+	assert.Len(t, NewIndexSpace(mainModule).imports(), 3)
+
+	// the errors.
+	_, err = Link(mainModule, libModule, "env")
+	assert.Contains(t, err.Error(), "env.print: the library doesn't export it")
+	other := NewBuilder(nil)
+	_, err = other.ImportFunction("lib", "add", other.FuncType([]string{"i32"}, "i32"))
+	assert.Nil(t, err)
+	_, err = Link(other.Module(), libModule, "lib")
+	assert.Contains(t, err.Error(), "import lib.add: the function of the library has another type")
+	other = NewBuilder(nil)
+	other.AddMemory(MemLimits{Intial: 1})
+	_, err = Link(other.Module(), libModule, "lib")
+	assert.Contains(t, err.Error(), "memory 0 of the library clashes with memory 0 of the module")
+
+	// the memories must have the same address type and sharing.
+	for _, limits := range []MemLimits{
+		{Flags: LIMITS_FLAG_INDEX64, Intial: 1},
+		{Flags: LIMITS_FLAG_SHARED, Intial: 1, Maximum: uint64(1)},
+	} {
+		other = NewBuilder(nil)
+		_, err = other.ImportMemory("lib", "mem", limits)
+		assert.Nil(t, err)
+		_, err = Link(other.Module(), libModule, "lib")
+		assert.Contains(t, err.Error(), "import lib.mem: the memory of the library has another type")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	build := func(metered bool) []JSON {
+		b := NewBuilder(nil)
+		binary := b.FuncType([]string{"i32", "i32"}, "i32")
+		_, err := b.ImportFunction("env", "print", b.FuncType([]string{"i32"}, ""))
+		assert.Nil(t, err)
+		e := NewEmitter()
+		if metered {
+			_, err = b.ImportFunction("metering", "usegas", b.FuncType([]string{"i64"}, ""))
+			assert.Nil(t, err)
+			e.I64Const(3)
+			e.Call(1)
+		}
+		code, _ := e.GetLocal(0).GetLocal(1).Instr("i32", "add").Code()
+		add := b.AddFunction(binary, nil, code)
+		assert.Nil(t, b.AddExport("add", "function", add))
+		if !metered {
+			assert.Nil(t, b.AddExport("print", "function", 0))
+		}
+		return b.Module()
+	}
+
+	d := Diff(build(false), build(false))
+	assert.True(t, d.Empty())
+
+	d = Diff(build(false), build(true))
+	assert.Equal(t, []Change{
+		{Section: "type", Kind: DIFF_ADDED, Entry: "type 2", New: "func (param i64)"},
+		{Section: "import", Kind: DIFF_ADDED, Entry: "metering.usegas", New: "func (param i64)"},
+		{Section: "export", Kind: DIFF_CHANGED, Entry: "add", Old: "function 1", New: "function 2"},
+		{Section: "export", Kind: DIFF_REMOVED, Entry: "print", Old: "function 0"},
+	}, d.Changes)
+	assert.Equal(t, []FuncDiff{{Index: 1, NewIndex: 2, Runs: []OpRun{
+		{Kind: DIFF_ADDED, Count: 2, Ops: []string{"i64.const 3", "call 1"}},
+		{Kind: DIFF_KEPT, Count: 4},
+	}}}, d.Functions)
+	assert.Equal(t, `type: + type 2: func (param i64)
+import: + metering.usegas: func (param i64)
+export: ~ add: function 1 -> function 2
+export: - print: function 0
+function 1 -> 2:
+  + i64.const 3
+  + call 1
+  = 4 ops
+`, d.String())
+
+	out, err := d.JSON()
+	assert.Nil(t, err)
+	var decoded ModuleDiff
+	assert.Nil(t, json.Unmarshal(out, &decoded))
+	assert.Equal(t, *d, decoded)
+}
+
+func TestAnalyze(t *testing.T) {
+	b := NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	_, err := b.ImportFunction("env", "f", void)
+	assert.Nil(t, err)
+	b.AddMemory(MemLimits{Flags: 1, Intial: 1, Maximum: uint64(2)})
+	code, _ := NewEmitter().
+		Block(BLOCK_TYPE_EMPTY, func(e *Emitter) {
+			e.Loop(BLOCK_TYPE_EMPTY, func(e *Emitter) {
+				e.GetLocal(0).GetLocal(0).Instr("i32", "add").BrIf(1)
+			})
+		}).
+		Call(0).
+		Code()
+	b.AddFunction(void, []LocalEntry{{Count: 2, Type: "i32"}}, code)
+	module := append(b.Module(), JSON{"name": "custom", "section_name": "producers", "payload": "clang"})
+	wasm := Json2Wasm(module)
+
+	s, err := Analyze(wasm)
+	assert.Nil(t, err)
+	assert.Equal(t, len(wasm), s.Size)
+	size := 8
+	for _, n := range s.Sections {
+		size += n
+	}
+	assert.Equal(t, len(wasm), size)
+	assert.Equal(t, 1+1+len("producers")+1+len("clang"), s.Sections["custom:producers"])
+	assert.Equal(t, []FuncStats{{Index: 1, Size: 20, Ops: 10, Locals: 2, Depth: 2}}, s.Functions)
+	assert.Equal(t, 2, s.Opcodes["get_local"])
+	assert.Equal(t, 1, s.Opcodes["i32.add"])
+	assert.Equal(t, 2, s.MaxDepth)
+	maximum := uint64(2)
+	assert.Equal(t, []LimitsStats{{Initial: 1, Maximum: &maximum}}, s.Memories)
+
+	// the stats of a corpus.
+	s.Add(s)
+	assert.Equal(t, 2, s.Modules)
+	assert.Equal(t, 4, s.Opcodes["get_local"])
+	assert.Len(t, s.Functions, 2)
+	out, err := s.JSON()
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"i32.add": 2`)
+
+	_, err = Analyze([]byte("wasm"))
+	assert.NotNil(t, err)
+}
+
+func TestBuilderData(t *testing.T) {
+	b := NewBuilder(nil)
+	b.AddMemory(MemLimits{Intial: 1})
+	memory := b.AddMemory(MemLimits{Intial: 1})
+	b.AddData(0, NewEmitter().I32Const(0).Ops(), []byte("a"))
+	b.AddData(memory, NewEmitter().I32Const(8).Ops(), []byte("b"))
+	module := Wasm2Json(Json2Wasm(b.Module()))
+	segments := module[2]["entries"].([]DataSegment)
+	assert.Equal(t, uint32(0), segments[0].Index)
+	assert.Equal(t, memory, segments[1].Index)
+	assert.Equal(t, []byte("b"), segments[1].Data)
+
+	// the encoding adds the index flag a segment of another memory needs.
+	module = NewBuilder(nil).Module()
+	module = append(module, JSON{"name": "memory", "entries": []MemLimits{{Intial: 1}, {Intial: 1}}},
+		JSON{"name": "data", "entries": []DataSegment{{Index: 1, Offset: NewEmitter().I32Const(8).Ops(), Data: []byte("c")}}})
+	segments = Wasm2Json(Json2Wasm(module))[2]["entries"].([]DataSegment)
+	assert.Equal(t, []DataSegment{{Flags: DATA_FLAG_INDEX, Index: 1, Offset: NewEmitter().I32Const(8).Ops(), Data: []byte("c")}}, segments)
+}
+
+func TestCallIndirectTableIndex(t *testing.T) {
+	b := NewBuilder(nil)
+	void := b.FuncType(nil, "")
+	var table uint32
+	for i := 0; i <= 300; i++ {
+		table = b.AddTable(Table{ElementType: "anyFunc", Limits: MemLimits{Intial: 1}})
+	}
+	code, _ := NewEmitter().I32Const(0).CallIndirect(void, table).Code()
+	b.AddFunction(void, nil, code)
+	assert.Nil(t, ValidateModule(b.Module()))
+
+	module := Wasm2Json(Json2Wasm(b.Module()))
+	body := module[len(module)-1]["entries"].([]CodeBody)[0]
+	assert.Equal(t, uint32(300), body.Code[1].Immediates.(JSON)["reserved"])
+	code, _ = NewEmitter().I32Const(0).CallIndirect(void, 301).Code()
+	module[len(module)-1]["entries"].([]CodeBody)[0].Code = code
+	assert.NotNil(t, ValidateModule(module))
+}